@@ -2,6 +2,7 @@ package agent
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/influxdata/telegraf"
@@ -15,13 +16,28 @@ var (
 
 type MetricMaker interface {
 	Name() string
+	// LogName is the name of the plugin instance in the logs.
+	LogName() string
 	MakeMetric(metric telegraf.Metric) telegraf.Metric
 }
 
+// errorCounter is implemented by the makers counting their own errors.
+type errorCounter interface {
+	IncrErrors()
+}
+
+// metricProcessor is implemented by the makers with processors of their
+// own, applied to the metrics they make.
+type metricProcessor interface {
+	Process(metric telegraf.Metric) []telegraf.Metric
+}
+
 type accumulator struct {
 	maker     MetricMaker
 	metrics   chan<- telegraf.Metric
 	precision time.Duration
+	// done, when closed, drops the metrics waiting to be sent.
+	done <-chan struct{}
 }
 
 func NewAccumulator(
@@ -83,9 +99,7 @@ func (ac *accumulator) AddHistogram(
 
 func (ac *accumulator) AddMetric(m telegraf.Metric) {
 	m.SetTime(m.Time().Round(ac.precision))
-	if m := ac.maker.MakeMetric(m); m != nil {
-		ac.metrics <- m
-	}
+	ac.addMetric(m)
 }
 
 func (ac *accumulator) addFields(
@@ -99,11 +113,44 @@ func (ac *accumulator) addFields(
 	if err != nil {
 		return
 	}
-	if m := ac.maker.MakeMetric(m); m != nil {
-		ac.metrics <- m
+	ac.addMetric(m)
+}
+
+// addMetric makes the metric and sends it, through the processors of the
+// maker if any.
+func (ac *accumulator) addMetric(m telegraf.Metric) {
+	m = ac.maker.MakeMetric(m)
+	if m == nil {
+		return
+	}
+	p, ok := ac.maker.(metricProcessor)
+	if !ok {
+		ac.send(m)
+		return
+	}
+	for _, m := range p.Process(m) {
+		ac.send(m)
+	}
+}
+
+// send sends the metric, unless done is closed while the channel is full.
+// The metrics not sent are rejected, they were not written.
+func (ac *accumulator) send(m telegraf.Metric) {
+	select {
+	case ac.metrics <- m:
+	case <-ac.done:
+		m.Reject()
 	}
 }
 
+// withDone returns a copy of the accumulator dropping the metrics waiting to
+// be sent once done is closed.
+func (ac *accumulator) withDone(done <-chan struct{}) *accumulator {
+	c := *ac
+	c.done = done
+	return &c
+}
+
 // AddError passes a runtime error to the accumulator.
 // The error will be tagged with the plugin name and written to the log.
 func (ac *accumulator) AddError(err error) {
@@ -111,7 +158,10 @@ func (ac *accumulator) AddError(err error) {
 		return
 	}
 	NErrors.Incr(1)
-	log.Printf("E! [%s]: Error in plugin: %v", ac.maker.Name(), err)
+	if ec, ok := ac.maker.(errorCounter); ok {
+		ec.IncrErrors()
+	}
+	log.Printf("E! [%s]: Error in plugin: %v", ac.maker.LogName(), err)
 }
 
 func (ac *accumulator) SetPrecision(precision, interval time.Duration) {
@@ -180,3 +230,108 @@ func (a *trackingAccumulator) onDelivery(info telegraf.DeliveryInfo) {
 		panic("channel is full")
 	}
 }
+
+// gatherAccumulator passes the metrics of a gather to the accumulator until
+// the gather is abandoned. The metrics added afterwards are dropped, so that an
+// abandoned gather never writes to the channel of a stopped agent.
+type gatherAccumulator struct {
+	telegraf.Accumulator
+
+	// done is closed when the gather is abandoned, it interrupts the metrics
+	// waiting for room in the channel.
+	done chan struct{}
+
+	mu        sync.RWMutex
+	abandoned bool
+}
+
+func newGatherAccumulator(acc telegraf.Accumulator) *gatherAccumulator {
+	done := make(chan struct{})
+	if ac, ok := acc.(*accumulator); ok {
+		acc = ac.withDone(done)
+	}
+	return &gatherAccumulator{
+		Accumulator: acc,
+		done:        done,
+	}
+}
+
+// add runs add unless the gather was abandoned.
+func (ga *gatherAccumulator) add(add func()) bool {
+	ga.mu.RLock()
+	defer ga.mu.RUnlock()
+	if ga.abandoned {
+		return false
+	}
+	add()
+	return true
+}
+
+// abandon drops the metrics added from now on. The metrics being added are
+// dropped if the channel is full, and abandon waits for them to return.
+func (ga *gatherAccumulator) abandon() {
+	close(ga.done)
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	ga.abandoned = true
+}
+
+func (ga *gatherAccumulator) AddFields(
+	measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	ga.add(func() { ga.Accumulator.AddFields(measurement, fields, tags, t...) })
+}
+
+func (ga *gatherAccumulator) AddGauge(
+	measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	ga.add(func() { ga.Accumulator.AddGauge(measurement, fields, tags, t...) })
+}
+
+func (ga *gatherAccumulator) AddCounter(
+	measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	ga.add(func() { ga.Accumulator.AddCounter(measurement, fields, tags, t...) })
+}
+
+func (ga *gatherAccumulator) AddSummary(
+	measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	ga.add(func() { ga.Accumulator.AddSummary(measurement, fields, tags, t...) })
+}
+
+func (ga *gatherAccumulator) AddHistogram(
+	measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	ga.add(func() { ga.Accumulator.AddHistogram(measurement, fields, tags, t...) })
+}
+
+func (ga *gatherAccumulator) AddMetric(m telegraf.Metric) {
+	if !ga.add(func() { ga.Accumulator.AddMetric(m) }) {
+		// Tracked metrics are reported undelivered, so that they are sent
+		// again by the next gather.
+		m.Reject()
+	}
+}
+
+func (ga *gatherAccumulator) WithTracking(maxTracked int) telegraf.TrackingAccumulator {
+	return &trackingAccumulator{
+		Accumulator: ga,
+		delivered:   make(chan telegraf.DeliveryInfo, maxTracked),
+	}
+}
@@ -141,6 +141,36 @@ func TestAddTrackingMetricGroupEmpty(t *testing.T) {
 	}
 }
 
+func TestAddFieldsProcessed(t *testing.T) {
+	metrics := make(chan telegraf.Metric, 10)
+	defer close(metrics)
+	a := NewAccumulator(&processingMetricMaker{}, metrics)
+
+	a.AddFields("acctest", map[string]interface{}{"usage": float64(99)}, nil)
+	a.AddFields("dropped", map[string]interface{}{"usage": float64(99)}, nil)
+
+	require.Len(t, metrics, 2)
+	for _, name := range []string{"acctest", "acctest_copy"} {
+		m := <-metrics
+		require.Equal(t, name, m.Name())
+	}
+}
+
+// processingMetricMaker drops the metrics named dropped, and copies the
+// others.
+type processingMetricMaker struct {
+	TestMetricMaker
+}
+
+func (pm *processingMetricMaker) Process(metric telegraf.Metric) []telegraf.Metric {
+	if metric.Name() == "dropped" {
+		return nil
+	}
+	m := metric.Copy()
+	m.SetName(metric.Name() + "_copy")
+	return []telegraf.Metric{metric, m}
+}
+
 type TestMetricMaker struct {
 }
 
@@ -148,6 +178,10 @@ func (tm *TestMetricMaker) Name() string {
 	return "TestPlugin"
 }
 
+func (tm *TestMetricMaker) LogName() string {
+	return tm.Name()
+}
+
 func (tm *TestMetricMaker) MakeMetric(metric telegraf.Metric) telegraf.Metric {
 	return metric
 }
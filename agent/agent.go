@@ -3,8 +3,10 @@ package agent
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"runtime"
+	"sort"
 	"sync"
 	"time"
 
@@ -12,18 +14,64 @@ import (
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/internal/config"
 	"github.com/influxdata/telegraf/internal/models"
-	"github.com/influxdata/telegraf/plugins/serializers/influx"
+	"github.com/influxdata/telegraf/plugins/serializers"
+)
+
+var (
+	// Delay before the first reconnection attempt of an output, doubled
+	// after each failure up to maxReconnectDelay.
+	reconnectDelay    = 5 * time.Second
+	maxReconnectDelay = 5 * time.Minute
 )
 
 // Agent runs a set of plugins.
 type Agent struct {
 	Config *config.Config
+
+	// mu serializes the reloads and guards the plugin tasks and the plugin
+	// lists of Config while the agent runs.
+	mu sync.Mutex
+	// pmu guards the processors, aggregators and outputs read for every
+	// metric, a reload only holds it to swap them.
+	pmu sync.RWMutex
+
+	// ctx is the context of the running agent, nil until it runs.
+	ctx       context.Context
+	inputDst  chan<- telegraf.Metric
+	aggCtx    context.Context
+	aggDst    chan<- telegraf.Metric
+	outputCtx context.Context
+	router    *router
+
+	// Tasks of the running plugin instances
+	inputs      map[*models.RunningInput]*task
+	aggregators map[*models.RunningAggregator]*task
+	outputs     map[*models.RunningOutput]*task
+}
+
+// task is a plugin instance running in its own goroutines.
+type task struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// flush receives the requests to flush an output now, the result of
+	// the write is sent on the request.
+	flush chan chan error
+}
+
+// stop cancels the task and waits for its goroutines to return.
+func (t *task) stop() {
+	t.cancel()
+	t.wg.Wait()
 }
 
 // NewAgent returns an Agent for the given Config.
 func NewAgent(config *config.Config) (*Agent, error) {
 	a := &Agent{
-		Config: config,
+		Config:      config,
+		inputs:      make(map[*models.RunningInput]*task),
+		aggregators: make(map[*models.RunningAggregator]*task),
+		outputs:     make(map[*models.RunningOutput]*task),
 	}
 	return a, nil
 }
@@ -39,24 +87,63 @@ func (a *Agent) Run(ctx context.Context) error {
 		return ctx.Err()
 	}
 
-	log.Printf("D! [agent] Connecting outputs")
-	err := a.connectOutputs(ctx)
-	if err != nil {
+	log.Printf("D! [agent] Opening output buffers")
+	for _, output := range a.Config.Outputs {
+		if err := openBuffer(output); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("D! [agent] Starting processors")
+	if err := a.allProcessors().Start(); err != nil {
 		return err
 	}
 
 	inputC := make(chan telegraf.Metric, 100)
 	procC := make(chan telegraf.Metric, 100)
 	outputC := make(chan telegraf.Metric, 100)
+	aggregations := make(chan telegraf.Metric, 100)
+
+	aggCtx, aggCancel := context.WithCancel(context.Background())
+	outputCtx, outputCancel := context.WithCancel(context.Background())
+
+	a.mu.Lock()
+	a.inputDst = inputC
+	a.aggCtx = aggCtx
+	a.aggDst = aggregations
+	a.outputCtx = outputCtx
+	a.router = newRouter(a.Config.Outputs)
 
-	startTime := time.Now()
+	log.Printf("D! [agent] Connecting outputs")
+	a.ctx = ctx
+	for _, output := range a.Config.Outputs {
+		a.startOutput(output)
+	}
 
 	log.Printf("D! [agent] Starting service inputs")
-	err = a.startServiceInputs(ctx, inputC)
+	err := a.startServiceInputs(ctx, inputC)
 	if err != nil {
+		for _, t := range a.outputs {
+			t.stop()
+		}
+		aggCancel()
+		outputCancel()
+		a.allProcessors().Stop()
+		a.mu.Unlock()
 		return err
 	}
 
+	for _, agg := range a.Config.Aggregators {
+		a.startAggregator(agg)
+	}
+	for _, input := range a.Config.Inputs {
+		a.startInput(input)
+	}
+	// A reload cannot add or remove the processor and aggregator stages.
+	hasProcessors := len(a.Config.Processors) > 0
+	hasAggregators := len(a.Config.Aggregators) > 0
+	a.mu.Unlock()
+
 	var wg sync.WaitGroup
 
 	src := inputC
@@ -66,7 +153,7 @@ func (a *Agent) Run(ctx context.Context) error {
 	go func(dst chan telegraf.Metric) {
 		defer wg.Done()
 
-		err := a.runInputs(ctx, startTime, dst)
+		err := a.runInputs(ctx)
 		if err != nil {
 			log.Printf("E! [agent] Error running inputs: %v", err)
 		}
@@ -80,7 +167,7 @@ func (a *Agent) Run(ctx context.Context) error {
 
 	src = dst
 
-	if len(a.Config.Processors) > 0 {
+	if hasProcessors {
 		dst = procC
 
 		wg.Add(1)
@@ -98,14 +185,14 @@ func (a *Agent) Run(ctx context.Context) error {
 		src = dst
 	}
 
-	if len(a.Config.Aggregators) > 0 {
+	if hasAggregators {
 		dst = outputC
 
 		wg.Add(1)
 		go func(src, dst chan telegraf.Metric) {
 			defer wg.Done()
 
-			err := a.runAggregators(startTime, src, dst)
+			err := a.runAggregators(src, dst, aggregations, aggCancel)
 			if err != nil {
 				log.Printf("E! [agent] Error running aggregators: %v", err)
 			}
@@ -114,13 +201,26 @@ func (a *Agent) Run(ctx context.Context) error {
 		}(src, dst)
 
 		src = dst
+	} else {
+		aggCancel()
 	}
 
+	// Outputs holding back the inputs must give way when shutting down.
+	go func() {
+		<-ctx.Done()
+		a.pmu.RLock()
+		outputs := a.Config.Outputs
+		a.pmu.RUnlock()
+		for _, output := range outputs {
+			output.Unblock()
+		}
+	}()
+
 	wg.Add(1)
 	go func(src chan telegraf.Metric) {
 		defer wg.Done()
 
-		err := a.runOutputs(startTime, src)
+		err := a.runOutputs(src, outputCancel)
 		if err != nil {
 			log.Printf("E! [agent] Error running outputs: %v", err)
 		}
@@ -128,6 +228,11 @@ func (a *Agent) Run(ctx context.Context) error {
 
 	wg.Wait()
 
+	log.Printf("D! [agent] Stopping processors")
+	a.mu.Lock()
+	a.allProcessors().Stop()
+	a.mu.Unlock()
+
 	log.Printf("D! [agent] Closing outputs")
 	err = a.closeOutputs()
 	if err != nil {
@@ -138,124 +243,319 @@ func (a *Agent) Run(ctx context.Context) error {
 	return nil
 }
 
-// Test runs the inputs once and prints the output to stdout in line protocol.
-func (a *Agent) Test(ctx context.Context) error {
+// Test runs each input once, and the service inputs for the wait duration,
+// through the processors and aggregators, and prints the resulting metrics
+// to w with the serializer. The metrics are preceded by the name of the
+// plugin instance they come from.
+func (a *Agent) Test(
+	ctx context.Context,
+	wait time.Duration,
+	w io.Writer,
+	serializer serializers.Serializer,
+) error {
+	metrics, err := a.runOnce(ctx, wait)
+	var source string
+	for _, m := range metrics {
+		octets, serr := serializer.Serialize(m.metric)
+		if serr != nil {
+			log.Printf("E! [agent] Could not serialize metric %q: %v", m.metric.Name(), serr)
+			continue
+		}
+		if m.source != source {
+			source = m.source
+			fmt.Fprintf(w, "# %s\n", source)
+		}
+		fmt.Fprint(w, "> ", string(octets))
+	}
+	return err
+}
+
+// Once runs each input once, and the service inputs for the wait duration,
+// through the processors and aggregators, then writes the resulting metrics
+// to the outputs and closes them.
+func (a *Agent) Once(ctx context.Context, wait time.Duration) error {
+	for _, output := range a.Config.Outputs {
+		if err := openBuffer(output); err != nil {
+			return err
+		}
+		if err := output.Connect(); err != nil {
+			return fmt.Errorf("could not connect to output %s: %v", output.LogName(), err)
+		}
+	}
+
+	metrics, err := a.runOnce(ctx, wait)
+
+	// The outputs write their full batches while the metrics are routed,
+	// for outputs blocking on a full buffer to make progress.
+	done := make(chan struct{})
 	var wg sync.WaitGroup
-	metricC := make(chan telegraf.Metric)
-	nulC := make(chan telegraf.Metric)
-	defer func() {
-		close(metricC)
-		close(nulC)
-		wg.Wait()
-	}()
+	for _, output := range a.Config.Outputs {
+		wg.Add(1)
+		go func(output *models.RunningOutput) {
+			defer wg.Done()
+			for {
+				select {
+				case <-output.BatchReady:
+					if err := output.WriteBatch(); err != nil {
+						log.Printf("E! [agent] Error writing to output [%s]: %v",
+							output.LogName(), err)
+					}
+				case <-done:
+					return
+				}
+			}
+		}(output)
+	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	router := newRouter(a.Config.Outputs)
+	for _, m := range metrics {
+		router.route(m.metric)
+	}
+	close(done)
+	wg.Wait()
 
-		s := influx.NewSerializer()
-		s.SetFieldSortOrder(influx.SortFields)
-		for metric := range metricC {
-			octets, err := s.Serialize(metric)
+	for _, output := range a.Config.Outputs {
+		if werr := output.Write(); werr != nil {
+			log.Printf("E! [agent] Error writing to output [%s]: %v", output.LogName(), werr)
 			if err == nil {
-				fmt.Print("> ", string(octets))
-
+				err = werr
 			}
 		}
-	}()
+	}
+	if cerr := a.closeOutputs(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// sourcedMetric is a metric with the name of the plugin instance it comes
+// from.
+type sourcedMetric struct {
+	source string
+	metric telegraf.Metric
+}
+
+// runOnce gathers every input once, letting the service inputs run for the
+// wait duration, and returns the metrics after the processors and a single
+// push of the aggregators. The gather errors are reported and the first one
+// is returned after the other inputs ran.
+func (a *Agent) runOnce(ctx context.Context, wait time.Duration) ([]sourcedMetric, error) {
+	precision := a.Config.Agent.Precision.Duration
+	interval := a.Config.Agent.Interval.Duration
 
+	processors := a.allProcessors()
+	if err := processors.Start(); err != nil {
+		return nil, err
+	}
+	defer processors.Stop()
+
+	now := time.Now()
+	for _, agg := range a.Config.Aggregators {
+		agg.SetPeriodStart(now)
+	}
+
+	var metrics []sourcedMetric
+	var wg sync.WaitGroup
+	inputC := make(chan sourcedMetric, 100)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for range nulC {
+		for m := range inputC {
+			for _, metric := range a.applyProcessors(m.metric) {
+				if dropOriginal := a.aggregate(metric); !dropOriginal {
+					metrics = append(metrics, sourcedMetric{m.source, metric})
+				}
+			}
 		}
 	}()
 
-	for _, input := range a.Config.Inputs {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			if _, ok := input.Input.(telegraf.ServiceInput); ok {
-				log.Printf("W!: [agent] skipping plugin [[%s]]: service inputs not supported in --test mode",
-					input.Name())
-				continue
-			}
+	err := a.gatherAll(ctx, wait, inputC)
+	close(inputC)
+	wg.Wait()
 
-			acc := NewAccumulator(input, metricC)
-			acc.SetPrecision(a.Config.Agent.Precision.Duration,
-				a.Config.Agent.Interval.Duration)
-			input.SetDefaultTags(a.Config.Tags)
-
-			// Special instructions for some inputs. cpu, for example, needs to be
-			// run twice in order to return cpu usage percentages.
-			switch input.Name() {
-			case "inputs.cpu", "inputs.mongodb", "inputs.procstat":
-				nulAcc := NewAccumulator(input, nulC)
-				nulAcc.SetPrecision(a.Config.Agent.Precision.Duration,
-					a.Config.Agent.Interval.Duration)
-				if err := input.Input.Gather(nulAcc); err != nil {
-					return err
-				}
+	// The inputs run concurrently, their metrics are grouped back in the
+	// order of the inputs.
+	order := make(map[string]int, len(a.Config.Inputs))
+	for i := len(a.Config.Inputs) - 1; i >= 0; i-- {
+		order[a.Config.Inputs[i].LogName()] = i
+	}
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return order[metrics[i].source] < order[metrics[j].source]
+	})
 
-				time.Sleep(500 * time.Millisecond)
-				if err := input.Input.Gather(acc); err != nil {
-					return err
-				}
-			default:
-				if err := input.Input.Gather(acc); err != nil {
-					return err
+	for _, agg := range a.Config.Aggregators {
+		aggC := make(chan telegraf.Metric, 100)
+		wg.Add(1)
+		go func(source string) {
+			defer wg.Done()
+			for metric := range aggC {
+				for _, metric := range a.applyProcessors(metric) {
+					metrics = append(metrics, sourcedMetric{source, metric})
 				}
 			}
-		}
+		}(agg.LogName())
+
+		acc := NewAccumulator(agg, aggC)
+		acc.SetPrecision(precision, interval)
+		agg.Push(acc)
+		close(aggC)
+		wg.Wait()
 	}
 
-	return nil
+	return metrics, err
 }
 
-// runInputs starts and triggers the periodic gather for Inputs.
-//
-// When the context is done the timers are stopped and this function returns
-// after all ongoing Gather calls complete.
-func (a *Agent) runInputs(
+// gatherAll gathers every input once, then lets the service inputs run for
+// the wait duration before stopping them.
+func (a *Agent) gatherAll(
 	ctx context.Context,
-	startTime time.Time,
-	dst chan<- telegraf.Metric,
+	wait time.Duration,
+	dst chan<- sourcedMetric,
 ) error {
+	precision := a.Config.Agent.Precision.Duration
+	interval := a.Config.Agent.Interval.Duration
+
+	// The metrics of each input are tagged with their source on their way
+	// to dst.
 	var wg sync.WaitGroup
-	for _, input := range a.Config.Inputs {
-		interval := a.Config.Agent.Interval.Duration
-		precision := a.Config.Agent.Precision.Duration
-		jitter := a.Config.Agent.CollectionJitter.Duration
+	channels := make([]chan telegraf.Metric, len(a.Config.Inputs))
+	for i, input := range a.Config.Inputs {
+		channels[i] = make(chan telegraf.Metric, 100)
+		wg.Add(1)
+		go func(source string, src <-chan telegraf.Metric) {
+			defer wg.Done()
+			for metric := range src {
+				dst <- sourcedMetric{source, metric}
+			}
+		}(input.LogName(), channels[i])
+	}
+
+	var started []telegraf.ServiceInput
+	defer func() {
+		for _, si := range started {
+			si.Stop()
+		}
+		for _, c := range channels {
+			close(c)
+		}
+		wg.Wait()
+	}()
+	for i, input := range a.Config.Inputs {
+		if si, ok := input.Input.(telegraf.ServiceInput); ok {
+			if err := startServiceInput(input, channels[i]); err != nil {
+				return err
+			}
+			started = append(started, si)
+		}
+	}
+
+	nulC := make(chan telegraf.Metric)
+	go func() {
+		for range nulC {
+		}
+	}()
+	defer close(nulC)
 
-		// Overwrite agent interval if this plugin has its own.
-		if input.Config.Interval != 0 {
-			interval = input.Config.Interval
+	var firstErr error
+	for i, input := range a.Config.Inputs {
+		if ctx.Err() != nil {
+			return firstErr
 		}
 
-		acc := NewAccumulator(input, dst)
+		acc := NewAccumulator(input, channels[i])
 		acc.SetPrecision(precision, interval)
+		input.SetDefaultTags(a.Config.Tags)
+
+		// Special instructions for some inputs. cpu, for example, needs to be
+		// run twice in order to return cpu usage percentages.
+		switch input.Name() {
+		case "inputs.cpu", "inputs.mongodb", "inputs.procstat":
+			nulAcc := NewAccumulator(input, nulC)
+			nulAcc.SetPrecision(precision, interval)
+			if err := input.Input.Gather(nulAcc); err != nil {
+				acc.AddError(err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
 
-		wg.Add(1)
-		go func(input *models.RunningInput) {
-			defer wg.Done()
+			time.Sleep(500 * time.Millisecond)
+		}
 
-			if a.Config.Agent.RoundInterval {
-				err := internal.SleepContext(
-					ctx, internal.AlignDuration(startTime, interval))
-				if err != nil {
-					return
-				}
+		if err := input.Input.Gather(acc); err != nil {
+			acc.AddError(err)
+			if firstErr == nil {
+				firstErr = err
 			}
+		}
+	}
 
-			a.gatherOnInterval(ctx, acc, input, interval, jitter)
-		}(input)
+	if len(started) > 0 && wait > 0 {
+		log.Printf("D! [agent] Waiting %s for the service inputs", wait)
+		internal.SleepContext(ctx, wait)
 	}
-	wg.Wait()
+	return firstErr
+}
+
+// runInputs waits for the context to be done, then for the inputs to stop.
+//
+// Each input gathers periodically in its own task, started by Run or by a
+// reload. This function returns after all ongoing Gather calls complete.
+func (a *Agent) runInputs(ctx context.Context) error {
+	<-ctx.Done()
+
+	a.mu.Lock()
+	tasks := make([]*task, 0, len(a.inputs))
+	for _, t := range a.inputs {
+		tasks = append(tasks, t)
+	}
+	a.mu.Unlock()
 
+	for _, t := range tasks {
+		t.wg.Wait()
+	}
 	return nil
 }
 
+// startInput starts the periodic gather of the input, a.mu must be held.
+func (a *Agent) startInput(input *models.RunningInput) {
+	interval := a.Config.Agent.Interval.Duration
+	precision := a.Config.Agent.Precision.Duration
+	jitter := a.Config.Agent.CollectionJitter.Duration
+
+	// Overwrite agent interval if this plugin has its own.
+	if input.Config.Interval != 0 {
+		interval = input.Config.Interval
+	}
+
+	acc := NewAccumulator(input, a.inputDst)
+	acc.SetPrecision(precision, interval)
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	t := &task{cancel: cancel}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		if input.Config.Schedule != nil {
+			a.gatherOnSchedule(ctx, acc, input, input.Config.Schedule, interval, jitter)
+			return
+		}
+
+		if a.Config.Agent.RoundInterval {
+			err := internal.SleepContext(
+				ctx, internal.AlignDuration(time.Now(), interval))
+			if err != nil {
+				return
+			}
+		}
+
+		a.gatherOnInterval(ctx, acc, input, interval, jitter)
+	}()
+	a.inputs[input] = t
+}
+
 // gather runs an input's gather function periodically until the context is
 // done.
 func (a *Agent) gatherOnInterval(
@@ -276,10 +576,7 @@ func (a *Agent) gatherOnInterval(
 			return
 		}
 
-		err = a.gatherOnce(acc, input, interval)
-		if err != nil {
-			acc.AddError(err)
-		}
+		a.gather(ctx, acc, input, interval)
 
 		select {
 		case <-ticker.C:
@@ -290,17 +587,86 @@ func (a *Agent) gatherOnInterval(
 	}
 }
 
+// gatherOnSchedule runs an input's gather function at the times of its
+// schedule. The interval is used to warn about slow gathers when the
+// schedule has no next time.
+func (a *Agent) gatherOnSchedule(
+	ctx context.Context,
+	acc telegraf.Accumulator,
+	input *models.RunningInput,
+	schedule Schedule,
+	interval time.Duration,
+	jitter time.Duration,
+) {
+	defer panicRecover(input)
+
+	ticker := NewScheduleTicker(schedule, jitter)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case tm := <-ticker.C:
+			// Gathers are expected to complete before the next one.
+			if next := schedule.Next(tm); !next.IsZero() {
+				interval = next.Sub(tm)
+			}
+			a.gather(ctx, acc, input, interval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// gather runs the input's gather function once, unless it skips while a
+// previous gather is still running.
+func (a *Agent) gather(
+	ctx context.Context,
+	acc telegraf.Accumulator,
+	input *models.RunningInput,
+	interval time.Duration,
+) {
+	if input.Config.SkipIfRunning && input.IsGathering() {
+		log.Printf("W! [agent] input %q is still gathering, skipping this interval",
+			input.LogName())
+		return
+	}
+
+	err := a.gatherOnce(ctx, acc, input, interval, input.Config.GatherTimeout)
+	if err != nil {
+		acc.AddError(err)
+	}
+}
+
 // gatherOnce runs the input's Gather function once, logging a warning each
-// interval it fails to complete before.
+// interval it fails to complete before. A gather running longer than the
+// timeout, when not 0, is abandoned: it keeps running but its metrics are
+// dropped. With a timeout, the gather is also abandoned when the context is
+// done.
 func (a *Agent) gatherOnce(
+	ctx context.Context,
 	acc telegraf.Accumulator,
 	input *models.RunningInput,
+	interval time.Duration,
 	timeout time.Duration,
 ) error {
-	ticker := time.NewTicker(timeout)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	done := make(chan error)
+	var (
+		timedOut <-chan time.Time
+		stopped  <-chan struct{}
+	)
+	gacc := newGatherAccumulator(acc)
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timedOut = timer.C
+		stopped = ctx.Done()
+		acc = gacc
+	}
+
+	// Buffered for an abandoned gather to return.
+	done := make(chan error, 1)
 	go func() {
 		done <- input.Gather(acc)
 	}()
@@ -311,7 +677,14 @@ func (a *Agent) gatherOnce(
 			return err
 		case <-ticker.C:
 			log.Printf("W! [agent] input %q did not complete within its interval",
-				input.Name())
+				input.LogName())
+		case <-timedOut:
+			gacc.abandon()
+			input.GatherTimeouts.Incr(1)
+			return fmt.Errorf("gather did not complete within %s, abandoned", timeout)
+		case <-stopped:
+			gacc.abandon()
+			return fmt.Errorf("gather did not complete before shutdown, abandoned")
 		}
 	}
 }
@@ -332,81 +705,134 @@ func (a *Agent) runProcessors(
 	return nil
 }
 
+// allProcessors returns the processors of the pipeline and the processors
+// attached to the inputs and outputs.
+func (a *Agent) allProcessors() models.RunningProcessors {
+	processors := append(models.RunningProcessors(nil), a.Config.Processors...)
+	for _, input := range a.Config.Inputs {
+		processors = append(processors, input.Processors...)
+	}
+	for _, output := range a.Config.Outputs {
+		processors = append(processors, output.Processors...)
+	}
+	return processors
+}
+
 // applyProcessors applies all processors to a metric.
 func (a *Agent) applyProcessors(m telegraf.Metric) []telegraf.Metric {
-	metrics := []telegraf.Metric{m}
-	for _, processor := range a.Config.Processors {
-		metrics = processor.Apply(metrics...)
-	}
+	a.pmu.RLock()
+	defer a.pmu.RUnlock()
 
-	return metrics
+	return a.Config.Processors.Apply(m)
 }
 
-// runAggregators triggers the periodic push for Aggregators.
+// runAggregators adds the metrics to the aggregators and forwards the
+// aggregations pushed by their tasks.
 //
-// When the context is done a final push will occur and then this function
+// When the source is closed a final push will occur and then this function
 // will return.
 func (a *Agent) runAggregators(
-	startTime time.Time,
 	src <-chan telegraf.Metric,
 	dst chan<- telegraf.Metric,
+	aggregations chan telegraf.Metric,
+	cancel context.CancelFunc,
 ) error {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	var wg sync.WaitGroup
-	wg.Add(1)
+	done := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		for metric := range src {
-			var dropOriginal bool
-			for _, agg := range a.Config.Aggregators {
-				if ok := agg.Add(metric); ok {
-					dropOriginal = true
-				}
-			}
-
-			if !dropOriginal {
+		defer close(done)
+		for metric := range aggregations {
+			metrics := a.applyProcessors(metric)
+			for _, metric := range metrics {
 				dst <- metric
 			}
 		}
-		cancel()
 	}()
 
+	for metric := range src {
+		if dropOriginal := a.aggregate(metric); !dropOriginal {
+			dst <- metric
+		}
+	}
+
+	cancel()
+	a.mu.Lock()
+	tasks := make([]*task, 0, len(a.aggregators))
+	for _, t := range a.aggregators {
+		tasks = append(tasks, t)
+	}
+	a.mu.Unlock()
+
+	for _, t := range tasks {
+		t.wg.Wait()
+	}
+	close(aggregations)
+	<-done
+	return nil
+}
+
+// aggregate adds the metric to all aggregators, it returns true when the
+// original metric is dropped.
+func (a *Agent) aggregate(metric telegraf.Metric) bool {
+	a.pmu.RLock()
+	defer a.pmu.RUnlock()
+
+	var dropOriginal bool
+	for _, agg := range a.Config.Aggregators {
+		if ok := agg.Add(metric); ok {
+			dropOriginal = true
+		}
+	}
+	return dropOriginal
+}
+
+// startAggregator starts the periodic push of the aggregator, a.mu must be
+// held.
+func (a *Agent) startAggregator(agg *models.RunningAggregator) {
 	precision := a.Config.Agent.Precision.Duration
 	interval := a.Config.Agent.Interval.Duration
-	aggregations := make(chan telegraf.Metric, 100)
-	for _, agg := range a.Config.Aggregators {
-		wg.Add(1)
-		go func(agg *models.RunningAggregator) {
-			defer wg.Done()
 
-			if a.Config.Agent.RoundInterval {
-				// Aggregators are aligned to the agent interval regardless of
-				// their period.
-				err := internal.SleepContext(ctx, internal.AlignDuration(startTime, interval))
-				if err != nil {
-					return
-				}
+	ctx, cancel := context.WithCancel(a.aggCtx)
+	t := &task{cancel: cancel}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		if agg.Config.Schedule != nil {
+			// The periods start at the times of the schedule, aligning them
+			// across agents.
+			start := agg.Config.Schedule.Next(time.Now())
+			if start.IsZero() {
+				return
 			}
+			err := internal.SleepContext(ctx, time.Until(start))
+			if err != nil {
+				return
+			}
+			agg.SetPeriodStart(start)
 
-			agg.SetPeriodStart(startTime)
-
-			acc := NewAccumulator(agg, aggregations)
+			acc := NewAccumulator(agg, a.aggDst)
 			acc.SetPrecision(precision, interval)
-			a.push(ctx, agg, acc)
-			close(aggregations)
-		}(agg)
-	}
+			a.pushOnSchedule(ctx, agg, acc)
+			return
+		}
 
-	for metric := range aggregations {
-		metrics := a.applyProcessors(metric)
-		for _, metric := range metrics {
-			dst <- metric
+		startTime := time.Now()
+		if a.Config.Agent.RoundInterval {
+			// Aggregators are aligned to the agent interval regardless of
+			// their period.
+			err := internal.SleepContext(ctx, internal.AlignDuration(startTime, interval))
+			if err != nil {
+				return
+			}
 		}
-	}
 
-	wg.Wait()
-	return nil
+		agg.SetPeriodStart(startTime)
+
+		acc := NewAccumulator(agg, a.aggDst)
+		acc.SetPrecision(precision, interval)
+		a.push(ctx, agg, acc)
+	}()
+	a.aggregators[agg] = t
 }
 
 // push runs the push for a single aggregator every period.  More simple than
@@ -433,67 +859,130 @@ func (a *Agent) push(
 	}
 }
 
-// runOutputs triggers the periodic write for Outputs.
+// pushOnSchedule pushes an aggregator at the end of each of its periods, and
+// once more on exit.
+func (a *Agent) pushOnSchedule(
+	ctx context.Context,
+	aggregator *models.RunningAggregator,
+	acc telegraf.Accumulator,
+) {
+	for {
+		err := internal.SleepContext(ctx, time.Until(aggregator.PeriodEnd()))
+		aggregator.Push(acc)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runOutputs routes the metrics to the outputs.
 //
-// When the context is done, outputs continue to run until their buffer is
-// closed, afterwich they run flush once more.
+// When the source is closed, the outputs flush once more and this function
+// returns.
 func (a *Agent) runOutputs(
-	startTime time.Time,
 	src <-chan telegraf.Metric,
+	cancel context.CancelFunc,
 ) error {
-	interval := a.Config.Agent.FlushInterval.Duration
-	jitter := a.Config.Agent.FlushJitter.Duration
+	for metric := range src {
+		a.route(metric)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	log.Println("I! [agent] Hang on, flushing any cached metrics before shutdown")
+	cancel()
+	a.mu.Lock()
+	tasks := make([]*task, 0, len(a.outputs))
+	for _, t := range a.outputs {
+		tasks = append(tasks, t)
+	}
+	a.mu.Unlock()
 
-	var wg sync.WaitGroup
-	for _, output := range a.Config.Outputs {
-		interval := interval
-		// Overwrite agent flush_interval if this plugin has its own.
-		if output.Config.FlushInterval != 0 {
-			interval = output.Config.FlushInterval
-		}
+	for _, t := range tasks {
+		t.wg.Wait()
+	}
+	return nil
+}
 
-		wg.Add(1)
-		go func(output *models.RunningOutput) {
-			defer wg.Done()
+// route delivers the metric with the current router.
+func (a *Agent) route(metric telegraf.Metric) {
+	a.pmu.RLock()
+	router := a.router
+	a.pmu.RUnlock()
 
-			if a.Config.Agent.RoundInterval {
-				err := internal.SleepContext(
-					ctx, internal.AlignDuration(startTime, interval))
-				if err != nil {
-					return
-				}
-			}
+	router.route(metric)
+}
 
-			a.flush(ctx, output, interval, jitter)
-		}(output)
+// startOutput connects the output and starts its periodic flush, a.mu must
+// be held. An output failing to connect is left disconnected, buffering its
+// metrics, and is reconnected in the background so that the other outputs
+// proceed.
+func (a *Agent) startOutput(output *models.RunningOutput) {
+	interval := a.Config.Agent.FlushInterval.Duration
+	jitter := a.Config.Agent.FlushJitter.Duration
+	// Overwrite agent flush_interval if this plugin has its own.
+	if output.Config.FlushInterval != 0 {
+		interval = output.Config.FlushInterval
 	}
 
-	for metric := range src {
-		for i, output := range a.Config.Outputs {
-			if i == len(a.Config.Outputs)-1 {
-				output.AddMetric(metric)
-			} else {
-				output.AddMetric(metric.Copy())
-			}
-		}
+	connectCtx, cancelConnect := context.WithCancel(a.ctx)
+	flushCtx, cancelFlush := context.WithCancel(a.outputCtx)
+	t := &task{
+		cancel: func() {
+			cancelConnect()
+			cancelFlush()
+		},
+		flush: make(chan chan error),
 	}
 
-	log.Println("I! [agent] Hang on, flushing any cached metrics before shutdown")
-	cancel()
-	wg.Wait()
+	log.Printf("D! [agent] Attempting connection to output: %s\n", output.LogName())
+	err := output.Connect()
+	if err != nil {
+		log.Printf("E! [agent] Failed to connect to output %s, retrying in the background, "+
+			"error was '%s' \n", output.LogName(), err)
+
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			a.reconnect(connectCtx, output)
+		}()
+	} else {
+		log.Printf("D! [agent] Successfully connected to output: %s\n", output.LogName())
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		if a.Config.Agent.RoundInterval {
+			// When canceled while aligning, the output still flushes the
+			// metrics it buffered.
+			a.waitFlush(flushCtx, output, interval,
+				internal.AlignDuration(time.Now(), interval), t.flush)
+		}
+
+		a.flush(flushCtx, output, interval, jitter, t.flush)
+	}()
+	a.outputs[output] = t
+}
 
+// openBuffer opens the disk buffer of the output, if it has one.
+func openBuffer(output *models.RunningOutput) error {
+	if output.Config.BufferType != "disk" {
+		return nil
+	}
+	if err := output.OpenDiskBuffer(); err != nil {
+		return fmt.Errorf("could not open disk buffer of output %s: %v", output.LogName(), err)
+	}
 	return nil
 }
 
-// flush runs an output's flush function periodically until the context is
-// done.
+// flush runs an output's flush function periodically, and when requested,
+// until the context is done.
 func (a *Agent) flush(
 	ctx context.Context,
 	output *models.RunningOutput,
 	interval time.Duration,
 	jitter time.Duration,
+	requests <-chan chan error,
 ) {
 	// since we are watching two channels we need a ticker with the jitter
 	// integrated.
@@ -501,8 +990,12 @@ func (a *Agent) flush(
 	defer ticker.Stop()
 
 	logError := func(err error) {
+		// The metrics stay buffered until the output reconnects.
+		if _, ok := err.(notConnectedError); ok {
+			return
+		}
 		if err != nil {
-			log.Printf("E! [agent] Error writing to output [%s]: %v", output.Name, err)
+			log.Printf("E! [agent] Error writing to output [%s]: %v", output.LogName(), err)
 		}
 	}
 
@@ -518,6 +1011,8 @@ func (a *Agent) flush(
 		select {
 		case <-ticker.C:
 			logError(a.flushOnce(output, interval, output.Write))
+		case done := <-requests:
+			done <- a.flushRequested(output, interval)
 		case <-output.BatchReady:
 			// Favor the ticker over batch ready
 			select {
@@ -533,6 +1028,80 @@ func (a *Agent) flush(
 	}
 }
 
+// waitFlush waits for the duration or the context to be done, serving the
+// requests to flush the output meanwhile.
+func (a *Agent) waitFlush(
+	ctx context.Context,
+	output *models.RunningOutput,
+	interval time.Duration,
+	d time.Duration,
+	requests <-chan chan error,
+) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		case done := <-requests:
+			done <- a.flushRequested(output, interval)
+		}
+	}
+}
+
+// flushRequested writes the buffered metrics of the output on request.
+func (a *Agent) flushRequested(output *models.RunningOutput, interval time.Duration) error {
+	err := a.flushOnce(output, interval, output.Write)
+	if err != nil {
+		log.Printf("E! [agent] Error writing to output [%s]: %v", output.LogName(), err)
+	}
+	return err
+}
+
+// Flush writes the metrics buffered by the running outputs now. It returns
+// the write error of each output, or the context error when the output did
+// not flush in time.
+func (a *Agent) Flush(ctx context.Context) map[*models.RunningOutput]error {
+	a.mu.Lock()
+	tasks := make(map[*models.RunningOutput]*task, len(a.outputs))
+	for output, t := range a.outputs {
+		tasks[output] = t
+	}
+	a.mu.Unlock()
+
+	// The outputs flush concurrently, each one in its own flush loop.
+	pending := make(map[*models.RunningOutput]chan error, len(tasks))
+	errs := make(map[*models.RunningOutput]error, len(tasks))
+	for output, t := range tasks {
+		done := make(chan error, 1)
+		select {
+		case t.flush <- done:
+			pending[output] = done
+		case <-ctx.Done():
+			errs[output] = ctx.Err()
+		}
+	}
+	for output, done := range pending {
+		select {
+		case err := <-done:
+			errs[output] = err
+		case <-ctx.Done():
+			errs[output] = ctx.Err()
+		}
+	}
+	return errs
+}
+
+// notConnectedError is returned when flushing an output that is not connected.
+type notConnectedError string
+
+func (e notConnectedError) Error() string {
+	return fmt.Sprintf("output %s is not connected", string(e))
+}
+
 // flushOnce runs the output's Write function once, logging a warning each
 // interval it fails to complete before.
 func (a *Agent) flushOnce(
@@ -540,6 +1109,12 @@ func (a *Agent) flushOnce(
 	timeout time.Duration,
 	writeFunc func() error,
 ) error {
+	// A disconnected output keeps its metrics buffered until it reconnects.
+	if !output.IsConnected() {
+		output.LogBufferStatus()
+		return notConnectedError(output.LogName())
+	}
+
 	ticker := time.NewTicker(timeout)
 	defer ticker.Stop()
 
@@ -555,42 +1130,47 @@ func (a *Agent) flushOnce(
 			return err
 		case <-ticker.C:
 			log.Printf("W! [agent] output %q did not complete within its flush interval",
-				output.Name)
+				output.LogName())
 			output.LogBufferStatus()
 		}
 	}
 
 }
 
-// connectOutputs connects to all outputs.
-func (a *Agent) connectOutputs(ctx context.Context) error {
-	for _, output := range a.Config.Outputs {
-		log.Printf("D! [agent] Attempting connection to output: %s\n", output.Name)
-		err := output.Output.Connect()
+// reconnect retries to connect the output, with an exponential backoff, until
+// it succeeds or the context is done.
+func (a *Agent) reconnect(ctx context.Context, output *models.RunningOutput) {
+	delay := reconnectDelay
+	for {
+		// The jitter spreads the retries of outputs failing together.
+		err := internal.SleepContext(ctx, delay+internal.RandomDuration(delay/2))
 		if err != nil {
-			log.Printf("E! [agent] Failed to connect to output %s, retrying in 15s, "+
-				"error was '%s' \n", output.Name, err)
+			return
+		}
 
-			err := internal.SleepContext(ctx, 15*time.Second)
-			if err != nil {
-				return err
-			}
+		err = output.Connect()
+		if err == nil {
+			log.Printf("I! [agent] Successfully connected to output: %s\n", output.LogName())
+			return
+		}
 
-			err = output.Output.Connect()
-			if err != nil {
-				return err
-			}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
 		}
-		log.Printf("D! [agent] Successfully connected to output: %s\n", output.Name)
+		log.Printf("E! [agent] Failed to connect to output %s, retrying in %s, "+
+			"error was '%s' \n", output.LogName(), delay, err)
 	}
-	return nil
 }
 
 // closeOutputs closes all outputs.
 func (a *Agent) closeOutputs() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	var err error
 	for _, output := range a.Config.Outputs {
-		err = output.Output.Close()
+		err = output.Close()
 	}
 	return err
 }
@@ -604,18 +1184,8 @@ func (a *Agent) startServiceInputs(
 
 	for _, input := range a.Config.Inputs {
 		if si, ok := input.Input.(telegraf.ServiceInput); ok {
-			// Service input plugins are not subject to timestamp rounding.
-			// This only applies to the accumulator passed to Start(), the
-			// Gather() accumulator does apply rounding according to the
-			// precision agent setting.
-			acc := NewAccumulator(input, dst)
-			acc.SetPrecision(time.Nanosecond, 0)
-
-			err := si.Start(acc)
+			err := startServiceInput(input, dst)
 			if err != nil {
-				log.Printf("E! [agent] Service for input %s failed to start: %v",
-					input.Name(), err)
-
 				for _, si := range started {
 					si.Stop()
 				}
@@ -630,8 +1200,33 @@ func (a *Agent) startServiceInputs(
 	return nil
 }
 
+// startServiceInput starts the input if it is a service input.
+func startServiceInput(input *models.RunningInput, dst chan<- telegraf.Metric) error {
+	si, ok := input.Input.(telegraf.ServiceInput)
+	if !ok {
+		return nil
+	}
+
+	// Service input plugins are not subject to timestamp rounding.
+	// This only applies to the accumulator passed to Start(), the
+	// Gather() accumulator does apply rounding according to the
+	// precision agent setting.
+	acc := NewAccumulator(input, dst)
+	acc.SetPrecision(time.Nanosecond, 0)
+
+	err := si.Start(acc)
+	if err != nil {
+		log.Printf("E! [agent] Service for input %s failed to start: %v",
+			input.LogName(), err)
+	}
+	return err
+}
+
 // stopServiceInputs stops all service inputs.
 func (a *Agent) stopServiceInputs() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	for _, input := range a.Config.Inputs {
 		if si, ok := input.Input.(telegraf.ServiceInput); ok {
 			si.Stop()
@@ -645,7 +1240,7 @@ func panicRecover(input *models.RunningInput) {
 		trace := make([]byte, 2048)
 		runtime.Stack(trace, true)
 		log.Printf("E! FATAL: Input [%s] panicked: %s, Stack:\n%s\n",
-			input.Name(), err, trace)
+			input.LogName(), err, trace)
 		log.Println("E! PLEASE REPORT THIS PANIC ON GITHUB with " +
 			"stack trace, configuration, and OS information: " +
 			"https://github.com/influxdata/telegraf/issues/new/choose")
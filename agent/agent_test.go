@@ -1,9 +1,19 @@
 package agent
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/plugins/serializers"
+	"github.com/influxdata/telegraf/testutil"
 
 	// needing to load the plugins
 	_ "github.com/influxdata/telegraf/plugins/inputs/all"
@@ -11,6 +21,7 @@ import (
 	_ "github.com/influxdata/telegraf/plugins/outputs/all"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAgent_OmitHostname(t *testing.T) {
@@ -109,3 +120,311 @@ func TestAgent_LoadOutput(t *testing.T) {
 	a, _ = NewAgent(c)
 	assert.Equal(t, 3, len(a.Config.Outputs))
 }
+
+// flakyOutput fails to connect a number of times before succeeding.
+type flakyOutput struct {
+	sync.Mutex
+	failures int
+	connects int
+	writes   int
+}
+
+func (o *flakyOutput) Connect() error {
+	o.Lock()
+	defer o.Unlock()
+	o.connects++
+	if o.connects <= o.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (o *flakyOutput) Close() error {
+	return nil
+}
+
+func (o *flakyOutput) Description() string {
+	return ""
+}
+
+func (o *flakyOutput) SampleConfig() string {
+	return ""
+}
+
+func (o *flakyOutput) Write(metrics []telegraf.Metric) error {
+	o.Lock()
+	defer o.Unlock()
+	o.writes++
+	return nil
+}
+
+// startedAgent returns an agent ready to start plugins, as when running
+// until the context is done.
+func startedAgent(ctx context.Context, c *config.Config) *Agent {
+	a, _ := NewAgent(c)
+	a.ctx = ctx
+	a.outputCtx = ctx
+	return a
+}
+
+// waitFor polls the condition until it is true or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAgent_ReconnectOutput(t *testing.T) {
+	defer func(delay time.Duration) { reconnectDelay = delay }(reconnectDelay)
+	reconnectDelay = time.Millisecond
+
+	output := &flakyOutput{failures: 3}
+	ro := models.NewRunningOutput("flaky", output, &models.OutputConfig{}, 0, 0)
+	c := config.NewConfig()
+	c.Outputs = append(c.Outputs, ro)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := startedAgent(ctx, c)
+
+	a.mu.Lock()
+	a.startOutput(ro)
+	a.mu.Unlock()
+	assert.False(t, ro.IsConnected())
+	assert.Equal(t, int64(0), ro.Connected.Get())
+
+	// The metrics of a disconnected output stay buffered
+	ro.AddMetric(testutil.TestMetric(1))
+	assert.EqualError(t, a.flushOnce(ro, time.Second, ro.Write), "output outputs.flaky is not connected")
+	assert.Equal(t, 0, output.writes)
+
+	waitFor(t, ro.IsConnected)
+	assert.Equal(t, int64(1), ro.Connected.Get())
+	assert.Equal(t, 4, output.connects)
+
+	assert.NoError(t, a.flushOnce(ro, time.Second, ro.Write))
+	assert.Equal(t, 1, output.writes)
+
+	a.outputs[ro].stop()
+	assert.NoError(t, ro.Close())
+	assert.False(t, ro.IsConnected())
+	assert.Equal(t, int64(0), ro.Connected.Get())
+}
+
+func TestAgent_ReconnectCanceled(t *testing.T) {
+	output := &flakyOutput{failures: 1}
+	ro := models.NewRunningOutput("flaky", output, &models.OutputConfig{}, 0, 0)
+	c := config.NewConfig()
+	c.Outputs = append(c.Outputs, ro)
+	a := startedAgent(context.Background(), c)
+
+	a.mu.Lock()
+	a.startOutput(ro)
+	a.mu.Unlock()
+	a.outputs[ro].stop()
+	assert.False(t, ro.IsConnected())
+	assert.Equal(t, 1, output.connects)
+}
+
+// hungInput blocks in Gather until released, then adds a metric.
+type hungInput struct {
+	sync.Mutex
+	gathers int
+	release chan struct{}
+}
+
+func (i *hungInput) Description() string  { return "" }
+func (i *hungInput) SampleConfig() string { return "" }
+
+func (i *hungInput) Gather(acc telegraf.Accumulator) error {
+	i.Lock()
+	i.gathers++
+	i.Unlock()
+
+	<-i.release
+	acc.AddFields("hung", map[string]interface{}{"value": 1}, nil)
+	return nil
+}
+
+func TestAgent_GatherTimeout(t *testing.T) {
+	input := &hungInput{release: make(chan struct{})}
+	ri := models.NewRunningInput(input, &models.InputConfig{Name: "gather_timeout"})
+	timeouts := ri.GatherTimeouts.Get()
+	a, _ := NewAgent(config.NewConfig())
+
+	metrics := make(chan telegraf.Metric, 10)
+	acc := NewAccumulator(ri, metrics)
+	err := a.gatherOnce(context.Background(), acc, ri, time.Hour, 10*time.Millisecond)
+	assert.EqualError(t, err, "gather did not complete within 10ms, abandoned")
+	assert.Equal(t, timeouts+1, ri.GatherTimeouts.Get())
+	assert.True(t, ri.IsGathering())
+
+	// The metrics of the abandoned gather are dropped
+	close(input.release)
+	waitFor(t, func() bool { return !ri.IsGathering() })
+	assert.Len(t, metrics, 0)
+
+	assert.NoError(t, a.gatherOnce(context.Background(), acc, ri, time.Hour, time.Hour))
+	assert.Len(t, metrics, 1)
+}
+
+// Test that a gather blocked on a full channel is abandoned
+func TestAgent_GatherTimeoutBlocked(t *testing.T) {
+	input := &hungInput{release: make(chan struct{})}
+	close(input.release)
+	ri := models.NewRunningInput(input, &models.InputConfig{Name: "gather_timeout"})
+	a, _ := NewAgent(config.NewConfig())
+
+	// Nothing reads the metrics.
+	acc := NewAccumulator(ri, make(chan telegraf.Metric))
+	errC := make(chan error)
+	go func() {
+		errC <- a.gatherOnce(context.Background(), acc, ri, time.Hour, 10*time.Millisecond)
+	}()
+	select {
+	case err := <-errC:
+		assert.EqualError(t, err, "gather did not complete within 10ms, abandoned")
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked gather not abandoned")
+	}
+	waitFor(t, func() bool { return !ri.IsGathering() })
+}
+
+// Test that a gather with a timeout is abandoned on shutdown
+func TestAgent_GatherTimeoutShutdown(t *testing.T) {
+	input := &hungInput{release: make(chan struct{})}
+	defer close(input.release)
+	ri := models.NewRunningInput(input, &models.InputConfig{Name: "gather_timeout"})
+	a, _ := NewAgent(config.NewConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acc := NewAccumulator(ri, make(chan telegraf.Metric, 10))
+	errC := make(chan error)
+	go func() {
+		errC <- a.gatherOnce(ctx, acc, ri, time.Hour, time.Hour)
+	}()
+	cancel()
+	select {
+	case err := <-errC:
+		assert.EqualError(t, err, "gather did not complete before shutdown, abandoned")
+	case <-time.After(5 * time.Second):
+		t.Fatal("gather not abandoned on shutdown")
+	}
+}
+
+func TestAgent_SkipIfRunning(t *testing.T) {
+	input := &hungInput{release: make(chan struct{})}
+	ri := models.NewRunningInput(input, &models.InputConfig{
+		Name:          "skip_if_running",
+		GatherTimeout: time.Millisecond,
+		SkipIfRunning: true,
+	})
+	a, _ := NewAgent(config.NewConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acc := NewAccumulator(ri, make(chan telegraf.Metric, 10))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.gatherOnInterval(ctx, acc, ri, 5*time.Millisecond, 0)
+	}()
+
+	// The intervals passing while the abandoned gather runs are skipped
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+	input.Lock()
+	assert.Equal(t, 1, input.gathers)
+	input.Unlock()
+	close(input.release)
+}
+
+type onceService struct {
+	reloadInput
+}
+
+func (i *onceService) Start(acc telegraf.Accumulator) error {
+	acc.AddFields("service", map[string]interface{}{"value": 1}, nil)
+	return nil
+}
+
+func (i *onceService) Stop() {}
+
+type tagProcessor struct{}
+
+func (p *tagProcessor) Description() string  { return "" }
+func (p *tagProcessor) SampleConfig() string { return "" }
+
+func (p *tagProcessor) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	for _, m := range in {
+		m.AddTag("processed", "true")
+	}
+	return in
+}
+
+type countAggregator struct {
+	count int64
+}
+
+func (c *countAggregator) Description() string    { return "" }
+func (c *countAggregator) SampleConfig() string   { return "" }
+func (c *countAggregator) Add(in telegraf.Metric) { c.count++ }
+func (c *countAggregator) Reset()                 { c.count = 0 }
+
+func (c *countAggregator) Push(acc telegraf.Accumulator) {
+	acc.AddFields("count", map[string]interface{}{"value": c.count}, nil)
+}
+
+func onceConfig() *config.Config {
+	c := config.NewConfig()
+	c.Inputs = append(c.Inputs,
+		newReloadInput("i1"),
+		models.NewRunningInput(&onceService{},
+			&models.InputConfig{Name: "service", ID: "i2"}))
+	c.Processors = append(c.Processors, &models.RunningProcessor{
+		Name:      "tag",
+		Processor: &tagProcessor{},
+		Config:    &models.ProcessorConfig{Name: "tag"},
+	})
+	c.Aggregators = append(c.Aggregators, models.NewRunningAggregator(
+		&countAggregator{},
+		&models.AggregatorConfig{Name: "count", Period: time.Minute}))
+	return c
+}
+
+func TestAgent_Test(t *testing.T) {
+	a, _ := NewAgent(onceConfig())
+	var buf bytes.Buffer
+	serializer, err := serializers.NewSerializer(&serializers.Config{
+		DataFormat:       "influx",
+		InfluxSortFields: true,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, a.Test(context.Background(), time.Millisecond, &buf, serializer))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	// The service input is started, then gathered as the other inputs.
+	require.Len(t, lines, 7)
+	assert.Equal(t, "# inputs.reload", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "> reload,processed=true value=1i "), lines[1])
+	assert.Equal(t, "# inputs.service", lines[2])
+	assert.True(t, strings.HasPrefix(lines[3], "> service,processed=true value=1i "), lines[3])
+	assert.True(t, strings.HasPrefix(lines[4], "> reload,processed=true value=1i "), lines[4])
+	assert.Equal(t, "# aggregators.count", lines[5])
+	assert.True(t, strings.HasPrefix(lines[6], "> count,processed=true value=3i "), lines[6])
+}
+
+func TestAgent_Once(t *testing.T) {
+	c := onceConfig()
+	output := newReloadOutput("once", "o1")
+	c.Outputs = append(c.Outputs, output)
+	a, _ := NewAgent(c)
+
+	require.NoError(t, a.Once(context.Background(), time.Millisecond))
+	o := output.Output.(*reloadOutput)
+	assert.Equal(t, []string{"reload", "service", "reload", "count"}, o.written)
+	assert.True(t, o.isClosed())
+}
@@ -0,0 +1,397 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+const (
+	// flushTimeout bounds the wait of a POST /flush for the outputs.
+	flushTimeout = time.Minute
+	// shutdownTimeout bounds the wait for the ongoing requests on Stop.
+	shutdownTimeout = 5 * time.Second
+)
+
+// ReloadFunc reloads the configuration of the agent, restarting the inputs
+// named in restart even when unchanged.
+type ReloadFunc func(restart []string) (*ReloadResult, error)
+
+// API serves the health, status and control endpoints of a running agent:
+//
+//	GET  /health   outputs connection, last writes and input errors
+//	GET  /status   plugins configuration, last gathers and buffers fill
+//	GET  /metrics  internal stats in the Prometheus text format
+//	POST /reload   reloads the configuration
+//	POST /flush    flushes the outputs
+//
+// The POST endpoints require the token as a bearer token when one is set.
+type API struct {
+	agent  *Agent
+	reload ReloadFunc
+	token  string
+
+	server *http.Server
+	wg     sync.WaitGroup
+}
+
+// NewAPI returns the API of the agent, reloading its configuration with
+// reload. Without a token the API only serves on a loopback address.
+func NewAPI(agent *Agent, reload ReloadFunc, token string) *API {
+	return &API{
+		agent:  agent,
+		reload: reload,
+		token:  token,
+	}
+}
+
+// Handler returns the handler serving the endpoints of the API.
+func (api *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", api.get(api.health))
+	mux.HandleFunc("/status", api.get(api.status))
+	mux.HandleFunc("/metrics", api.get(api.metrics))
+	mux.HandleFunc("/reload", api.post(api.reloadConfig))
+	mux.HandleFunc("/flush", api.post(api.flush))
+	return mux
+}
+
+// Start listens on the address and serves the API in the background. A
+// non-loopback address is refused unless the API has a token.
+func (api *API) Start(address string) error {
+	if api.token == "" {
+		addr, err := net.ResolveTCPAddr("tcp", address)
+		if err != nil {
+			return fmt.Errorf("invalid API address %s: %v", address, err)
+		}
+		if addr.IP == nil || !addr.IP.IsLoopback() {
+			return fmt.Errorf("API address %s is not a loopback address, set api_token to serve the API on it", address)
+		}
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %v", address, err)
+	}
+	api.server = &http.Server{Handler: api.Handler()}
+
+	api.wg.Add(1)
+	go func() {
+		defer api.wg.Done()
+		err := api.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("E! [agent] Error serving API: %v", err)
+		}
+	}()
+	log.Printf("I! [agent] Serving API at http://%s", listener.Addr())
+	return nil
+}
+
+// Stop shuts the API down, waiting for the ongoing requests.
+func (api *API) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := api.server.Shutdown(ctx); err != nil {
+		log.Printf("E! [agent] Error shutting down API: %v", err)
+	}
+	api.wg.Wait()
+}
+
+func (api *API) get(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (api *API) post(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+			return
+		}
+		if !api.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, errorResponse{"unauthorized"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// authorized reports whether the request carries the token of the API.
+func (api *API) authorized(r *http.Request) bool {
+	if api.token == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) == 1
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type outputHealth struct {
+	Name      string     `json:"name"`
+	Connected bool       `json:"connected"`
+	LastWrite *time.Time `json:"last_write,omitempty"`
+}
+
+type inputHealth struct {
+	Name         string `json:"name"`
+	GatherErrors int64  `json:"gather_errors"`
+}
+
+type healthResponse struct {
+	Healthy bool           `json:"healthy"`
+	Outputs []outputHealth `json:"outputs"`
+	Inputs  []inputHealth  `json:"inputs"`
+}
+
+// health reports the agent as healthy, with a 200 status, when all its
+// outputs are connected and 503 otherwise.
+func (api *API) health(w http.ResponseWriter, r *http.Request) {
+	inputs, outputs := api.agent.plugins()
+
+	resp := healthResponse{
+		Healthy: true,
+		Outputs: make([]outputHealth, 0, len(outputs)),
+		Inputs:  make([]inputHealth, 0, len(inputs)),
+	}
+	for _, output := range outputs {
+		connected := output.IsConnected()
+		resp.Healthy = resp.Healthy && connected
+		resp.Outputs = append(resp.Outputs, outputHealth{
+			Name:      output.LogName(),
+			Connected: connected,
+			LastWrite: optionalTime(output.LastWrite()),
+		})
+	}
+	for _, input := range inputs {
+		resp.Inputs = append(resp.Inputs, inputHealth{
+			Name:         input.LogName(),
+			GatherErrors: input.GatherErrors.Get(),
+		})
+	}
+
+	code := http.StatusOK
+	if !resp.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, resp)
+}
+
+type inputStatus struct {
+	Name       string     `json:"name"`
+	ID         string     `json:"id"`
+	Interval   string     `json:"interval"`
+	Route      string     `json:"route,omitempty"`
+	LastGather *time.Time `json:"last_gather,omitempty"`
+}
+
+type outputStatus struct {
+	Name          string     `json:"name"`
+	ID            string     `json:"id"`
+	FlushInterval string     `json:"flush_interval"`
+	BatchSize     int        `json:"metric_batch_size"`
+	BufferType    string     `json:"buffer_type"`
+	BufferSize    int        `json:"buffer_size"`
+	BufferLimit   int        `json:"buffer_limit"`
+	Routes        []string   `json:"routes,omitempty"`
+	Connected     bool       `json:"connected"`
+	LastWrite     *time.Time `json:"last_write,omitempty"`
+}
+
+type statusResponse struct {
+	Interval      string         `json:"interval"`
+	FlushInterval string         `json:"flush_interval"`
+	Inputs        []inputStatus  `json:"inputs"`
+	Processors    []string       `json:"processors"`
+	Aggregators   []string       `json:"aggregators"`
+	Outputs       []outputStatus `json:"outputs"`
+}
+
+// status reports the configuration and state of the running plugins.
+func (api *API) status(w http.ResponseWriter, r *http.Request) {
+	a := api.agent
+	a.mu.Lock()
+	processors := a.Config.ProcessorNames()
+	aggregators := a.Config.AggregatorNames()
+	a.mu.Unlock()
+	inputs, outputs := a.plugins()
+
+	resp := statusResponse{
+		Interval:      a.Config.Agent.Interval.Duration.String(),
+		FlushInterval: a.Config.Agent.FlushInterval.Duration.String(),
+		Inputs:        make([]inputStatus, 0, len(inputs)),
+		Processors:    processors,
+		Aggregators:   aggregators,
+		Outputs:       make([]outputStatus, 0, len(outputs)),
+	}
+	for _, input := range inputs {
+		interval := a.Config.Agent.Interval.Duration
+		if input.Config.Interval != 0 {
+			interval = input.Config.Interval
+		}
+		resp.Inputs = append(resp.Inputs, inputStatus{
+			Name:       input.LogName(),
+			ID:         input.Config.ID,
+			Interval:   interval.String(),
+			Route:      input.Config.Route,
+			LastGather: optionalTime(input.LastGather()),
+		})
+	}
+	for _, output := range outputs {
+		interval := a.Config.Agent.FlushInterval.Duration
+		if output.Config.FlushInterval != 0 {
+			interval = output.Config.FlushInterval
+		}
+		bufferType := output.Config.BufferType
+		if bufferType == "" {
+			bufferType = "memory"
+		}
+		resp.Outputs = append(resp.Outputs, outputStatus{
+			Name:          output.LogName(),
+			ID:            output.Config.ID,
+			FlushInterval: interval.String(),
+			BatchSize:     output.MetricBatchSize,
+			BufferType:    bufferType,
+			BufferSize:    output.BufferLength(),
+			BufferLimit:   output.MetricBufferLimit,
+			Routes:        output.Config.Routes,
+			Connected:     output.IsConnected(),
+			LastWrite:     optionalTime(output.LastWrite()),
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// metrics writes the internal stats in the Prometheus text format, one
+// untyped sample per field named after the measurement and the field.
+func (api *API) metrics(w http.ResponseWriter, r *http.Request) {
+	var lines []string
+	for _, m := range selfstat.Metrics() {
+		if m == nil {
+			continue
+		}
+		labels := make([]string, 0, len(m.TagList()))
+		for _, tag := range m.TagList() {
+			labels = append(labels, fmt.Sprintf("%s=%q",
+				sanitizePrometheus(tag.Key), tag.Value))
+		}
+		for _, field := range m.FieldList() {
+			name := sanitizePrometheus(m.Name() + "_" + field.Key)
+			line := name
+			if len(labels) > 0 {
+				line += "{" + strings.Join(labels, ",") + "}"
+			}
+			lines = append(lines, fmt.Sprintf("%s %v", line, field.Value))
+		}
+	}
+	sort.Strings(lines)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+var invalidPrometheusChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+func sanitizePrometheus(name string) string {
+	return invalidPrometheusChars.ReplaceAllString(name, "_")
+}
+
+// reloadConfig reloads the configuration, restarting the inputs named by the
+// restart parameters. The agent is restarted, with a 202 status, when the
+// change cannot be applied in place.
+func (api *API) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	result, err := api.reload(r.URL.Query()["restart"])
+	switch {
+	case err == ErrRestartRequired:
+		writeJSON(w, http.StatusAccepted, errorResponse{err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+type flushResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+type flushResponse struct {
+	Outputs []flushResult `json:"outputs"`
+}
+
+// flush writes the metrics buffered by the outputs now, with a 500 status
+// if any output failed.
+func (api *API) flush(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), flushTimeout)
+	defer cancel()
+
+	code := http.StatusOK
+	resp := flushResponse{Outputs: []flushResult{}}
+	for output, err := range api.agent.Flush(ctx) {
+		result := flushResult{Name: output.LogName()}
+		if err != nil {
+			result.Error = err.Error()
+			code = http.StatusInternalServerError
+		}
+		resp.Outputs = append(resp.Outputs, result)
+	}
+	sort.Slice(resp.Outputs, func(i, j int) bool {
+		return resp.Outputs[i].Name < resp.Outputs[j].Name
+	})
+	writeJSON(w, code, resp)
+}
+
+// plugins returns the running inputs and outputs.
+func (a *Agent) plugins() ([]*models.RunningInput, []*models.RunningOutput) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	inputs := append([]*models.RunningInput(nil), a.Config.Inputs...)
+	outputs := append([]*models.RunningOutput(nil), a.Config.Outputs...)
+	return inputs, outputs
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("E! [agent] Error writing API response: %v", err)
+	}
+}
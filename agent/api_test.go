@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func apiRequest(t *testing.T, api *API, method, path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, req)
+
+	var body map[string]interface{}
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	}
+	return w, body
+}
+
+func TestAPI_Health(t *testing.T) {
+	c := config.NewConfig()
+	c.Inputs = append(c.Inputs, newReloadInput("i1"))
+	output := newReloadOutput("api_health", "o1")
+	c.Outputs = append(c.Outputs, output)
+	a, _ := NewAgent(c)
+	api := NewAPI(a, nil, "")
+
+	w, body := apiRequest(t, api, "GET", "/health")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, false, body["healthy"])
+
+	require.NoError(t, output.Connect())
+	w, body = apiRequest(t, api, "GET", "/health")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, body["healthy"])
+	outputs := body["outputs"].([]interface{})
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "outputs.api_health", outputs[0].(map[string]interface{})["name"])
+	assert.NotContains(t, outputs[0], "last_write")
+	inputs := body["inputs"].([]interface{})
+	require.Len(t, inputs, 1)
+	assert.Equal(t, "inputs.reload", inputs[0].(map[string]interface{})["name"])
+
+	w, _ = apiRequest(t, api, "POST", "/health")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestAPI_Status(t *testing.T) {
+	c := config.NewConfig()
+	c.Inputs = append(c.Inputs, newReloadInput("i1"))
+	output := newReloadOutput("api_status", "o1")
+	c.Outputs = append(c.Outputs, output)
+	a, _ := NewAgent(c)
+	api := NewAPI(a, nil, "")
+
+	output.AddMetric(testutil.TestMetric(1))
+	w, body := apiRequest(t, api, "GET", "/status")
+	assert.Equal(t, http.StatusOK, w.Code)
+	inputs := body["inputs"].([]interface{})
+	require.Len(t, inputs, 1)
+	assert.Equal(t, "i1", inputs[0].(map[string]interface{})["id"])
+	outputs := body["outputs"].([]interface{})
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "memory", outputs[0].(map[string]interface{})["buffer_type"])
+	assert.Equal(t, float64(1), outputs[0].(map[string]interface{})["buffer_size"])
+	assert.Equal(t, float64(10000), outputs[0].(map[string]interface{})["buffer_limit"])
+}
+
+func TestAPI_Metrics(t *testing.T) {
+	newReloadOutput("api_metrics", "o1")
+	a, _ := NewAgent(config.NewConfig())
+	api := NewAPI(a, nil, "")
+
+	w, _ := apiRequest(t, api, "GET", "/metrics")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "\ninternal_write_buffer_limit{output=\"api_metrics\"} 10000\n")
+}
+
+func TestAPI_Reload(t *testing.T) {
+	var restarted []string
+	var reloadErr error
+	api := NewAPI(nil, func(restart []string) (*ReloadResult, error) {
+		restarted = restart
+		if reloadErr != nil {
+			return nil, reloadErr
+		}
+		return &ReloadResult{Changed: restart, Unchanged: 2}, nil
+	}, "")
+
+	w, body := apiRequest(t, api, "POST", "/reload?restart=inputs.tableprov")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"inputs.tableprov"}, restarted)
+	assert.Equal(t, float64(2), body["unchanged"])
+
+	reloadErr = ErrRestartRequired
+	w, _ = apiRequest(t, api, "POST", "/reload")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Nil(t, restarted)
+
+	reloadErr = errors.New("invalid config")
+	w, body = apiRequest(t, api, "POST", "/reload")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "invalid config", body["error"])
+
+	w, _ = apiRequest(t, api, "GET", "/reload")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestAPI_Flush(t *testing.T) {
+	c := config.NewConfig()
+	output := newReloadOutput("api_flush", "o1")
+	c.Outputs = append(c.Outputs, output)
+	a, _ := NewAgent(c)
+	stop := runAgent(t, a)
+	defer stop()
+	api := NewAPI(a, nil, "")
+
+	output.AddMetric(testutil.TestMetric(1))
+	w, body := apiRequest(t, api, "POST", "/flush")
+	assert.Equal(t, http.StatusOK, w.Code)
+	outputs := body["outputs"].([]interface{})
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "outputs.api_flush", outputs[0].(map[string]interface{})["name"])
+
+	o := output.Output.(*reloadOutput)
+	o.Lock()
+	assert.Equal(t, []string{"test1"}, o.written)
+	o.Unlock()
+	assert.False(t, output.LastWrite().IsZero())
+	assert.Equal(t, 0, output.BufferLength())
+}
+
+func TestAPI_FlushNotConnected(t *testing.T) {
+	c := config.NewConfig()
+	output := models.NewRunningOutput("api_flush_down", &flakyOutput{failures: 1000},
+		&models.OutputConfig{Name: "api_flush_down"}, 0, 0)
+	c.Outputs = append(c.Outputs, output)
+	a, _ := NewAgent(c)
+	stop := runAgent(t, a)
+	defer stop()
+	api := NewAPI(a, nil, "")
+
+	output.AddMetric(testutil.TestMetric(1))
+	w, body := apiRequest(t, api, "POST", "/flush")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	outputs := body["outputs"].([]interface{})
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "output outputs.api_flush_down is not connected",
+		outputs[0].(map[string]interface{})["error"])
+	assert.Equal(t, 1, output.BufferLength())
+}
+
+func TestAPI_Token(t *testing.T) {
+	reloaded := 0
+	api := NewAPI(nil, func(restart []string) (*ReloadResult, error) {
+		reloaded++
+		return &ReloadResult{}, nil
+	}, "secret")
+
+	w, _ := apiRequest(t, api, "POST", "/reload")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	req := httptest.NewRequest("POST", "/reload", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	w = httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 0, reloaded)
+
+	req = httptest.NewRequest("POST", "/reload", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, reloaded)
+}
+
+func TestAPI_StartLoopbackOnly(t *testing.T) {
+	api := NewAPI(nil, nil, "")
+	assert.Error(t, api.Start(":0"))
+	assert.Error(t, api.Start("0.0.0.0:0"))
+	require.NoError(t, api.Start("127.0.0.1:0"))
+	api.Stop()
+
+	api = NewAPI(nil, nil, "secret")
+	require.NoError(t, api.Start(":0"))
+	api.Stop()
+}
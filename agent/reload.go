@@ -0,0 +1,357 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// ErrRestartRequired is returned by Reload when the new configuration cannot
+// be applied to the running agent.
+var ErrRestartRequired = errors.New("configuration change requires a restart of the agent")
+
+// ReloadResult lists the plugin instances changed by a reload.
+type ReloadResult struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Changed   []string `json:"changed"`
+	Failed    []string `json:"failed"`
+	Unchanged int      `json:"unchanged"`
+}
+
+func (r *ReloadResult) String() string {
+	s := fmt.Sprintf("added [%s], removed [%s], changed [%s], %d unchanged",
+		strings.Join(r.Added, " "), strings.Join(r.Removed, " "),
+		strings.Join(r.Changed, " "), r.Unchanged)
+	if len(r.Failed) > 0 {
+		s += fmt.Sprintf(", failed to start [%s]", strings.Join(r.Failed, " "))
+	}
+	return s
+}
+
+// add records the instances stopped and started for a kind of plugin. A
+// stopped and a started instance of the same plugin are reported as changed.
+func (r *ReloadResult) add(stopped, started []string, unchanged int) {
+	for _, name := range started {
+		i := indexOf(stopped, name)
+		if i < 0 {
+			r.Added = append(r.Added, name)
+			continue
+		}
+		r.Changed = append(r.Changed, name)
+		stopped = append(stopped[:i], stopped[i+1:]...)
+	}
+	r.Removed = append(r.Removed, stopped...)
+	r.Unchanged += unchanged
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// match pairs the configured plugin instances with the running instances of
+// the same ID. For each configured instance it returns the index of the
+// running instance kept in its place, or -1 when it must be started, and for
+// each running instance whether it is kept.
+func match(running, configured []string) ([]int, []bool) {
+	kept := make([]int, len(configured))
+	keep := make([]bool, len(running))
+	for i, id := range configured {
+		kept[i] = -1
+		for j, rid := range running {
+			if !keep[j] && rid == id {
+				kept[i] = j
+				keep[j] = true
+				break
+			}
+		}
+	}
+	return kept, keep
+}
+
+// Reload applies the plugins of the new configuration to the running agent.
+// Plugin instances are identified by the hash of their configuration: the
+// unchanged instances keep running, with the buffers of the outputs, while
+// the removed and changed instances are stopped and the added and changed
+// instances are started.
+//
+// ErrRestartRequired is returned, and nothing is changed, when the agent
+// settings or the global tags changed, or when the processors or aggregators
+// are added to or removed from the pipeline.
+//
+// The running inputs named in restart, such as "inputs.tableprov", are
+// restarted even when unchanged.
+func (a *Agent) Reload(c *config.Config, restart ...string) (*ReloadResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.ctx == nil || a.ctx.Err() != nil {
+		return nil, errors.New("agent is not running")
+	}
+
+	if !reflect.DeepEqual(a.Config.Agent, c.Agent) ||
+		!reflect.DeepEqual(a.Config.Tags, c.Tags) {
+		return nil, ErrRestartRequired
+	}
+	// The processor and aggregator stages only run when configured.
+	if (len(a.Config.Processors) == 0) != (len(c.Processors) == 0) ||
+		(len(a.Config.Aggregators) == 0) != (len(c.Aggregators) == 0) {
+		return nil, ErrRestartRequired
+	}
+
+	result := &ReloadResult{}
+	a.reloadOutputs(c.Outputs, result)
+	a.reloadProcessors(c.Processors, result)
+	a.reloadAggregators(c.Aggregators, result)
+	a.reloadInputs(c.Inputs, restart, result)
+	return result, nil
+}
+
+func (a *Agent) reloadInputs(
+	configured []*models.RunningInput,
+	restart []string,
+	result *ReloadResult,
+) {
+	running := a.Config.Inputs
+	ids := inputIDs(running)
+	for i, input := range running {
+		if indexOf(restart, input.Name()) >= 0 || indexOf(restart, input.LogName()) >= 0 {
+			ids[i] = ""
+		}
+	}
+	kept, keep := match(ids, inputIDs(configured))
+
+	var stopped []string
+	for i, input := range running {
+		if keep[i] {
+			continue
+		}
+		a.inputs[input].stop()
+		delete(a.inputs, input)
+		if si, ok := input.Input.(telegraf.ServiceInput); ok {
+			si.Stop()
+		}
+		input.Processors.Stop()
+		stopped = append(stopped, input.LogName())
+	}
+
+	var started []string
+	inputs := make([]*models.RunningInput, 0, len(configured))
+	for i, input := range configured {
+		if kept[i] >= 0 {
+			inputs = append(inputs, running[kept[i]])
+			continue
+		}
+		if err := input.Processors.Start(); err != nil {
+			log.Printf("E! [agent] %v", err)
+			result.Failed = append(result.Failed, input.LogName())
+			continue
+		}
+		if err := startServiceInput(input, a.inputDst); err != nil {
+			input.Processors.Stop()
+			result.Failed = append(result.Failed, input.LogName())
+			continue
+		}
+		a.startInput(input)
+		inputs = append(inputs, input)
+		started = append(started, input.LogName())
+	}
+
+	a.Config.Inputs = inputs
+	result.add(stopped, started, countKept(kept))
+}
+
+func (a *Agent) reloadProcessors(configured models.RunningProcessors, result *ReloadResult) {
+	running := a.Config.Processors
+	kept, keep := match(processorIDs(running), processorIDs(configured))
+
+	var stopped, started []string
+	for i, processor := range running {
+		if !keep[i] {
+			stopped = append(stopped, processor.LogName())
+		}
+	}
+
+	processors := make(models.RunningProcessors, 0, len(configured))
+	for i, processor := range configured {
+		if kept[i] >= 0 {
+			processors = append(processors, running[kept[i]])
+			continue
+		}
+		if err := processor.Start(); err != nil {
+			log.Printf("E! [agent] %v", err)
+			result.Failed = append(result.Failed, processor.LogName())
+			continue
+		}
+		processors = append(processors, processor)
+		started = append(started, processor.LogName())
+	}
+
+	a.pmu.Lock()
+	a.Config.Processors = processors
+	a.pmu.Unlock()
+
+	// The removed processors are no longer applied once replaced.
+	for i, processor := range running {
+		if !keep[i] {
+			processor.Stop()
+		}
+	}
+	result.add(stopped, started, countKept(kept))
+}
+
+func (a *Agent) reloadAggregators(configured []*models.RunningAggregator, result *ReloadResult) {
+	running := a.Config.Aggregators
+	kept, keep := match(aggregatorIDs(running), aggregatorIDs(configured))
+
+	var added []*models.RunningAggregator
+	aggregators := make([]*models.RunningAggregator, 0, len(configured))
+	for i, agg := range configured {
+		if kept[i] >= 0 {
+			aggregators = append(aggregators, running[kept[i]])
+			continue
+		}
+		aggregators = append(aggregators, agg)
+		added = append(added, agg)
+	}
+
+	a.pmu.Lock()
+	a.Config.Aggregators = aggregators
+	a.pmu.Unlock()
+
+	// The removed aggregators no longer receive metrics, they push their
+	// last aggregations when stopped.
+	var stopped, started []string
+	for i, agg := range running {
+		if keep[i] {
+			continue
+		}
+		a.aggregators[agg].stop()
+		delete(a.aggregators, agg)
+		stopped = append(stopped, agg.LogName())
+	}
+	for _, agg := range added {
+		a.startAggregator(agg)
+		started = append(started, agg.LogName())
+	}
+	result.add(stopped, started, countKept(kept))
+}
+
+func (a *Agent) reloadOutputs(configured []*models.RunningOutput, result *ReloadResult) {
+	running := a.Config.Outputs
+	kept, keep := match(outputIDs(running), outputIDs(configured))
+
+	// Stop routing metrics to the removed outputs before their last flush.
+	var outputs []*models.RunningOutput
+	for i := range configured {
+		if kept[i] >= 0 {
+			outputs = append(outputs, running[kept[i]])
+		}
+	}
+	a.setOutputs(outputs)
+
+	var stopped []string
+	for i, output := range running {
+		if keep[i] {
+			continue
+		}
+		a.outputs[output].stop()
+		delete(a.outputs, output)
+		if err := output.Close(); err != nil {
+			log.Printf("E! [agent] Error closing output %s: %v", output.LogName(), err)
+		}
+		output.Processors.Stop()
+		stopped = append(stopped, output.LogName())
+	}
+
+	// The disk buffer of a changed output is only opened once the previous
+	// instance closed it, so that its metrics are replayed.
+	var started []string
+	outputs = make([]*models.RunningOutput, 0, len(configured))
+	for i, output := range configured {
+		if kept[i] >= 0 {
+			outputs = append(outputs, running[kept[i]])
+			continue
+		}
+		if err := output.Processors.Start(); err != nil {
+			log.Printf("E! [agent] %v", err)
+			result.Failed = append(result.Failed, output.LogName())
+			continue
+		}
+		if err := openBuffer(output); err != nil {
+			log.Printf("E! [agent] %v", err)
+			output.Processors.Stop()
+			result.Failed = append(result.Failed, output.LogName())
+			continue
+		}
+		a.startOutput(output)
+		outputs = append(outputs, output)
+		started = append(started, output.LogName())
+	}
+
+	a.setOutputs(outputs)
+	result.add(stopped, started, countKept(kept))
+}
+
+// setOutputs replaces the outputs the metrics are routed to.
+func (a *Agent) setOutputs(outputs []*models.RunningOutput) {
+	a.pmu.Lock()
+	defer a.pmu.Unlock()
+
+	a.Config.Outputs = outputs
+	a.router = newRouter(outputs)
+}
+
+// countKept returns the number of running instances kept.
+func countKept(kept []int) int {
+	n := 0
+	for _, i := range kept {
+		if i >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func inputIDs(inputs []*models.RunningInput) []string {
+	ids := make([]string, len(inputs))
+	for i, input := range inputs {
+		ids[i] = input.Config.ID
+	}
+	return ids
+}
+
+func processorIDs(processors models.RunningProcessors) []string {
+	ids := make([]string, len(processors))
+	for i, processor := range processors {
+		ids[i] = processor.Config.ID
+	}
+	return ids
+}
+
+func aggregatorIDs(aggregators []*models.RunningAggregator) []string {
+	ids := make([]string, len(aggregators))
+	for i, agg := range aggregators {
+		ids[i] = agg.Config.ID
+	}
+	return ids
+}
+
+func outputIDs(outputs []*models.RunningOutput) []string {
+	ids := make([]string, len(outputs))
+	for i, output := range outputs {
+		ids[i] = output.Config.ID
+	}
+	return ids
+}
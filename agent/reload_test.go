@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type reloadInput struct{}
+
+func (i *reloadInput) Description() string  { return "" }
+func (i *reloadInput) SampleConfig() string { return "" }
+
+func (i *reloadInput) Gather(acc telegraf.Accumulator) error {
+	acc.AddFields("reload", map[string]interface{}{"value": 1}, nil)
+	return nil
+}
+
+type reloadOutput struct {
+	sync.Mutex
+	closed  bool
+	written []string
+}
+
+func (o *reloadOutput) Connect() error       { return nil }
+func (o *reloadOutput) Description() string  { return "" }
+func (o *reloadOutput) SampleConfig() string { return "" }
+
+func (o *reloadOutput) Write(metrics []telegraf.Metric) error {
+	o.Lock()
+	defer o.Unlock()
+	for _, m := range metrics {
+		o.written = append(o.written, m.Name())
+	}
+	return nil
+}
+
+func (o *reloadOutput) Close() error {
+	o.Lock()
+	defer o.Unlock()
+	o.closed = true
+	return nil
+}
+
+func (o *reloadOutput) isClosed() bool {
+	o.Lock()
+	defer o.Unlock()
+	return o.closed
+}
+
+// serviceProcessor records whether its service is running.
+type serviceProcessor struct {
+	sync.Mutex
+	running bool
+	starts  int
+}
+
+func (p *serviceProcessor) Description() string  { return "" }
+func (p *serviceProcessor) SampleConfig() string { return "" }
+
+func (p *serviceProcessor) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	return in
+}
+
+func (p *serviceProcessor) Start() error {
+	p.Lock()
+	defer p.Unlock()
+	p.running = true
+	p.starts++
+	return nil
+}
+
+func (p *serviceProcessor) Stop() {
+	p.Lock()
+	defer p.Unlock()
+	p.running = false
+}
+
+func (p *serviceProcessor) isRunning() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.running
+}
+
+func newServiceProcessor(id string) *models.RunningProcessor {
+	return &models.RunningProcessor{
+		Name:      "service",
+		Processor: &serviceProcessor{},
+		Config:    &models.ProcessorConfig{Name: "service", ID: id},
+	}
+}
+
+func newReloadInput(id string) *models.RunningInput {
+	return models.NewRunningInput(&reloadInput{},
+		&models.InputConfig{Name: "reload", ID: id})
+}
+
+func newReloadOutput(name, id string) *models.RunningOutput {
+	return models.NewRunningOutput(name, &reloadOutput{},
+		&models.OutputConfig{Name: name, ID: id}, 0, 0)
+}
+
+// runAgent runs the agent until the returned function is called.
+func runAgent(t *testing.T, a *Agent) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- a.Run(ctx)
+	}()
+	waitFor(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.ctx != nil
+	})
+	return func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	kept, keep := match([]string{"a", "b", "a"}, []string{"a", "c", "a", "a"})
+	assert.Equal(t, []int{0, -1, 2, -1}, kept)
+	assert.Equal(t, []bool{true, false, true}, keep)
+	assert.Equal(t, 2, countKept(kept))
+}
+
+func TestReloadResult(t *testing.T) {
+	r := &ReloadResult{}
+	r.add([]string{"inputs.cpu", "inputs.mem"}, []string{"inputs.mem", "inputs.disk"}, 2)
+	r.add(nil, nil, 1)
+	assert.Equal(t, []string{"inputs.disk"}, r.Added)
+	assert.Equal(t, []string{"inputs.cpu"}, r.Removed)
+	assert.Equal(t, []string{"inputs.mem"}, r.Changed)
+	assert.Equal(t, 3, r.Unchanged)
+	assert.Equal(t, "added [inputs.disk], removed [inputs.cpu], changed [inputs.mem], 3 unchanged",
+		r.String())
+}
+
+func TestReload(t *testing.T) {
+	c := config.NewConfig()
+	input := newReloadInput("i1")
+	kept := newReloadOutput("kept", "o1")
+	removed := newReloadOutput("removed", "o2")
+	c.Inputs = append(c.Inputs, input)
+	c.Outputs = append(c.Outputs, kept, removed)
+
+	a, _ := NewAgent(c)
+	stop := runAgent(t, a)
+
+	kept.AddMetric(testutil.TestMetric(1))
+
+	c2 := config.NewConfig()
+	added := newReloadOutput("added", "o3")
+	c2.Inputs = append(c2.Inputs, newReloadInput("i1"), newReloadInput("i2"))
+	c2.Outputs = append(c2.Outputs, newReloadOutput("kept", "o1"), added)
+
+	result, err := a.Reload(c2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"outputs.added", "inputs.reload"}, result.Added)
+	assert.Equal(t, []string{"outputs.removed"}, result.Removed)
+	assert.Empty(t, result.Changed)
+	assert.Empty(t, result.Failed)
+	assert.Equal(t, 2, result.Unchanged)
+
+	// The unchanged instances keep running with their buffered metrics.
+	assert.Equal(t, []*models.RunningOutput{kept, added}, a.Config.Outputs)
+	assert.Equal(t, input, a.Config.Inputs[0])
+	assert.True(t, removed.Output.(*reloadOutput).isClosed())
+	assert.Len(t, a.inputs, 2)
+	assert.Len(t, a.outputs, 2)
+
+	// The metric buffered before the reload is flushed on shutdown.
+	stop()
+	assert.Contains(t, kept.Output.(*reloadOutput).written, "test1")
+	assert.True(t, kept.Output.(*reloadOutput).isClosed())
+	assert.True(t, added.Output.(*reloadOutput).isClosed())
+}
+
+func TestReloadServiceProcessors(t *testing.T) {
+	c := config.NewConfig()
+	kept := newServiceProcessor("p1")
+	removed := newServiceProcessor("p2")
+	c.Processors = append(c.Processors, kept, removed)
+	input := newReloadInput("i1")
+	input.Processors = append(input.Processors, newServiceProcessor("p3"))
+	c.Inputs = append(c.Inputs, input)
+	c.Outputs = append(c.Outputs, newReloadOutput("kept", "o1"))
+
+	a, _ := NewAgent(c)
+	stop := runAgent(t, a)
+
+	// The processors are started with the agent.
+	assert.True(t, kept.Processor.(*serviceProcessor).isRunning())
+	assert.True(t, removed.Processor.(*serviceProcessor).isRunning())
+	inputProcessor := input.Processors[0].Processor.(*serviceProcessor)
+	assert.True(t, inputProcessor.isRunning())
+
+	c2 := config.NewConfig()
+	added := newServiceProcessor("p4")
+	c2.Processors = append(c2.Processors, newServiceProcessor("p1"), added)
+	changed := newReloadInput("i2")
+	changed.Processors = append(changed.Processors, newServiceProcessor("p3"))
+	c2.Inputs = append(c2.Inputs, changed)
+	c2.Outputs = append(c2.Outputs, newReloadOutput("kept", "o1"))
+
+	_, err := a.Reload(c2)
+	require.NoError(t, err)
+
+	// The kept processors keep running, the others are swapped.
+	assert.True(t, kept.Processor.(*serviceProcessor).isRunning())
+	assert.Equal(t, 1, kept.Processor.(*serviceProcessor).starts)
+	assert.False(t, removed.Processor.(*serviceProcessor).isRunning())
+	assert.True(t, added.Processor.(*serviceProcessor).isRunning())
+	assert.False(t, inputProcessor.isRunning())
+	assert.True(t, changed.Processors[0].Processor.(*serviceProcessor).isRunning())
+
+	// They are stopped with the agent.
+	stop()
+	assert.False(t, kept.Processor.(*serviceProcessor).isRunning())
+	assert.False(t, added.Processor.(*serviceProcessor).isRunning())
+	assert.False(t, changed.Processors[0].Processor.(*serviceProcessor).isRunning())
+}
+
+func TestReloadRestartRequired(t *testing.T) {
+	c := config.NewConfig()
+	c.Outputs = append(c.Outputs, newReloadOutput("kept", "o1"))
+	a, _ := NewAgent(c)
+
+	c2 := config.NewConfig()
+	_, err := a.Reload(c2)
+	assert.EqualError(t, err, "agent is not running")
+
+	stop := runAgent(t, a)
+	defer stop()
+
+	c2.Tags["dc"] = "us-east-1"
+	_, err = a.Reload(c2)
+	assert.Equal(t, ErrRestartRequired, err)
+
+	c2 = config.NewConfig()
+	c2.Agent.FlushInterval.Duration = 42
+	_, err = a.Reload(c2)
+	assert.Equal(t, ErrRestartRequired, err)
+
+	c2 = config.NewConfig()
+	c2.Processors = append(c2.Processors, &models.RunningProcessor{
+		Name:   "printer",
+		Config: &models.ProcessorConfig{Name: "printer", ID: "p1"},
+	})
+	_, err = a.Reload(c2)
+	assert.Equal(t, ErrRestartRequired, err)
+
+	// Nothing changed on the running agent.
+	assert.Len(t, a.Config.Outputs, 1)
+	assert.Len(t, a.outputs, 1)
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+var metricsUnrouted = selfstat.Register("agent", "metrics_unrouted", map[string]string{})
+
+// router selects the outputs a metric is delivered to based on its route tag.
+type router struct {
+	// enabled is false when no output is subscribed to a route, in which
+	// case every metric goes to every output.
+	enabled bool
+
+	all      []*models.RunningOutput
+	unrouted []*models.RunningOutput
+	wildcard []*models.RunningOutput
+	routes   map[string][]*models.RunningOutput
+}
+
+func newRouter(outputs []*models.RunningOutput) *router {
+	r := &router{
+		all:    outputs,
+		routes: make(map[string][]*models.RunningOutput),
+	}
+
+	for _, output := range outputs {
+		if len(output.Config.Routes) > 0 {
+			r.enabled = true
+		}
+	}
+	if !r.enabled {
+		return r
+	}
+
+	// Build the output list of each route, keeping the configured order of
+	// the outputs.
+	for _, output := range outputs {
+		for _, route := range output.Config.Routes {
+			if route == models.RouteAll {
+				r.wildcard = append(r.wildcard, output)
+				continue
+			}
+			if _, ok := r.routes[route]; !ok {
+				r.routes[route] = nil
+			}
+		}
+	}
+	for _, output := range outputs {
+		if len(output.Config.Routes) == 0 || subscribed(output, models.RouteAll) {
+			r.unrouted = append(r.unrouted, output)
+		}
+		for route := range r.routes {
+			if subscribed(output, route) || subscribed(output, models.RouteAll) {
+				r.routes[route] = append(r.routes[route], output)
+			}
+		}
+	}
+	return r
+}
+
+func subscribed(output *models.RunningOutput, route string) bool {
+	for _, r := range output.Config.Routes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// outputs returns the outputs the metric should be delivered to. The route
+// tag is removed from the metric.
+func (r *router) outputs(metric telegraf.Metric) []*models.RunningOutput {
+	route, ok := metric.GetTag(models.RouteTag)
+	if ok {
+		metric.RemoveTag(models.RouteTag)
+	}
+	if !r.enabled {
+		return r.all
+	}
+	if !ok {
+		return r.unrouted
+	}
+
+	if outputs, ok := r.routes[route]; ok {
+		return outputs
+	}
+	return r.wildcard
+}
+
+// route delivers the metric to its outputs. The metric is only copied for
+// the outputs which receive it, the last one takes ownership of the original.
+func (r *router) route(metric telegraf.Metric) {
+	outputs := r.outputs(metric)
+	if len(outputs) == 0 {
+		metricsUnrouted.Incr(1)
+		metric.Drop()
+		return
+	}
+
+	for i, output := range outputs {
+		if i == len(outputs)-1 {
+			output.AddMetric(metric)
+		} else {
+			output.AddMetric(metric.Copy())
+		}
+	}
+}
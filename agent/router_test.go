@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRoutedOutput(name string, routes ...string) *models.RunningOutput {
+	conf := &models.OutputConfig{Name: name, Routes: routes}
+	return models.NewRunningOutput(name, nil, conf, 0, 0)
+}
+
+func outputNames(outputs []*models.RunningOutput) []string {
+	var names []string
+	for _, output := range outputs {
+		names = append(names, output.Name)
+	}
+	return names
+}
+
+func TestRouterDisabled(t *testing.T) {
+	r := newRouter([]*models.RunningOutput{
+		newRoutedOutput("akamill"),
+		newRoutedOutput("http"),
+	})
+
+	m := testutil.TestMetric(1)
+	m.AddTag(models.RouteTag, "tableprov")
+	assert.Equal(t, []string{"akamill", "http"}, outputNames(r.outputs(m)))
+	assert.False(t, m.HasTag(models.RouteTag))
+}
+
+func TestRouterKeepsRouteNamedTag(t *testing.T) {
+	r := newRouter([]*models.RunningOutput{
+		newRoutedOutput("akamill", "tableprov"),
+		newRoutedOutput("http"),
+	})
+
+	// A tag of the metric named route does not select the outputs.
+	m := testutil.TestMetric(1)
+	m.AddTag("route", "tableprov")
+	assert.Equal(t, []string{"http"}, outputNames(r.outputs(m)))
+	assert.True(t, m.HasTag("route"))
+}
+
+func TestRouterOutputs(t *testing.T) {
+	r := newRouter([]*models.RunningOutput{
+		newRoutedOutput("akamill", "tableprov"),
+		newRoutedOutput("http", "system"),
+		newRoutedOutput("file"),
+		newRoutedOutput("debug", "*"),
+	})
+
+	m := testutil.TestMetric(1)
+	assert.Equal(t, []string{"file", "debug"}, outputNames(r.outputs(m)))
+
+	m.AddTag(models.RouteTag, "tableprov")
+	assert.Equal(t, []string{"akamill", "debug"}, outputNames(r.outputs(m)))
+	assert.False(t, m.HasTag(models.RouteTag))
+
+	m.AddTag(models.RouteTag, "system")
+	assert.Equal(t, []string{"http", "debug"}, outputNames(r.outputs(m)))
+
+	m.AddTag(models.RouteTag, "unknown")
+	assert.Equal(t, []string{"debug"}, outputNames(r.outputs(m)))
+}
+
+func TestRouterMultipleRoutes(t *testing.T) {
+	r := newRouter([]*models.RunningOutput{
+		newRoutedOutput("akamill", "tableprov", "system"),
+		newRoutedOutput("http", "system"),
+	})
+
+	m := testutil.TestMetric(1)
+	assert.Empty(t, r.outputs(m))
+
+	m.AddTag(models.RouteTag, "system")
+	assert.Equal(t, []string{"akamill", "http"}, outputNames(r.outputs(m)))
+
+	m.AddTag(models.RouteTag, "tableprov")
+	assert.Equal(t, []string{"akamill"}, outputNames(r.outputs(m)))
+}
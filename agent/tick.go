@@ -8,23 +8,60 @@ import (
 	"github.com/influxdata/telegraf/internal"
 )
 
+// Schedule gives the times a periodic task runs at.
+type Schedule interface {
+	// Next returns the first time after t the task runs at, or the zero
+	// time when it never runs again.
+	Next(t time.Time) time.Time
+}
+
+// every is a schedule running every interval from its start.
+type every struct {
+	start    time.Time
+	interval time.Duration
+}
+
+// Every returns a schedule running every interval from start.
+func Every(start time.Time, interval time.Duration) Schedule {
+	return &every{start: start, interval: interval}
+}
+
+func (e *every) Next(t time.Time) time.Time {
+	if t.Before(e.start) {
+		return e.start
+	}
+	n := t.Sub(e.start)/e.interval + 1
+	return e.start.Add(n * e.interval)
+}
+
+// Ticker sends the times of a schedule on C. The times are delayed by a
+// random jitter, and dropped while the previous one was not received.
 type Ticker struct {
 	C          chan time.Time
-	ticker     *time.Ticker
+	schedule   Schedule
 	jitter     time.Duration
 	wg         sync.WaitGroup
 	cancelFunc context.CancelFunc
 }
 
+// NewTicker returns a ticker sending the time every interval.
 func NewTicker(
 	interval time.Duration,
 	jitter time.Duration,
+) *Ticker {
+	return NewScheduleTicker(Every(time.Now(), interval), jitter)
+}
+
+// NewScheduleTicker returns a ticker sending the times of the schedule.
+func NewScheduleTicker(
+	schedule Schedule,
+	jitter time.Duration,
 ) *Ticker {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	t := &Ticker{
 		C:          make(chan time.Time, 1),
-		ticker:     time.NewTicker(interval),
+		schedule:   schedule,
 		jitter:     jitter,
 		cancelFunc: cancel,
 	}
@@ -42,16 +79,21 @@ func (t *Ticker) Stop() {
 
 func (t *Ticker) relayTime(ctx context.Context) {
 	defer t.wg.Done()
-	for {
-		select {
-		case tm := <-t.ticker.C:
-			internal.SleepContext(ctx, internal.RandomDuration(t.jitter))
-			select {
-			case t.C <- tm:
-			default:
-			}
-		case <-ctx.Done():
+
+	next := t.schedule.Next(time.Now())
+	for !next.IsZero() {
+		err := internal.SleepContext(ctx, time.Until(next))
+		if err != nil {
 			return
 		}
+
+		internal.SleepContext(ctx, internal.RandomDuration(t.jitter))
+		select {
+		case t.C <- next:
+		default:
+		}
+
+		// The times passed meanwhile are skipped.
+		next = t.schedule.Next(time.Now())
 	}
 }
@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvery(t *testing.T) {
+	start := time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+	s := Every(start, 10*time.Second)
+
+	assert.Equal(t, start, s.Next(start.Add(-time.Hour)))
+	assert.Equal(t, start.Add(10*time.Second), s.Next(start))
+	assert.Equal(t, start.Add(30*time.Second), s.Next(start.Add(25*time.Second)))
+}
+
+func TestScheduleTicker(t *testing.T) {
+	schedule, err := internal.ParseCronSchedule("* * * * * *")
+	require.NoError(t, err)
+	ticker := NewScheduleTicker(schedule, 0)
+	defer ticker.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case tm := <-ticker.C:
+			assert.Equal(t, tm, tm.Truncate(time.Second))
+		case <-time.After(3 * time.Second):
+			t.Fatal("no tick")
+		}
+	}
+}
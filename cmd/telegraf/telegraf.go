@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"flag"
@@ -13,6 +14,7 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/influxdata/telegraf/agent"
 	"github.com/influxdata/telegraf/internal"
@@ -23,7 +25,10 @@ import (
 	_ "github.com/influxdata/telegraf/plugins/inputs/all"
 	"github.com/influxdata/telegraf/plugins/outputs"
 	_ "github.com/influxdata/telegraf/plugins/outputs/all"
+	"github.com/influxdata/telegraf/plugins/processors"
 	_ "github.com/influxdata/telegraf/plugins/processors/all"
+	"github.com/influxdata/telegraf/plugins/serializers"
+	"github.com/influxdata/telegraf/selfstat"
 	"github.com/kardianos/service"
 )
 
@@ -34,9 +39,24 @@ var pprofAddr = flag.String("pprof-addr", "",
 var fQuiet = flag.Bool("quiet", false,
 	"run in quiet mode")
 var fTest = flag.Bool("test", false, "gather metrics, print them out, and exit")
-var fConfig = flag.String("config", "", "configuration file to load")
+var fTestWait = flag.Duration("test-wait", 5*time.Second,
+	"how long the service inputs run in --test and --once modes")
+var fTestFormat = flag.String("test-format", "influx",
+	"data format of the metrics printed by --test")
+var fOnce = flag.Bool("once", false,
+	"gather, process, aggregate and write the metrics to the outputs once, and exit")
+var fConfig = flag.String("config", "",
+	"configuration file to load, or HTTP(S) URL to fetch it from")
 var fConfigDirectory = flag.String("config-directory", "",
 	"directory containing additional *.conf files")
+var fConfigCheck = flag.Bool("config-check", false,
+	"load and check the config file and directory, and exit")
+var fWatchConfig = flag.Bool("watch-config", false,
+	"reload the config when the config file or the *.conf files of the config directory change")
+var fPrintEffectiveConfig = flag.Bool("print-effective-config", false,
+	"print the config the agent runs with, with the plugin defaults, and exit")
+var fEffectiveConfigFormat = flag.String("effective-config-format", "toml",
+	"format of the config printed by --print-effective-config, toml or json")
 var fVersion = flag.Bool("version", false, "display the version and exit")
 var fSampleConfig = flag.Bool("sample-config", false,
 	"print out full sample configuration")
@@ -53,6 +73,8 @@ var fAggregatorFilters = flag.String("aggregator-filter", "",
 	"filter the aggregators to enable, separator is :")
 var fProcessorFilters = flag.String("processor-filter", "",
 	"filter the processors to enable, separator is :")
+var fTestRestriction = flag.String("test-restriction", "",
+	"evaluate a restriction file against the table names given as arguments")
 var fUsage = flag.String("usage", "",
 	"print usage for a plugin, ie, 'telegraf --usage mysql'")
 var fService = flag.String("service", "",
@@ -68,6 +90,15 @@ var (
 
 var stop chan struct{}
 
+// watchDebounce is how long the config files must stop changing before they
+// are reloaded by --watch-config.
+const watchDebounce = 2 * time.Second
+
+var (
+	configReloads      = selfstat.Register("agent", "config_reloads", map[string]string{})
+	configReloadErrors = selfstat.Register("agent", "config_reload_errors", map[string]string{})
+)
+
 func reloadLoop(
 	stop chan struct{},
 	inputFilters []string,
@@ -82,24 +113,44 @@ func reloadLoop(
 
 		ctx, cancel := context.WithCancel(context.Background())
 
-		signals := make(chan os.Signal)
+		// A SIGHUP reloads the config in the running agent, which is only
+		// restarted when the change cannot be applied in place.
+		hup := make(chan struct{}, 1)
+		restart := func() {
+			<-reload
+			reload <- true
+			cancel()
+		}
+
+		signals := make(chan os.Signal, 1)
 		signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
 			syscall.SIGTERM, syscall.SIGINT)
 		go func() {
-			select {
-			case sig := <-signals:
-				if sig == syscall.SIGHUP {
-					log.Printf("I! Reloading Telegraf config")
-					<-reload
-					reload <- true
+			for {
+				select {
+				case sig := <-signals:
+					if sig == syscall.SIGHUP {
+						log.Printf("I! Reloading Telegraf config")
+						select {
+						case hup <- struct{}{}:
+						default:
+						}
+						continue
+					}
+					cancel()
+					return
+				case <-stop:
+					cancel()
+					return
+				case <-ctx.Done():
+					return
 				}
-				cancel()
-			case <-stop:
-				cancel()
 			}
 		}()
 
-		err := runAgent(ctx, inputFilters, outputFilters)
+		err := runAgent(ctx, hup, restart, inputFilters, outputFilters)
+		signal.Stop(signals)
+		cancel()
 		if err != nil {
 			log.Fatalf("E! [telegraf] Error running agent: %v", err)
 		}
@@ -107,6 +158,8 @@ func reloadLoop(
 }
 
 func runAgent(ctx context.Context,
+	hup <-chan struct{},
+	restart func(),
 	inputFilters []string,
 	outputFilters []string,
 ) error {
@@ -116,51 +169,28 @@ func runAgent(ctx context.Context,
 	log.Printf("I! Starting Telegraf %s", version)
 
 	// If no other options are specified, load the config file and run.
-	c := config.NewConfig()
-	c.OutputFilters = outputFilters
-	c.InputFilters = inputFilters
-	err := c.LoadConfig(*fConfig)
+	c, err := loadConfig(inputFilters, outputFilters)
 	if err != nil {
 		return err
 	}
 
-	if *fConfigDirectory != "" {
-		err = c.LoadDirectory(*fConfigDirectory)
-		if err != nil {
-			return err
-		}
-	}
-	if !*fTest && len(c.Outputs) == 0 {
-		return errors.New("Error: no outputs found, did you provide a valid config file?")
-	}
-	if len(c.Inputs) == 0 {
-		return errors.New("Error: no inputs found, did you provide a valid config file?")
-	}
-
-	if int64(c.Agent.Interval.Duration) <= 0 {
-		return fmt.Errorf("Agent interval must be positive, found %s",
-			c.Agent.Interval.Duration)
-	}
-
-	if int64(c.Agent.FlushInterval.Duration) <= 0 {
-		return fmt.Errorf("Agent flush_interval must be positive; found %s",
-			c.Agent.Interval.Duration)
-	}
-
 	ag, err := agent.NewAgent(c)
 	if err != nil {
 		return err
 	}
 
 	// Setup logging as configured.
-	logger.SetupLogging(
-		ag.Config.Agent.Debug || *fDebug,
-		ag.Config.Agent.Quiet || *fQuiet,
-		ag.Config.Agent.Logfile,
-	)
+	err = logger.SetupLoggingConfig(logConfig(c))
+	if err != nil {
+		return err
+	}
 
 	if *fTest {
-		return ag.Test(ctx)
+		serializer, err := testSerializer(*fTestFormat)
+		if err != nil {
+			return err
+		}
+		return ag.Test(ctx, *fTestWait, os.Stdout, serializer)
 	}
 
 	log.Printf("I! Loaded inputs: %s", strings.Join(c.InputNames(), " "))
@@ -187,9 +217,213 @@ func runAgent(ctx context.Context,
 		}
 	}
 
+	if *fOnce {
+		return ag.Once(ctx, *fTestWait)
+	}
+
+	reload := func(restartInputs []string) (*agent.ReloadResult, error) {
+		return reloadAgent(ag, restart, inputFilters, outputFilters, restartInputs)
+	}
+
+	if c.Agent.APIAddress != "" {
+		api := agent.NewAPI(ag, reload, c.Agent.APIToken)
+		if err := api.Start(c.Agent.APIAddress); err != nil {
+			return err
+		}
+		defer api.Stop()
+	}
+
+	// A config given by URL is reloaded when it changes.
+	var refresh <-chan struct{}
+	if config.IsURL(*fConfig) && c.Agent.ConfigRefreshInterval.Duration > 0 {
+		refresh, err = config.WatchRemote(ctx, *fConfig, c.Agent.ConfigRefreshInterval.Duration)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The config files are reloaded once they stopped changing for
+	// watchDebounce.
+	var watch <-chan struct{}
+	if *fWatchConfig {
+		watch, err = config.WatchFiles(ctx, *fConfig, *fConfigDirectory, watchDebounce)
+		if err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+				reload(nil)
+			case <-refresh:
+				log.Printf("I! [telegraf] Config %s changed, reloading", *fConfig)
+				reload(nil)
+			case <-watch:
+				log.Printf("I! [telegraf] Config files changed, reloading")
+				reload(nil)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
 	return ag.Run(ctx)
 }
 
+// logConfig returns the logging configuration of the agent.
+func logConfig(c *config.Config) logger.Config {
+	return logger.Config{
+		Debug:               c.Agent.Debug || *fDebug,
+		Quiet:               c.Agent.Quiet || *fQuiet,
+		Logfile:             c.Agent.Logfile,
+		Format:              c.Agent.LogFormat,
+		Levels:              c.Agent.LogLevels,
+		RotationInterval:    c.Agent.LogfileRotationInterval.Duration,
+		RotationMaxSize:     c.Agent.LogfileRotationMaxSize.Size,
+		RotationMaxArchives: c.Agent.LogfileRotationMaxArchives,
+	}
+}
+
+// testSerializer returns the serializer of the metrics printed by --test,
+// tableprov_csv chunks are decoded to text.
+func testSerializer(format string) (serializers.Serializer, error) {
+	if format == "tableprov_csv" {
+		format = "tableprov_csv_text"
+	}
+	return serializers.NewSerializer(&serializers.Config{
+		DataFormat:       format,
+		InfluxSortFields: true,
+		TimestampUnits:   time.Second,
+	})
+}
+
+// loadConfig loads and validates the config file and directory.
+func loadConfig(inputFilters []string, outputFilters []string) (*config.Config, error) {
+	c := config.NewConfig()
+	c.OutputFilters = outputFilters
+	c.InputFilters = inputFilters
+	c.Check = *fConfigCheck
+	err := c.LoadConfig(*fConfig)
+	if err != nil && !c.Check {
+		return nil, err
+	}
+
+	// A check reports the errors of the config file and directory together.
+	if *fConfigDirectory != "" {
+		derr := c.LoadDirectory(*fConfigDirectory)
+		if err == nil {
+			err = derr
+		} else if derr != nil {
+			err = fmt.Errorf("%v\n%v", err, derr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.AddSecrets(c.Secrets()...)
+
+	if !*fTest && len(c.Outputs) == 0 {
+		return nil, errors.New("Error: no outputs found, did you provide a valid config file?")
+	}
+	if len(c.Inputs) == 0 {
+		return nil, errors.New("Error: no inputs found, did you provide a valid config file?")
+	}
+
+	if int64(c.Agent.Interval.Duration) <= 0 {
+		return nil, fmt.Errorf("Agent interval must be positive, found %s",
+			c.Agent.Interval.Duration)
+	}
+
+	if int64(c.Agent.FlushInterval.Duration) <= 0 {
+		return nil, fmt.Errorf("Agent flush_interval must be positive; found %s",
+			c.Agent.Interval.Duration)
+	}
+	return c, nil
+}
+
+// reloadAgent applies the config to the running agent, restarting the inputs
+// named in restartInputs. The running config is kept when the new one is
+// invalid, and the agent is restarted when the new one cannot be applied in
+// place.
+func reloadAgent(
+	ag *agent.Agent,
+	restart func(),
+	inputFilters []string,
+	outputFilters []string,
+	restartInputs []string,
+) (*agent.ReloadResult, error) {
+	c, err := loadConfig(inputFilters, outputFilters)
+	if err != nil {
+		log.Printf("E! [telegraf] Error loading config, keeping the running config: %v", err)
+		configReloadErrors.Incr(1)
+		return nil, err
+	}
+
+	result, err := ag.Reload(c, restartInputs...)
+	switch {
+	case err == agent.ErrRestartRequired:
+		log.Printf("I! [telegraf] Restarting agent, %v", err)
+		configReloads.Incr(1)
+		restart()
+	case err != nil:
+		log.Printf("E! [telegraf] Error reloading config: %v", err)
+		configReloadErrors.Incr(1)
+	default:
+		log.Printf("I! [telegraf] Reloaded config: %s", result)
+		configReloads.Incr(1)
+	}
+	return result, err
+}
+
+// testFile prints the results of the file for the inputs, using the
+// processor registered for the test. The inputs are read from stdin if none
+// are given. When a config file is given, the first processor of the config
+// performing the test is used, with its options.
+func testFile(test string, path string, inputs []string) error {
+	name, ok := processors.Testers[test]
+	if !ok {
+		return fmt.Errorf("no processor performs the %s test", test)
+	}
+
+	var tester processors.Tester
+	if *fConfig != "" {
+		c := config.NewConfig()
+		if err := c.LoadConfig(*fConfig); err != nil {
+			return err
+		}
+		for _, p := range c.Processors {
+			if t, ok := p.Processor.(processors.Tester); ok && p.Name == name {
+				tester = t
+				break
+			}
+		}
+	}
+	if tester == nil {
+		creator, ok := processors.Processors[name]
+		if !ok {
+			return fmt.Errorf("undefined processor %s for the %s test", name, test)
+		}
+		if tester, ok = creator().(processors.Tester); !ok {
+			return fmt.Errorf("processor %s cannot perform the %s test", name, test)
+		}
+	}
+
+	if len(inputs) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if input := strings.TrimSpace(scanner.Text()); input != "" {
+				inputs = append(inputs, input)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return tester.TestFile(os.Stdout, path, inputs)
+}
+
 func usageExit(rc int) {
 	fmt.Println(internal.Usage)
 	os.Exit(rc)
@@ -323,6 +557,27 @@ func main() {
 			processorFilters,
 		)
 		return
+	case *fTestRestriction != "":
+		err := testFile("restriction", *fTestRestriction, args)
+		if err != nil {
+			log.Fatalf("E! %s", err)
+		}
+		return
+	case *fConfigCheck:
+		if _, err := loadConfig(inputFilters, outputFilters); err != nil {
+			log.Fatalf("E! %s", err)
+		}
+		fmt.Println("Config OK")
+		return
+	case *fPrintEffectiveConfig:
+		c, err := loadConfig(inputFilters, outputFilters)
+		if err != nil {
+			log.Fatalf("E! %s", err)
+		}
+		if err := c.PrintEffectiveConfig(os.Stdout, *fEffectiveConfigFormat); err != nil {
+			log.Fatalf("E! %s", err)
+		}
+		return
 	case *fUsage != "":
 		err := config.PrintInputConfig(*fUsage)
 		err2 := config.PrintOutputConfig(*fUsage)
@@ -2,14 +2,18 @@ package config
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
 
+	"reflect"
 	"regexp"
 	"runtime"
 	"sort"
@@ -39,13 +43,13 @@ var (
 	// Default output plugins
 	outputDefaults = []string{"influxdb"}
 
-	// envVarRe is a regex to find environment variables in the config file
-	envVarRe = regexp.MustCompile(`\$\w+`)
+	// envVarRe is a regex to find environment variables in the config file,
+	// $VAR or ${VAR...}, and the secret files, ${file:/path}
+	envVarRe = regexp.MustCompile(`\$\{[^}]*\}|\$\w+`)
 
-	envVarEscaper = strings.NewReplacer(
-		`"`, `\"`,
-		`\`, `\\`,
-	)
+	// fileRefRe is a regex to find the secret files given as a whole string
+	// in the config file, "@file:/path"
+	fileRefRe = regexp.MustCompile(`"@file:[^"]+"|'@file:[^']+'`)
 )
 
 // Config specifies the URL/user/password for the database that telegraf
@@ -62,6 +66,27 @@ type Config struct {
 	Aggregators []*models.RunningAggregator
 	// Processors have a slice wrapper type because they need to be sorted
 	Processors models.RunningProcessors
+
+	// Check rejects the unknown options even when strict_config is
+	// disabled, for checking the config.
+	Check bool
+
+	// unknownOptions are the options of the file being loaded matching no
+	// option of their plugin.
+	unknownOptions []unknownOption
+
+	// secrets are the values read from secret files.
+	secrets []string
+
+	// source is the config file being loaded.
+	source string
+}
+
+// unknownOption is an option of the config matching no option of its plugin.
+type unknownOption struct {
+	line   int
+	key    string
+	plugin string
 }
 
 func NewConfig() *Config {
@@ -71,6 +96,9 @@ func NewConfig() *Config {
 			Interval:      internal.Duration{Duration: 10 * time.Second},
 			RoundInterval: true,
 			FlushInterval: internal.Duration{Duration: 10 * time.Second},
+
+			LogfileRotationMaxArchives: 5,
+			StrictConfig:               true,
 		},
 
 		Tags:          make(map[string]string),
@@ -125,6 +153,19 @@ type AgentConfig struct {
 	// not be less than 2 times MetricBatchSize.
 	MetricBufferLimit int
 
+	// MetricBufferBytesLimit limits the estimated size of the metrics each
+	// output buffers. No limit when 0.
+	MetricBufferBytesLimit internal.Size
+
+	// OverflowPolicy is applied by the outputs when their buffer is full:
+	// "drop_oldest" (default), "drop_newest" or "block_inputs".
+	OverflowPolicy string
+
+	// BufferDirectory is the directory holding the disk buffers of the
+	// outputs with buffer_type = "disk", in a subdirectory named after each
+	// output.
+	BufferDirectory string
+
 	// FlushBufferWhenFull tells Telegraf to flush the metric buffer whenever
 	// it fills up, regardless of FlushInterval. Setting this option to true
 	// does _not_ deactivate FlushInterval.
@@ -141,10 +182,43 @@ type AgentConfig struct {
 	// Logfile specifies the file to send logs to
 	Logfile string
 
+	// LogFormat is the format of the logs, either "text" or "json".
+	LogFormat string `toml:"log_format"`
+
+	// LogLevels overrides the log level of plugins, by plugin name.
+	LogLevels map[string]string `toml:"log_levels"`
+
+	// LogfileRotationInterval rotates the logfile once opened for that long,
+	// LogfileRotationMaxSize before it grows over that size. The logfile is
+	// never rotated when both are 0.
+	LogfileRotationInterval internal.Duration `toml:"logfile_rotation_interval"`
+	LogfileRotationMaxSize  internal.Size     `toml:"logfile_rotation_max_size"`
+
+	// LogfileRotationMaxArchives is the number of rotated logfiles kept, -1
+	// to keep them all.
+	LogfileRotationMaxArchives int `toml:"logfile_rotation_max_archives"`
+
 	// Quiet is the option for running in quiet mode
 	Quiet        bool
 	Hostname     string
 	OmitHostname bool
+
+	// APIAddress is the address of the HTTP API serving the health, status
+	// and internal metrics of the agent, disabled when empty.
+	APIAddress string `toml:"api_address"`
+
+	// APIToken is required as a bearer token by the POST endpoints of the
+	// API. Without it the API is only served on a loopback address.
+	APIToken string `toml:"api_token"`
+
+	// ConfigRefreshInterval polls the config file given by URL at this
+	// interval, reloading the config when it changed. Disabled when 0.
+	ConfigRefreshInterval internal.Duration `toml:"config_refresh_interval"`
+
+	// StrictConfig rejects the config when an option matches no option of
+	// its plugin, ie a misspelled option. When disabled, such options are
+	// logged and ignored.
+	StrictConfig bool `toml:"strict_config"`
 }
 
 // Inputs returns a list of strings of the configured inputs.
@@ -240,6 +314,20 @@ var header = `# Telegraf Configuration
   ## This buffer only fills when writes fail to output plugin(s).
   metric_buffer_limit = 10000
 
+  ## Limit on the estimated size of the metrics buffered by each output, ie
+  ## "100MB". Applies in addition to metric_buffer_limit.
+  # metric_buffer_bytes_limit = "0"
+
+  ## What outputs do with new metrics when their buffer is full:
+  ##   drop_oldest  - overwrite the oldest metrics (default)
+  ##   drop_newest  - drop the new metrics
+  ##   block_inputs - hold back the inputs until the output catches up
+  # overflow_policy = "drop_oldest"
+
+  ## Directory of the buffers of outputs with buffer_type = "disk". Each output
+  ## keeps its unsent metrics in a subdirectory named after the output.
+  # buffer_directory = "/var/lib/telegraf/buffer"
+
   ## Collection jitter is used to jitter the collection by a random amount.
   ## Each plugin will sleep for a random time within jitter before collecting.
   ## This can be used to avoid many plugins querying things like sysfs at the
@@ -270,12 +358,42 @@ var header = `# Telegraf Configuration
   quiet = false
   ## Specify the log file name. The empty string means to log to stderr.
   logfile = ""
+  ## Log format, "text" or "json" for a record per line with the time, level,
+  ## plugin_type, plugin_name and message fields.
+  # log_format = "text"
+  ## Log level of specific plugins, overriding debug and quiet; one of
+  ## "debug", "info", "warn" or "error".
+  # log_levels = { "inputs.tableprov" = "debug" }
+
+  ## Rotate the log file once it is this old, or before it grows over this
+  ## size. The log file is not rotated when both are 0.
+  # logfile_rotation_interval = "0h"
+  # logfile_rotation_max_size = "0MB"
+  ## Number of rotated log files to keep, older ones are removed. -1 keeps
+  ## all of them.
+  # logfile_rotation_max_archives = 5
 
   ## Override default hostname, if empty use os.Hostname()
   hostname = ""
   ## If set to true, do no set the "host" tag in the telegraf agent.
   omit_hostname = false
 
+  ## Address of the HTTP API serving the health, status and internal metrics
+  ## of the agent, and reloading its config. Disabled when empty.
+  # api_address = "localhost:8099"
+  ## Token required by the POST endpoints of the API, as an
+  ## "Authorization: Bearer <token>" header. Without it the API address must
+  ## be a loopback address.
+  # api_token = "${TELEGRAF_API_TOKEN}"
+
+  ## Poll the config file, when given by URL, at this interval and reload
+  ## the config when it changed. Disabled when 0.
+  # config_refresh_interval = "0s"
+
+  ## Reject the config when an option matches no option of its plugin, ie a
+  ## misspelled option. When false, such options are logged and ignored.
+  # strict_config = true
+
 
 ###############################################################################
 #                            OUTPUT PLUGINS                                   #
@@ -532,6 +650,7 @@ func PrintOutputConfig(name string) error {
 }
 
 func (c *Config) LoadDirectory(path string) error {
+	var errs []string
 	walkfn := func(thispath string, info os.FileInfo, _ error) error {
 		if info == nil {
 			log.Printf("W! Telegraf is not permitted to read %s", thispath)
@@ -552,18 +671,27 @@ func (c *Config) LoadDirectory(path string) error {
 		}
 		err := c.LoadConfig(thispath)
 		if err != nil {
-			return err
+			if !c.Check {
+				return err
+			}
+			// The files are all checked, reporting all their errors.
+			errs = append(errs, err.Error())
 		}
 		return nil
 	}
-	return filepath.Walk(path, walkfn)
+	if err := filepath.Walk(path, walkfn); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
 }
 
 // Try to find a default config file at these locations (in order):
-//   1. $TELEGRAF_CONFIG_PATH
-//   2. $HOME/.telegraf/telegraf.conf
-//   3. /etc/telegraf/telegraf.conf
-//
+//  1. $TELEGRAF_CONFIG_PATH, which may be an HTTP(S) URL
+//  2. $HOME/.telegraf/telegraf.conf
+//  3. /etc/telegraf/telegraf.conf
 func getDefaultConfigPath() (string, error) {
 	envfile := os.Getenv("TELEGRAF_CONFIG_PATH")
 	homefile := os.ExpandEnv("${HOME}/.telegraf/telegraf.conf")
@@ -571,6 +699,10 @@ func getDefaultConfigPath() (string, error) {
 	if runtime.GOOS == "windows" {
 		etcfile = `C:\Program Files\Telegraf\telegraf.conf`
 	}
+	if IsURL(envfile) {
+		log.Printf("I! Using config file: %s", envfile)
+		return envfile, nil
+	}
 	for _, path := range []string{envfile, homefile, etcfile} {
 		if _, err := os.Stat(path); err == nil {
 			log.Printf("I! Using config file: %s", path)
@@ -591,10 +723,16 @@ func (c *Config) LoadConfig(path string) error {
 			return err
 		}
 	}
-	tbl, err := parseFile(path)
+	contents, err := readFile(path)
+	if err != nil {
+		return fmt.Errorf("Error reading %s, %s", path, err)
+	}
+	tbl, err := c.parseContents(contents)
 	if err != nil {
 		return fmt.Errorf("Error parsing %s, %s", path, err)
 	}
+	c.source = path
+	c.unknownOptions = nil
 
 	// Parse tags tables first:
 	for _, tableName := range []string{"tags", "global_tags"} {
@@ -616,7 +754,7 @@ func (c *Config) LoadConfig(path string) error {
 		if !ok {
 			return fmt.Errorf("%s: invalid configuration", path)
 		}
-		if err = toml.UnmarshalTable(subTable, c.Agent); err != nil {
+		if err = c.unmarshalTable("agent", subTable, c.Agent); err != nil {
 			log.Printf("E! Could not parse [agent] config\n")
 			return fmt.Errorf("Error parsing %s, %s", path, err)
 		}
@@ -709,9 +847,99 @@ func (c *Config) LoadConfig(path string) error {
 	if len(c.Processors) > 1 {
 		sort.Sort(c.Processors)
 	}
+	if err := c.checkUnknownOptions(path); err != nil {
+		return err
+	}
+
+	if IsURL(path) {
+		r, err := getRemote(path)
+		if err == nil {
+			err = r.save(contents)
+		}
+		if err != nil {
+			log.Printf("W! Could not cache config %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// checkUnknownOptions reports the unknown options of the file, it returns
+// them as an error in strict mode, otherwise they are logged.
+func (c *Config) checkUnknownOptions(path string) error {
+	if len(c.unknownOptions) == 0 {
+		return nil
+	}
+	sort.Slice(c.unknownOptions, func(i, j int) bool {
+		return c.unknownOptions[i].line < c.unknownOptions[j].line
+	})
+
+	lines := make([]string, 0, len(c.unknownOptions))
+	for _, o := range c.unknownOptions {
+		lines = append(lines, fmt.Sprintf("%s:%d: unknown option %q in %s",
+			path, o.line, o.key, o.plugin))
+	}
+	if c.Agent.StrictConfig || c.Check {
+		return fmt.Errorf("Error parsing %s, %d unknown options:\n  %s",
+			path, len(lines), strings.Join(lines, "\n  "))
+	}
+	for _, line := range lines {
+		log.Printf("W! Ignoring %s", line)
+	}
 	return nil
 }
 
+// unmarshalTable unmarshals the table into the plugin, recording the keys
+// matching no option of the plugin instead of failing on the first one.
+func (c *Config) unmarshalTable(plugin string, table *ast.Table, v interface{}) error {
+	conf := toml.DefaultConfig
+	conf.MissingField = func(typ reflect.Type, key string) error {
+		line, ok := findLine(table, key)
+		if !ok {
+			line = table.Line
+		}
+		c.unknownOptions = append(c.unknownOptions, unknownOption{line, key, plugin})
+		return nil
+	}
+	return conf.UnmarshalTable(table, v)
+}
+
+// setAlias gives its alias to a plugin naming its instance in its own logs.
+func setAlias(plugin interface{}, alias string) {
+	if p, ok := plugin.(telegraf.AliasedPlugin); ok {
+		p.SetAlias(alias)
+	}
+}
+
+// findLine returns the line of the key in the table or, for the options of
+// nested structs, in its subtables.
+func findLine(table *ast.Table, key string) (int, bool) {
+	if field, ok := table.Fields[key]; ok {
+		switch v := field.(type) {
+		case *ast.KeyValue:
+			return v.Line, true
+		case *ast.Table:
+			return v.Line, true
+		case []*ast.Table:
+			return v[0].Line, true
+		}
+	}
+	for _, field := range table.Fields {
+		var subtables []*ast.Table
+		switch v := field.(type) {
+		case *ast.Table:
+			subtables = []*ast.Table{v}
+		case []*ast.Table:
+			subtables = v
+		}
+		for _, t := range subtables {
+			if line, ok := findLine(t, key); ok {
+				return line, true
+			}
+		}
+	}
+	return 0, false
+}
+
 // trimBOM trims the Byte-Order-Marks from the beginning of the file.
 // this is for Windows compatibility only.
 // see https://github.com/influxdata/telegraf/issues/1378
@@ -719,34 +947,100 @@ func trimBOM(f []byte) []byte {
 	return bytes.TrimPrefix(f, []byte("\xef\xbb\xbf"))
 }
 
-// escapeEnv escapes a value for inserting into a TOML string.
+// escapeEnv escapes a value for inserting into a TOML basic string: the
+// quotes, the backslashes and the control characters, ie the newlines of a
+// multi-line secret.
 func escapeEnv(value string) string {
-	return envVarEscaper.Replace(value)
+	var buf bytes.Buffer
+	for _, r := range value {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&buf, `\u%04X`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	return buf.String()
 }
 
-// parseFile loads a TOML configuration from a provided path and
-// returns the AST produced from the TOML parser. When loading the file, it
-// will find environment variables and replace them.
-func parseFile(fpath string) (*ast.Table, error) {
-	contents, err := ioutil.ReadFile(fpath)
+// readFile reads the config file at the path, or fetches it when the path
+// is an HTTP(S) URL.
+func readFile(fpath string) ([]byte, error) {
+	if !IsURL(fpath) {
+		return ioutil.ReadFile(fpath)
+	}
+	r, err := getRemote(fpath)
 	if err != nil {
 		return nil, err
 	}
+	contents, _, err := r.fetch()
+	return contents, err
+}
+
+// parseContents parses the contents of a config file and returns the AST
+// produced from the TOML parser. The environment variables and the secret
+// files are replaced before parsing.
+func (c *Config) parseContents(contents []byte) (*ast.Table, error) {
 	// ugh windows why
 	contents = trimBOM(contents)
 
-	env_vars := envVarRe.FindAll(contents, -1)
-	for _, env_var := range env_vars {
-		env_val, ok := os.LookupEnv(strings.TrimPrefix(string(env_var), "$"))
-		if ok {
-			env_val = escapeEnv(env_val)
-			contents = bytes.Replace(contents, env_var, []byte(env_val), 1)
-		}
+	contents, err := c.substitute(contents)
+	if err != nil {
+		return nil, err
 	}
-
 	return toml.Parse(contents)
 }
 
+// pluginID returns the identity of a plugin instance, a hash of its
+// configuration table. It must be computed before the table is built, since
+// building removes the parsed fields.
+func pluginID(kind string, name string, table *ast.Table) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s.%s\n", kind, name)
+	hashTable(h, table)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// hashTable writes the fields of the table in key order.
+func hashTable(w io.Writer, table *ast.Table) {
+	keys := make([]string, 0, len(table.Fields))
+	for key := range table.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch v := table.Fields[key].(type) {
+		case *ast.KeyValue:
+			fmt.Fprintf(w, "%s=%s\n", key, v.Value.Source())
+		case *ast.Table:
+			fmt.Fprintf(w, "[%s]\n", key)
+			hashTable(w, v)
+		case []*ast.Table:
+			for _, t := range v {
+				fmt.Fprintf(w, "[[%s]]\n", key)
+				hashTable(w, t)
+			}
+		}
+	}
+}
+
 func (c *Config) addAggregator(name string, table *ast.Table) error {
 	creator, ok := aggregators.Aggregators[name]
 	if !ok {
@@ -754,43 +1048,99 @@ func (c *Config) addAggregator(name string, table *ast.Table) error {
 	}
 	aggregator := creator()
 
+	id := pluginID("aggregators", name, table)
 	conf, err := buildAggregator(name, table)
 	if err != nil {
 		return err
 	}
+	conf.ID = id
+	conf.Source = c.source
 
-	if err := toml.UnmarshalTable(table, aggregator); err != nil {
+	if err := c.unmarshalTable("aggregators."+name, table, aggregator); err != nil {
 		return err
 	}
+	setAlias(aggregator, conf.Alias)
 
 	c.Aggregators = append(c.Aggregators, models.NewRunningAggregator(aggregator, conf))
 	return nil
 }
 
 func (c *Config) addProcessor(name string, table *ast.Table) error {
+	rf, err := c.newProcessor("processors", name, table)
+	if err != nil {
+		return err
+	}
+	c.Processors = append(c.Processors, rf)
+	return nil
+}
+
+// newProcessor creates the processor of the table, prefix is the path of the
+// processors table in the messages.
+func (c *Config) newProcessor(prefix, name string, table *ast.Table) (*models.RunningProcessor, error) {
 	creator, ok := processors.Processors[name]
 	if !ok {
-		return fmt.Errorf("Undefined but requested processor: %s", name)
+		return nil, fmt.Errorf("Undefined but requested processor: %s", name)
 	}
 	processor := creator()
 
+	id := pluginID("processors", name, table)
 	processorConfig, err := buildProcessor(name, table)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	processorConfig.ID = id
+	processorConfig.Source = c.source
 
-	if err := toml.UnmarshalTable(table, processor); err != nil {
-		return err
+	if err := c.unmarshalTable(prefix+"."+name, table, processor); err != nil {
+		return nil, err
 	}
+	setAlias(processor, processorConfig.Alias)
 
-	rf := &models.RunningProcessor{
+	return &models.RunningProcessor{
 		Name:      name,
 		Processor: processor,
 		Config:    processorConfig,
+	}, nil
+}
+
+// buildProcessors creates the processors attached to a plugin, in the
+// processors table of the plugin, sorted by their order. Processors of the
+// same order are kept in the order of their names, then of their tables. The
+// table is removed, it is not an option of the plugin.
+func (c *Config) buildProcessors(plugin string, tbl *ast.Table) (models.RunningProcessors, error) {
+	node, ok := tbl.Fields["processors"]
+	if !ok {
+		return nil, nil
 	}
+	delete(tbl.Fields, "processors")
 
-	c.Processors = append(c.Processors, rf)
-	return nil
+	subTable, ok := node.(*ast.Table)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported config format: %s.processors", plugin)
+	}
+	names := make([]string, 0, len(subTable.Fields))
+	for name := range subTable.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var processors models.RunningProcessors
+	for _, name := range names {
+		tables, ok := subTable.Fields[name].([]*ast.Table)
+		if !ok {
+			return nil, fmt.Errorf("Unsupported config format: %s.processors.%s",
+				plugin, name)
+		}
+		for _, t := range tables {
+			rf, err := c.newProcessor(plugin+".processors", name, t)
+			if err != nil {
+				return nil, err
+			}
+			processors = append(processors, rf)
+		}
+	}
+	sort.Stable(processors)
+	return processors, nil
 }
 
 func (c *Config) addOutput(name string, table *ast.Table) error {
@@ -802,6 +1152,7 @@ func (c *Config) addOutput(name string, table *ast.Table) error {
 		return fmt.Errorf("Undefined but requested output: %s", name)
 	}
 	output := creator()
+	id := pluginID("outputs", name, table)
 
 	// If the output has a SetSerializer function, then this means it can write
 	// arbitrary types of output, so build the serializer and set it.
@@ -818,17 +1169,72 @@ func (c *Config) addOutput(name string, table *ast.Table) error {
 	if err != nil {
 		return err
 	}
+	outputConfig.ID = id
+	outputConfig.Source = c.source
+
+	processors, err := c.buildProcessors("outputs."+name, table)
+	if err != nil {
+		return err
+	}
 
-	if err := toml.UnmarshalTable(table, output); err != nil {
+	if err := c.unmarshalTable("outputs."+name, table, output); err != nil {
 		return err
 	}
+	setAlias(output, outputConfig.Alias)
+
+	if outputConfig.MetricBufferBytesLimit == 0 {
+		outputConfig.MetricBufferBytesLimit = c.Agent.MetricBufferBytesLimit.Size
+	}
+	if outputConfig.OverflowPolicy == "" {
+		outputConfig.OverflowPolicy = c.Agent.OverflowPolicy
+	}
+	switch outputConfig.OverflowPolicy {
+	case "":
+		outputConfig.OverflowPolicy = models.OverflowDropOldest
+	case models.OverflowDropOldest, models.OverflowDropNewest, models.OverflowBlockInputs:
+	default:
+		return fmt.Errorf("invalid overflow_policy %q for output %s", outputConfig.OverflowPolicy, name)
+	}
 
 	ro := models.NewRunningOutput(name, output, outputConfig,
 		c.Agent.MetricBatchSize, c.Agent.MetricBufferLimit)
+	ro.Processors = processors
+	if outputConfig.BufferType == "disk" {
+		if err := c.setDiskBufferDirectory(ro); err != nil {
+			return err
+		}
+	}
 	c.Outputs = append(c.Outputs, ro)
 	return nil
 }
 
+// setDiskBufferDirectory sets the directory of the disk buffer of the output,
+// in the agent buffer_directory unless the output sets its own directory. The
+// buffer is opened by the agent when the output starts.
+func (c *Config) setDiskBufferDirectory(ro *models.RunningOutput) error {
+	conf := &ro.Config.DiskBuffer
+	if conf.Directory == "" {
+		if c.Agent.BufferDirectory == "" {
+			return fmt.Errorf("buffer_directory must be set for the disk buffer of output %s",
+				ro.Name)
+		}
+		dir := ro.Name
+		if ro.Config.Alias != "" {
+			dir += "-" + ro.Config.Alias
+		}
+		conf.Directory = filepath.Join(c.Agent.BufferDirectory, dir)
+	}
+
+	for _, other := range c.Outputs {
+		if other.Config.BufferType == "disk" &&
+			filepath.Clean(other.Config.DiskBuffer.Directory) == filepath.Clean(conf.Directory) {
+			return fmt.Errorf("outputs %s and %s share the buffer directory %s, set buffer_directory on the output",
+				other.LogName(), ro.LogName(), conf.Directory)
+		}
+	}
+	return nil
+}
+
 func (c *Config) addInput(name string, table *ast.Table) error {
 	if len(c.InputFilters) > 0 && !sliceContains(name, c.InputFilters) {
 		return nil
@@ -843,6 +1249,7 @@ func (c *Config) addInput(name string, table *ast.Table) error {
 		return fmt.Errorf("Undefined but requested input: %s", name)
 	}
 	input := creator()
+	id := pluginID("inputs", name, table)
 
 	// If the input has a SetParser function, then this means it can accept
 	// arbitrary types of input, so build the parser and set it.
@@ -859,12 +1266,21 @@ func (c *Config) addInput(name string, table *ast.Table) error {
 	if err != nil {
 		return err
 	}
+	pluginConfig.ID = id
+	pluginConfig.Source = c.source
 
-	if err := toml.UnmarshalTable(table, input); err != nil {
+	processors, err := c.buildProcessors("inputs."+name, table)
+	if err != nil {
 		return err
 	}
 
+	if err := c.unmarshalTable("inputs."+name, table, input); err != nil {
+		return err
+	}
+	setAlias(input, pluginConfig.Alias)
+
 	rp := models.NewRunningInput(input, pluginConfig)
+	rp.Processors = processors
 	c.Inputs = append(c.Inputs, rp)
 	return nil
 }
@@ -887,6 +1303,14 @@ func buildAggregator(name string, tbl *ast.Table) (*models.AggregatorConfig, err
 		Period: time.Second * 30,
 	}
 
+	if node, ok := tbl.Fields["alias"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				conf.Alias = str.Value
+			}
+		}
+	}
+
 	if node, ok := tbl.Fields["period"]; ok {
 		if kv, ok := node.(*ast.KeyValue); ok {
 			if str, ok := kv.Value.(*ast.String); ok {
@@ -913,6 +1337,11 @@ func buildAggregator(name string, tbl *ast.Table) (*models.AggregatorConfig, err
 		}
 	}
 
+	var err error
+	if conf.Schedule, err = buildSchedule(tbl); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", name, err)
+	}
+
 	if node, ok := tbl.Fields["drop_original"]; ok {
 		if kv, ok := node.(*ast.KeyValue); ok {
 			if b, ok := kv.Value.(*ast.Boolean); ok {
@@ -958,14 +1387,15 @@ func buildAggregator(name string, tbl *ast.Table) (*models.AggregatorConfig, err
 		}
 	}
 
+	delete(tbl.Fields, "alias")
 	delete(tbl.Fields, "period")
 	delete(tbl.Fields, "delay")
+	delete(tbl.Fields, "schedule")
 	delete(tbl.Fields, "drop_original")
 	delete(tbl.Fields, "name_prefix")
 	delete(tbl.Fields, "name_suffix")
 	delete(tbl.Fields, "name_override")
 	delete(tbl.Fields, "tags")
-	var err error
 	conf.Filter, err = buildFilter(tbl)
 	if err != nil {
 		return conf, err
@@ -986,6 +1416,14 @@ func buildProcessor(name string, tbl *ast.Table) (*models.ProcessorConfig, error
 		}
 	}
 
+	if node, ok := tbl.Fields["alias"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				conf.Alias = str.Value
+			}
+		}
+	}
+
 	if node, ok := tbl.Fields["order"]; ok {
 		if kv, ok := node.(*ast.KeyValue); ok {
 			if b, ok := kv.Value.(*ast.Integer); ok {
@@ -998,6 +1436,7 @@ func buildProcessor(name string, tbl *ast.Table) (*models.ProcessorConfig, error
 		}
 	}
 
+	delete(tbl.Fields, "alias")
 	delete(tbl.Fields, "order")
 	var err error
 	conf.Filter, err = buildFilter(tbl)
@@ -1162,6 +1601,14 @@ func buildInput(name string, tbl *ast.Table) (*models.InputConfig, error) {
 		}
 	}
 
+	if node, ok := tbl.Fields["alias"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				cp.Alias = str.Value
+			}
+		}
+	}
+
 	if node, ok := tbl.Fields["name_prefix"]; ok {
 		if kv, ok := node.(*ast.KeyValue); ok {
 			if str, ok := kv.Value.(*ast.String); ok {
@@ -1186,6 +1633,44 @@ func buildInput(name string, tbl *ast.Table) (*models.InputConfig, error) {
 		}
 	}
 
+	if node, ok := tbl.Fields["route"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				cp.Route = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["gather_timeout"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				dur, err := time.ParseDuration(str.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				cp.GatherTimeout = dur
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["skip_if_running"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if b, ok := kv.Value.(*ast.Boolean); ok {
+				var err error
+				cp.SkipIfRunning, err = b.Boolean()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	var err error
+	if cp.Schedule, err = buildSchedule(tbl); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", name, err)
+	}
+
 	cp.Tags = make(map[string]string)
 	if node, ok := tbl.Fields["tags"]; ok {
 		if subtbl, ok := node.(*ast.Table); ok {
@@ -1195,12 +1680,16 @@ func buildInput(name string, tbl *ast.Table) (*models.InputConfig, error) {
 		}
 	}
 
+	delete(tbl.Fields, "alias")
+	delete(tbl.Fields, "route")
+	delete(tbl.Fields, "gather_timeout")
+	delete(tbl.Fields, "skip_if_running")
+	delete(tbl.Fields, "schedule")
 	delete(tbl.Fields, "name_prefix")
 	delete(tbl.Fields, "name_suffix")
 	delete(tbl.Fields, "name_override")
 	delete(tbl.Fields, "interval")
 	delete(tbl.Fields, "tags")
-	var err error
 	cp.Filter, err = buildFilter(tbl)
 	if err != nil {
 		return cp, err
@@ -1208,6 +1697,32 @@ func buildInput(name string, tbl *ast.Table) (*models.InputConfig, error) {
 	return cp, nil
 }
 
+// buildSchedule parses the cron expression of the schedule option, returning
+// nil when it is not set.
+func buildSchedule(tbl *ast.Table) (*internal.CronSchedule, error) {
+	node, ok := tbl.Fields["schedule"]
+	if !ok {
+		return nil, nil
+	}
+	kv, ok := node.(*ast.KeyValue)
+	if !ok {
+		return nil, nil
+	}
+	str, ok := kv.Value.(*ast.String)
+	if !ok {
+		return nil, fmt.Errorf("schedule must be a string")
+	}
+
+	schedule, err := internal.ParseCronSchedule(str.Value)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", str.Value)
+	}
+	return schedule, nil
+}
+
 // buildParser grabs the necessary entries from the ast.Table for creating
 // a parsers.Parser object, and creates it, which can then be added onto
 // an Input object.
@@ -1338,6 +1853,130 @@ func buildParser(name string, tbl *ast.Table) (parsers.Parser, error) {
 		}
 	}
 
+	if node, ok := tbl.Fields["csv_column_names"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if ary, ok := kv.Value.(*ast.Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*ast.String); ok {
+						c.CSVColumnNames = append(c.CSVColumnNames, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_column_types"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if ary, ok := kv.Value.(*ast.Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*ast.String); ok {
+						c.CSVColumnTypes = append(c.CSVColumnTypes, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_comment"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				c.CSVComment = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_delimiter"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				c.CSVDelimiter = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_header_row_count"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if integer, ok := kv.Value.(*ast.Integer); ok {
+				v, err := integer.Int()
+				if err != nil {
+					return nil, err
+				}
+				c.CSVHeaderRowCount = int(v)
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_measurement_column"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				c.CSVMeasurementColumn = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_skip_columns"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if integer, ok := kv.Value.(*ast.Integer); ok {
+				v, err := integer.Int()
+				if err != nil {
+					return nil, err
+				}
+				c.CSVSkipColumns = int(v)
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_skip_rows"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if integer, ok := kv.Value.(*ast.Integer); ok {
+				v, err := integer.Int()
+				if err != nil {
+					return nil, err
+				}
+				c.CSVSkipRows = int(v)
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_tag_columns"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if ary, ok := kv.Value.(*ast.Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*ast.String); ok {
+						c.CSVTagColumns = append(c.CSVTagColumns, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_timestamp_column"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				c.CSVTimestampColumn = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_timestamp_format"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				c.CSVTimestampFormat = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["csv_trim_space"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if b, ok := kv.Value.(*ast.Boolean); ok {
+				v, err := b.Boolean()
+				if err != nil {
+					return nil, err
+				}
+				c.CSVTrimSpace = v
+			}
+		}
+	}
+
 	c.MetricName = name
 
 	delete(tbl.Fields, "data_format")
@@ -1353,6 +1992,18 @@ func buildParser(name string, tbl *ast.Table) (parsers.Parser, error) {
 	delete(tbl.Fields, "dropwizard_time_format")
 	delete(tbl.Fields, "dropwizard_tags_path")
 	delete(tbl.Fields, "dropwizard_tag_paths")
+	delete(tbl.Fields, "csv_column_names")
+	delete(tbl.Fields, "csv_column_types")
+	delete(tbl.Fields, "csv_comment")
+	delete(tbl.Fields, "csv_delimiter")
+	delete(tbl.Fields, "csv_header_row_count")
+	delete(tbl.Fields, "csv_measurement_column")
+	delete(tbl.Fields, "csv_skip_columns")
+	delete(tbl.Fields, "csv_skip_rows")
+	delete(tbl.Fields, "csv_tag_columns")
+	delete(tbl.Fields, "csv_timestamp_column")
+	delete(tbl.Fields, "csv_timestamp_format")
+	delete(tbl.Fields, "csv_trim_space")
 
 	return parsers.NewParser(c)
 }
@@ -1486,5 +2137,118 @@ func buildOutput(name string, tbl *ast.Table) (*models.OutputConfig, error) {
 	if len(oc.Filter.FieldPass) > 0 {
 		oc.Filter.NamePass = oc.Filter.FieldPass
 	}
+
+	if node, ok := tbl.Fields["alias"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				oc.Alias = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["metric_buffer_bytes_limit"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			var size internal.Size
+			if err := size.UnmarshalTOML([]byte(kv.Value.Source())); err != nil {
+				return nil, fmt.Errorf("invalid metric_buffer_bytes_limit for output %s: %v", name, err)
+			}
+			oc.MetricBufferBytesLimit = size.Size
+		}
+	}
+
+	if node, ok := tbl.Fields["overflow_policy"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				oc.OverflowPolicy = str.Value
+			}
+		}
+	}
+
+	oc.BufferType = "memory"
+	if node, ok := tbl.Fields["buffer_type"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				oc.BufferType = str.Value
+			}
+		}
+	}
+	switch oc.BufferType {
+	case "memory", "disk":
+	default:
+		return nil, fmt.Errorf("invalid buffer_type %q for output %s, must be \"memory\" or \"disk\"",
+			oc.BufferType, name)
+	}
+
+	if node, ok := tbl.Fields["buffer_directory"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				oc.DiskBuffer.Directory = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["buffer_segment_size"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			var size internal.Size
+			if err := size.UnmarshalTOML([]byte(kv.Value.Source())); err != nil {
+				return nil, fmt.Errorf("invalid buffer_segment_size for output %s: %v", name, err)
+			}
+			oc.DiskBuffer.SegmentSize = size.Size
+		}
+	}
+
+	if node, ok := tbl.Fields["buffer_max_disk_size"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			var size internal.Size
+			if err := size.UnmarshalTOML([]byte(kv.Value.Source())); err != nil {
+				return nil, fmt.Errorf("invalid buffer_max_disk_size for output %s: %v", name, err)
+			}
+			oc.DiskBuffer.MaxSize = size.Size
+		}
+	}
+
+	fsync := "always"
+	if node, ok := tbl.Fields["buffer_fsync"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				fsync = str.Value
+			}
+		}
+	}
+	switch fsync {
+	case "always":
+		oc.DiskBuffer.Fsync = true
+	case "never":
+		oc.DiskBuffer.Fsync = false
+	default:
+		return nil, fmt.Errorf("invalid buffer_fsync %q for output %s, must be \"always\" or \"never\"",
+			fsync, name)
+	}
+
+	delete(tbl.Fields, "alias")
+	delete(tbl.Fields, "metric_buffer_bytes_limit")
+	delete(tbl.Fields, "overflow_policy")
+	delete(tbl.Fields, "buffer_type")
+	delete(tbl.Fields, "buffer_directory")
+	delete(tbl.Fields, "buffer_segment_size")
+	delete(tbl.Fields, "buffer_max_disk_size")
+	delete(tbl.Fields, "buffer_fsync")
+
+	// route may be a single route or a list of routes
+	if node, ok := tbl.Fields["route"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			switch v := kv.Value.(type) {
+			case *ast.String:
+				oc.Routes = append(oc.Routes, v.Value)
+			case *ast.Array:
+				for _, elem := range v.Value {
+					if str, ok := elem.(*ast.String); ok {
+						oc.Routes = append(oc.Routes, str.Value)
+					}
+				}
+			}
+		}
+	}
+	delete(tbl.Fields, "route")
 	return oc, nil
 }
@@ -0,0 +1,549 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/toml"
+)
+
+// secretKeyRe matches the options holding secrets, redacted from the
+// effective config along with the values read from secret files.
+var secretKeyRe = regexp.MustCompile(`(?i)password|passwd|secret|token|authorization`)
+
+// bareKeyRe matches the TOML keys written without quotes.
+var bareKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// options are the options of a plugin, or of a table of options, by TOML
+// key.
+type options map[string]interface{}
+
+// effectivePlugin is a plugin instance of the effective config.
+type effectivePlugin struct {
+	Plugin  string  `json:"plugin"`
+	Source  string  `json:"source"`
+	Options options `json:"options"`
+	// Processors are the processors attached to an input or an output.
+	Processors []effectivePlugin `json:"processors,omitempty"`
+}
+
+// effectiveConfig is the config the agent runs with, once all the files are
+// merged and the plugins have filled in their defaults.
+type effectiveConfig struct {
+	Agent       options           `json:"agent"`
+	GlobalTags  map[string]string `json:"global_tags"`
+	Inputs      []effectivePlugin `json:"inputs"`
+	Processors  []effectivePlugin `json:"processors"`
+	Aggregators []effectivePlugin `json:"aggregators"`
+	Outputs     []effectivePlugin `json:"outputs"`
+}
+
+// PrintEffectiveConfig writes the config the agent runs with, in the toml or
+// json format. Every plugin is annotated with the file it comes from, and
+// the secrets are redacted.
+func (c *Config) PrintEffectiveConfig(w io.Writer, format string) error {
+	e := c.effective()
+	switch format {
+	case "", "toml":
+		return e.writeTOML(w)
+	case "json":
+		octets, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", octets)
+		return err
+	default:
+		return fmt.Errorf("invalid effective config format %q, expected toml or json", format)
+	}
+}
+
+func (c *Config) effective() *effectiveConfig {
+	e := &effectiveConfig{
+		Agent:       c.redact(structOptions(reflect.ValueOf(c.Agent).Elem())),
+		GlobalTags:  c.Tags,
+		Inputs:      []effectivePlugin{},
+		Processors:  []effectivePlugin{},
+		Aggregators: []effectivePlugin{},
+		Outputs:     []effectivePlugin{},
+	}
+
+	for _, input := range c.Inputs {
+		opts := structOptions(reflect.ValueOf(input.Input))
+		merge(opts, inputOptions(input.Config))
+		e.Inputs = append(e.Inputs, effectivePlugin{
+			Plugin:     input.Config.Name,
+			Source:     input.Config.Source,
+			Options:    c.redact(opts),
+			Processors: c.effectiveProcessors(input.Processors),
+		})
+	}
+	e.Processors = append(e.Processors, c.effectiveProcessors(c.Processors)...)
+	for _, agg := range c.Aggregators {
+		opts := structOptions(reflect.ValueOf(agg.Aggregator))
+		merge(opts, aggregatorOptions(agg.Config))
+		e.Aggregators = append(e.Aggregators, effectivePlugin{
+			Plugin:  agg.Config.Name,
+			Source:  agg.Config.Source,
+			Options: c.redact(opts),
+		})
+	}
+	for _, output := range c.Outputs {
+		opts := structOptions(reflect.ValueOf(output.Output))
+		merge(opts, outputOptions(output))
+		e.Outputs = append(e.Outputs, effectivePlugin{
+			Plugin:     output.Config.Name,
+			Source:     output.Config.Source,
+			Options:    c.redact(opts),
+			Processors: c.effectiveProcessors(output.Processors),
+		})
+	}
+
+	// The plugins of a file are loaded in no particular order, they are
+	// sorted for the dumps to be compared. The processors keep their order.
+	for _, plugins := range [][]effectivePlugin{e.Inputs, e.Aggregators, e.Outputs} {
+		sort.SliceStable(plugins, func(i, j int) bool {
+			if plugins[i].Source != plugins[j].Source {
+				return plugins[i].Source < plugins[j].Source
+			}
+			return plugins[i].Plugin < plugins[j].Plugin
+		})
+	}
+	return e
+}
+
+func (c *Config) effectiveProcessors(processors models.RunningProcessors) []effectivePlugin {
+	var plugins []effectivePlugin
+	for _, processor := range processors {
+		opts := structOptions(reflect.ValueOf(processor.Processor))
+		merge(opts, processorOptions(processor.Config))
+		plugins = append(plugins, effectivePlugin{
+			Plugin:  processor.Config.Name,
+			Source:  processor.Config.Source,
+			Options: c.redact(opts),
+		})
+	}
+	return plugins
+}
+
+// merge sets the options of src in dst.
+func merge(dst, src options) {
+	for key, value := range src {
+		dst[key] = value
+	}
+}
+
+// structOptions returns the options of a plugin struct, with the values the
+// plugin runs with. The fields which cannot be set from the config, like
+// interfaces or functions, are left out. The fields of an embedded struct
+// are options of the plugin, unless shadowed by the plugin's own fields.
+func structOptions(rv reflect.Value) options {
+	opts := options{}
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return opts
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return opts
+	}
+
+	typ := rv.Type()
+	var embedded []options
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		key := strings.Split(field.Tag.Get("toml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if field.Anonymous {
+			embedded = append(embedded, structOptions(rv.Field(i)))
+			continue
+		}
+		if key == "" {
+			key = toml.DefaultConfig.FieldToKey(typ, field.Name)
+		}
+		if value, ok := optionValue(rv.Field(i)); ok {
+			opts[key] = value
+		}
+	}
+
+	for _, e := range embedded {
+		for key, value := range e {
+			if _, ok := opts[key]; !ok {
+				opts[key] = value
+			}
+		}
+	}
+	return opts
+}
+
+// optionValue returns the value of an option as a bool, int64, uint64,
+// float64, string, slice of values or options, and false when it cannot be
+// set from the config.
+func optionValue(rv reflect.Value) (interface{}, bool) {
+	switch v := rv.Interface().(type) {
+	case internal.Duration:
+		return v.Duration.String(), true
+	case internal.Size:
+		return v.Size, true
+	case time.Time:
+		return nil, false
+	case encoding.TextMarshaler:
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil, false
+		}
+		text, err := v.MarshalText()
+		if err != nil {
+			return nil, false
+		}
+		return string(text), true
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.String:
+		return rv.String(), true
+	case reflect.Slice, reflect.Array:
+		values := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			value, ok := optionValue(rv.Index(i))
+			if !ok {
+				return nil, false
+			}
+			values = append(values, value)
+		}
+		return values, true
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		opts := options{}
+		for _, key := range rv.MapKeys() {
+			if value, ok := optionValue(rv.MapIndex(key)); ok {
+				opts[key.String()] = value
+			}
+		}
+		return opts, true
+	case reflect.Struct:
+		return structOptions(rv), true
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil, false
+		}
+		return optionValue(rv.Elem())
+	default:
+		return nil, false
+	}
+}
+
+func inputOptions(conf *models.InputConfig) options {
+	opts := options{}
+	setString(opts, "alias", conf.Alias)
+	setDuration(opts, "interval", conf.Interval)
+	setString(opts, "name_override", conf.NameOverride)
+	setString(opts, "name_prefix", conf.MeasurementPrefix)
+	setString(opts, "name_suffix", conf.MeasurementSuffix)
+	setString(opts, "route", conf.Route)
+	setDuration(opts, "gather_timeout", conf.GatherTimeout)
+	if conf.SkipIfRunning {
+		opts["skip_if_running"] = true
+	}
+	if conf.Schedule != nil {
+		opts["schedule"] = conf.Schedule.String()
+	}
+	setTags(opts, conf.Tags)
+	filterOptions(opts, conf.Filter)
+	return opts
+}
+
+func processorOptions(conf *models.ProcessorConfig) options {
+	opts := options{}
+	setString(opts, "alias", conf.Alias)
+	if conf.Order != 0 {
+		opts["order"] = conf.Order
+	}
+	filterOptions(opts, conf.Filter)
+	return opts
+}
+
+func aggregatorOptions(conf *models.AggregatorConfig) options {
+	opts := options{}
+	setString(opts, "alias", conf.Alias)
+	setDuration(opts, "period", conf.Period)
+	setDuration(opts, "delay", conf.Delay)
+	if conf.Schedule != nil {
+		opts["schedule"] = conf.Schedule.String()
+	}
+	if conf.DropOriginal {
+		opts["drop_original"] = true
+	}
+	setString(opts, "name_override", conf.NameOverride)
+	setString(opts, "name_prefix", conf.MeasurementPrefix)
+	setString(opts, "name_suffix", conf.MeasurementSuffix)
+	setTags(opts, conf.Tags)
+	filterOptions(opts, conf.Filter)
+	return opts
+}
+
+// outputOptions returns the options of the output, with the buffer limits
+// it runs with, defaulting to those of the agent.
+func outputOptions(ro *models.RunningOutput) options {
+	conf := ro.Config
+	opts := options{
+		"metric_batch_size":   int64(ro.MetricBatchSize),
+		"metric_buffer_limit": int64(ro.MetricBufferLimit),
+		"overflow_policy":     conf.OverflowPolicy,
+	}
+	setString(opts, "alias", conf.Alias)
+	setDuration(opts, "flush_interval", conf.FlushInterval)
+	if conf.MetricBufferBytesLimit != 0 {
+		opts["metric_buffer_bytes_limit"] = conf.MetricBufferBytesLimit
+	}
+	if len(conf.Routes) > 0 {
+		opts["route"] = stringValues(conf.Routes)
+	}
+	if conf.BufferType == "disk" {
+		opts["buffer_type"] = conf.BufferType
+		opts["buffer_directory"] = conf.DiskBuffer.Directory
+		opts["buffer_segment_size"] = conf.DiskBuffer.SegmentSize
+		if conf.DiskBuffer.MaxSize != 0 {
+			opts["buffer_max_disk_size"] = conf.DiskBuffer.MaxSize
+		}
+		if conf.DiskBuffer.Fsync {
+			opts["buffer_fsync"] = "always"
+		} else {
+			opts["buffer_fsync"] = "never"
+		}
+	}
+	filterOptions(opts, conf.Filter)
+	return opts
+}
+
+func filterOptions(opts options, f models.Filter) {
+	for key, values := range map[string][]string{
+		"namepass":   f.NamePass,
+		"namedrop":   f.NameDrop,
+		"fieldpass":  f.FieldPass,
+		"fielddrop":  f.FieldDrop,
+		"taginclude": f.TagInclude,
+		"tagexclude": f.TagExclude,
+	} {
+		if len(values) > 0 {
+			opts[key] = stringValues(values)
+		}
+	}
+	for key, tagFilters := range map[string][]models.TagFilter{
+		"tagpass": f.TagPass,
+		"tagdrop": f.TagDrop,
+	} {
+		if len(tagFilters) == 0 {
+			continue
+		}
+		tags := options{}
+		for _, tf := range tagFilters {
+			tags[tf.Name] = stringValues(tf.Filter)
+		}
+		opts[key] = tags
+	}
+}
+
+func setString(opts options, key, value string) {
+	if value != "" {
+		opts[key] = value
+	}
+}
+
+func setDuration(opts options, key string, value time.Duration) {
+	if value != 0 {
+		opts[key] = value.String()
+	}
+}
+
+func setTags(opts options, tags map[string]string) {
+	if len(tags) == 0 {
+		return
+	}
+	table := options{}
+	for key, value := range tags {
+		table[key] = value
+	}
+	opts["tags"] = table
+}
+
+func stringValues(values []string) []interface{} {
+	out := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		out = append(out, value)
+	}
+	return out
+}
+
+// redact replaces the values of the secret options and the values read from
+// secret files in the options.
+func (c *Config) redact(opts options) options {
+	for key, value := range opts {
+		opts[key] = c.redactValue(key, value)
+	}
+	return opts
+}
+
+func (c *Config) redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v != "" && secretKeyRe.MatchString(key) {
+			return redacted
+		}
+		return c.Redact(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = c.redactValue(key, v[i])
+		}
+		return v
+	case options:
+		return c.redact(v)
+	default:
+		return value
+	}
+}
+
+func (e *effectiveConfig) writeTOML(w io.Writer) error {
+	tw := &tomlWriter{w: w}
+	tw.printf("# Effective configuration, with the defaults of the plugins and the\n")
+	tw.printf("# secrets redacted.\n")
+
+	tw.printf("\n[global_tags]\n")
+	tags := options{}
+	for key, value := range e.GlobalTags {
+		tags[key] = value
+	}
+	tw.table("global_tags", tags, "  ")
+
+	tw.printf("\n[agent]\n")
+	tw.table("agent", e.Agent, "  ")
+
+	for _, section := range []struct {
+		name    string
+		plugins []effectivePlugin
+	}{
+		{"inputs", e.Inputs},
+		{"processors", e.Processors},
+		{"aggregators", e.Aggregators},
+		{"outputs", e.Outputs},
+	} {
+		for _, p := range section.plugins {
+			name := section.name + "." + p.Plugin
+			tw.printf("\n# source: %s\n", p.Source)
+			tw.printf("[[%s]]\n", name)
+			tw.table(name, p.Options, "  ")
+			for _, processor := range p.Processors {
+				pname := name + ".processors." + processor.Plugin
+				tw.printf("  [[%s]]\n", pname)
+				tw.table(pname, processor.Options, "    ")
+			}
+		}
+	}
+	return tw.err
+}
+
+// tomlWriter writes tables of options in TOML, keeping the first error.
+type tomlWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (tw *tomlWriter) printf(format string, args ...interface{}) {
+	if tw.err == nil {
+		_, tw.err = fmt.Fprintf(tw.w, format, args...)
+	}
+}
+
+// table writes the values of the table, then its subtables named after
+// their key under the name of the table.
+func (tw *tomlWriter) table(name string, opts options, indent string) {
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var subtables []string
+	for _, key := range keys {
+		if _, ok := opts[key].(options); ok {
+			subtables = append(subtables, key)
+			continue
+		}
+		tw.printf("%s%s = %s\n", indent, tomlKey(key), tomlValue(opts[key]))
+	}
+	for _, key := range subtables {
+		subname := name + "." + tomlKey(key)
+		tw.printf("%s[%s]\n", indent, subname)
+		tw.table(subname, opts[key].(options), indent+"  ")
+	}
+}
+
+func tomlKey(key string) string {
+	if bareKeyRe.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+func tomlValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0"
+		}
+		return s
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			values = append(values, tomlValue(value))
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	case options:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]string, 0, len(v))
+		for _, key := range keys {
+			values = append(values, tomlKey(key)+" = "+tomlValue(v[key]))
+		}
+		return "{" + strings.Join(values, ", ") + "}"
+	default:
+		return strconv.Quote(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/tls"
+)
+
+// Environment variables configuring the fetching of a config file given by
+// URL. The config itself cannot configure how it is fetched.
+const (
+	// EnvConfigToken is sent as a bearer token.
+	EnvConfigToken = "TELEGRAF_CONFIG_TOKEN"
+	// EnvConfigTLSCA, EnvConfigTLSCert and EnvConfigTLSKey are the TLS
+	// files of HTTPS URLs.
+	EnvConfigTLSCA   = "TELEGRAF_CONFIG_TLS_CA"
+	EnvConfigTLSCert = "TELEGRAF_CONFIG_TLS_CERT"
+	EnvConfigTLSKey  = "TELEGRAF_CONFIG_TLS_KEY"
+	// EnvConfigInsecureSkipVerify skips the verification of the server
+	// certificate when "true".
+	EnvConfigInsecureSkipVerify = "TELEGRAF_CONFIG_INSECURE_SKIP_VERIFY"
+	// EnvConfigCache is the file caching the last good config, by default
+	// in the cache directory of the user.
+	EnvConfigCache = "TELEGRAF_CONFIG_CACHE"
+)
+
+// remoteTimeout limits the time to fetch a config file.
+const remoteTimeout = 30 * time.Second
+
+var (
+	remotesMu sync.Mutex
+	// remotes are the config files fetched so far, by URL.
+	remotes = make(map[string]*remoteConfig)
+)
+
+// IsURL reports whether the config path is an HTTP(S) URL.
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// remoteConfig is a config file fetched over HTTP(S). The last version
+// fetched is kept for the conditional requests, and the last good version is
+// cached on disk for starting while the server is unavailable.
+type remoteConfig struct {
+	url       string
+	token     string
+	cacheFile string
+	client    *http.Client
+
+	mu       sync.Mutex
+	etag     string
+	contents []byte
+}
+
+// getRemote returns the remote config file of the URL, shared by the loads
+// of the config and the polling.
+func getRemote(url string) (*remoteConfig, error) {
+	remotesMu.Lock()
+	defer remotesMu.Unlock()
+
+	if r, ok := remotes[url]; ok {
+		return r, nil
+	}
+	r, err := newRemoteConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	remotes[url] = r
+	return r, nil
+}
+
+func newRemoteConfig(url string) (*remoteConfig, error) {
+	clientConfig := tls.ClientConfig{
+		TLSCA:   os.Getenv(EnvConfigTLSCA),
+		TLSCert: os.Getenv(EnvConfigTLSCert),
+		TLSKey:  os.Getenv(EnvConfigTLSKey),
+	}
+	if v := os.Getenv(EnvConfigInsecureSkipVerify); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", EnvConfigInsecureSkipVerify, err)
+		}
+		clientConfig.InsecureSkipVerify = insecure
+	}
+	tlsConfig, err := clientConfig.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cacheFile := os.Getenv(EnvConfigCache)
+	if cacheFile == "" {
+		if dir := userCacheDir(); dir != "" {
+			h := sha256.Sum256([]byte(url))
+			cacheFile = filepath.Join(dir, "telegraf",
+				"config-"+hex.EncodeToString(h[:8])+".conf")
+		} else {
+			log.Printf("W! No cache directory for the config %s, set %s to start "+
+				"from the last good config when it cannot be fetched", url, EnvConfigCache)
+		}
+	}
+
+	return &remoteConfig{
+		url:       url,
+		token:     os.Getenv(EnvConfigToken),
+		cacheFile: cacheFile,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+			Timeout: remoteTimeout,
+		},
+	}, nil
+}
+
+// userCacheDir returns the cache directory of the user, empty when there is
+// none, ie when running as a service without a home directory.
+func userCacheDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		return os.Getenv("LocalAppData")
+	case "darwin":
+		if home := os.Getenv("HOME"); home != "" {
+			return filepath.Join(home, "Library", "Caches")
+		}
+	default:
+		if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+			return dir
+		}
+		if home := os.Getenv("HOME"); home != "" {
+			return filepath.Join(home, ".cache")
+		}
+	}
+	return ""
+}
+
+// fetch returns the config file and whether it changed since the last
+// fetch. When the server is unavailable, the last version fetched, or else
+// the cached one, is returned.
+func (r *remoteConfig) fetch() ([]byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	contents, changed, err := r.get()
+	if err == nil {
+		return contents, changed, nil
+	}
+	if r.contents != nil {
+		log.Printf("W! Could not fetch config %s, keeping the last one fetched: %v", r.url, err)
+		return r.contents, false, nil
+	}
+	if r.cacheFile == "" {
+		return nil, false, err
+	}
+	cached, cerr := ioutil.ReadFile(r.cacheFile)
+	if cerr != nil {
+		return nil, false, err
+	}
+	log.Printf("W! Could not fetch config %s, using the cached config %s: %v",
+		r.url, r.cacheFile, err)
+	r.contents = cached
+	return cached, true, nil
+}
+
+// get requests the config file, unless it did not change since the last
+// request.
+func (r *remoteConfig) get() ([]byte, bool, error) {
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	if r.etag != "" && r.contents != nil {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if r.contents != nil {
+			return r.contents, false, nil
+		}
+		fallthrough
+	default:
+		return nil, false, fmt.Errorf("%s returned HTTP status %s", r.url, resp.Status)
+	}
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	changed := !bytes.Equal(contents, r.contents)
+	r.etag = resp.Header.Get("ETag")
+	r.contents = contents
+	return contents, changed, nil
+}
+
+// save caches the contents as the last good config. The file is replaced
+// at once, for a crash not to leave a partial config behind.
+func (r *remoteConfig) save(contents []byte) error {
+	if r.cacheFile == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(r.cacheFile), 0700); err != nil {
+		return err
+	}
+	tmp := r.cacheFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, contents, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.cacheFile)
+}
+
+// WatchRemote polls the config file at the URL every interval, until the
+// context is done. A value is sent on the returned channel when the config
+// file changed.
+func WatchRemote(ctx context.Context, url string, interval time.Duration) (<-chan struct{}, error) {
+	r, err := getRemote(url)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if _, changed, err := r.fetch(); err != nil {
+				log.Printf("E! Could not fetch config %s: %v", url, err)
+			} else if changed {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
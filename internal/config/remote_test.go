@@ -0,0 +1,194 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const remoteConf = `
+[[inputs.memcached]]
+  servers = ["localhost"]
+`
+
+// configServer serves a config file with an ETag, counting the requests
+// answered with the file.
+type configServer struct {
+	sync.Mutex
+	contents string
+	etag     string
+	token    string
+	down     bool
+	served   int
+}
+
+func (s *configServer) set(contents, etag string) {
+	s.Lock()
+	defer s.Unlock()
+	s.contents, s.etag = contents, etag
+}
+
+func (s *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch {
+	case s.down:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token:
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.Header.Get("If-None-Match") == s.etag:
+		w.WriteHeader(http.StatusNotModified)
+	default:
+		s.served++
+		w.Header().Set("ETag", s.etag)
+		w.Write([]byte(s.contents))
+	}
+}
+
+// setupRemote sets the environment of the remote config, caching it in a
+// temporary directory, and forgets the config files fetched so far.
+func setupRemote(t *testing.T, token string) (string, func()) {
+	dir, err := ioutil.TempDir("", "telegraf")
+	require.NoError(t, err)
+	cache := filepath.Join(dir, "cache", "telegraf.conf")
+	os.Setenv(EnvConfigCache, cache)
+	os.Setenv(EnvConfigToken, token)
+
+	remotesMu.Lock()
+	remotes = make(map[string]*remoteConfig)
+	remotesMu.Unlock()
+	return cache, func() {
+		os.Unsetenv(EnvConfigCache)
+		os.Unsetenv(EnvConfigToken)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConfig_LoadRemote(t *testing.T) {
+	cache, cleanup := setupRemote(t, "secret")
+	defer cleanup()
+	s := &configServer{contents: remoteConf, etag: `"v1"`, token: "secret"}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewConfig()
+	require.NoError(t, c.LoadConfig(ts.URL))
+	assert.Len(t, c.Inputs, 1)
+
+	cached, err := ioutil.ReadFile(cache)
+	require.NoError(t, err)
+	assert.Equal(t, remoteConf, string(cached))
+
+	// The unchanged config is not sent again.
+	c = NewConfig()
+	require.NoError(t, c.LoadConfig(ts.URL))
+	assert.Len(t, c.Inputs, 1)
+	s.Lock()
+	assert.Equal(t, 1, s.served)
+	s.Unlock()
+}
+
+func TestConfig_LoadRemoteUnauthorized(t *testing.T) {
+	_, cleanup := setupRemote(t, "wrong")
+	defer cleanup()
+	s := &configServer{contents: remoteConf, etag: `"v1"`, token: "secret"}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewConfig()
+	assert.Error(t, c.LoadConfig(ts.URL))
+}
+
+func TestConfig_LoadRemoteCached(t *testing.T) {
+	cache, cleanup := setupRemote(t, "")
+	defer cleanup()
+	s := &configServer{down: true}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewConfig()
+	assert.Error(t, c.LoadConfig(ts.URL))
+
+	// The agent starts with the last good config while the server is down.
+	require.NoError(t, os.MkdirAll(filepath.Dir(cache), 0700))
+	require.NoError(t, ioutil.WriteFile(cache, []byte(remoteConf), 0600))
+	c = NewConfig()
+	require.NoError(t, c.LoadConfig(ts.URL))
+	assert.Len(t, c.Inputs, 1)
+}
+
+func TestConfig_LoadRemoteInvalidNotCached(t *testing.T) {
+	cache, cleanup := setupRemote(t, "")
+	defer cleanup()
+	s := &configServer{contents: "[[inputs.memcached]]\n  serverz = 1\n", etag: `"v1"`}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewConfig()
+	assert.Error(t, c.LoadConfig(ts.URL))
+	_, err := os.Stat(cache)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWatchRemote(t *testing.T) {
+	_, cleanup := setupRemote(t, "")
+	defer cleanup()
+	s := &configServer{contents: remoteConf, etag: `"v1"`}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewConfig()
+	require.NoError(t, c.LoadConfig(ts.URL))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := WatchRemote(ctx, ts.URL, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	select {
+	case <-changes:
+		t.Fatal("unchanged config reported as changed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	s.set(remoteConf+"\n[[inputs.memcached]]\n", `"v2"`)
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("changed config not reported")
+	}
+
+	c = NewConfig()
+	require.NoError(t, c.LoadConfig(ts.URL))
+	assert.Len(t, c.Inputs, 2)
+}
+
+func TestUserCacheDir(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("Skipping test on " + runtime.GOOS)
+	}
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+
+	os.Setenv("XDG_CACHE_HOME", "/var/cache/edge")
+	os.Setenv("HOME", "/home/edge")
+	assert.Equal(t, "/var/cache/edge", userCacheDir())
+
+	os.Unsetenv("XDG_CACHE_HOME")
+	assert.Equal(t, "/home/edge/.cache", userCacheDir())
+
+	// Services may run without a home directory.
+	os.Unsetenv("HOME")
+	assert.Equal(t, "", userCacheDir())
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// redacted replaces the secrets in the config dumps and the logs.
+const redacted = "****"
+
+// substitute replaces the environment variables and the secret files in the
+// contents of a config file, the lines commented out are left as is:
+//
+//	$VAR, ${VAR}            the value of the variable; $VAR is left as is
+//	                        when the variable is not set
+//	${VAR:-default}         the default when the variable is unset or empty
+//	${VAR:?message}         an error with the message when the variable is
+//	                        unset or empty
+//	${file:/path}           the contents of the file, a secret
+//	"@file:/path"           the contents of the file as a string, a secret
+func (c *Config) substitute(contents []byte) ([]byte, error) {
+	lines := bytes.Split(contents, []byte("\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("#")) {
+			continue
+		}
+
+		var err error
+		line = fileRefRe.ReplaceAllFunc(line, func(ref []byte) []byte {
+			secret, rerr := c.readSecret(string(ref[len(`"@file:`) : len(ref)-1]))
+			if rerr != nil {
+				err = rerr
+				return ref
+			}
+			return []byte(`"` + escapeEnv(secret) + `"`)
+		})
+		line = envVarRe.ReplaceAllFunc(line, func(ref []byte) []byte {
+			value, rerr := c.expand(string(ref))
+			if rerr != nil {
+				err = rerr
+				return ref
+			}
+			return []byte(value)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		lines[i] = line
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// expand returns the replacement of an environment variable or a secret
+// file reference, escaped for a TOML string.
+func (c *Config) expand(ref string) (string, error) {
+	if !strings.HasPrefix(ref, "${") {
+		value, ok := os.LookupEnv(ref[1:])
+		if !ok {
+			return ref, nil
+		}
+		return escapeEnv(value), nil
+	}
+
+	expr := ref[2 : len(ref)-1]
+	if strings.HasPrefix(expr, "file:") {
+		secret, err := c.readSecret(expr[len("file:"):])
+		return escapeEnv(secret), err
+	}
+	if i := strings.Index(expr, ":-"); i >= 0 {
+		if value := os.Getenv(expr[:i]); value != "" {
+			return escapeEnv(value), nil
+		}
+		// The default is written in the config, already escaped.
+		return expr[i+2:], nil
+	}
+	if i := strings.Index(expr, ":?"); i >= 0 {
+		if value := os.Getenv(expr[:i]); value != "" {
+			return escapeEnv(value), nil
+		}
+		message := expr[i+2:]
+		if message == "" {
+			message = "not set"
+		}
+		return "", fmt.Errorf("%s: %s", expr[:i], message)
+	}
+	return escapeEnv(os.Getenv(expr)), nil
+}
+
+// readSecret returns the contents of a secret file, without the trailing
+// newline, and records it for its redaction.
+func (c *Config) readSecret(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read secret file: %v", err)
+	}
+	secret := strings.TrimRight(string(contents), "\r\n")
+	if secret != "" {
+		c.secrets = append(c.secrets, secret)
+	}
+	return secret, nil
+}
+
+// Secrets returns the values read from secret files, which are to be
+// redacted from the logs.
+func (c *Config) Secrets() []string {
+	return c.secrets
+}
+
+// Redact replaces the values read from secret files in s.
+func (c *Config) Redact(s string) string {
+	if len(c.secrets) == 0 {
+		return s
+	}
+	// The longest secrets are replaced first, in case a secret contains
+	// another.
+	secrets := append([]string(nil), c.secrets...)
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+	return s
+}
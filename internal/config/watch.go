@@ -0,0 +1,145 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// WatchFiles watches the config file and the *.conf files of the config
+// directory, until the context is done. A value is sent on the returned
+// channel once no change happened for the debounce duration, so that the
+// files written together are reloaded at once. A config file given by URL is
+// not watched, see WatchRemote.
+func WatchFiles(ctx context.Context, path, directory string, debounce time.Duration) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// The files are replaced rather than written by most editors and config
+	// management tools, so their directory is watched.
+	var files []string
+	if !IsURL(path) {
+		if path == "" {
+			if path, err = getDefaultConfigPath(); err != nil {
+				watcher.Close()
+				return nil, err
+			}
+		}
+		path = filepath.Clean(path)
+		files = append(files, path)
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	if directory != "" {
+		directory = filepath.Clean(directory)
+		if err := watchDirectory(watcher, directory); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	w := &filesWatcher{
+		watcher:   watcher,
+		files:     files,
+		directory: directory,
+		debounce:  debounce,
+		changes:   make(chan struct{}, 1),
+	}
+	go w.run(ctx)
+	return w.changes, nil
+}
+
+// watchDirectory watches the directory and its subdirectories, as loaded by
+// LoadDirectory.
+func watchDirectory(watcher *fsnotify.Watcher, directory string) error {
+	return filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if path != directory && strings.HasPrefix(info.Name(), "..") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+type filesWatcher struct {
+	watcher   *fsnotify.Watcher
+	files     []string
+	directory string
+	debounce  time.Duration
+	changes   chan struct{}
+}
+
+func (w *filesWatcher) run(ctx context.Context) {
+	defer w.watcher.Close()
+
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case err := <-w.watcher.Errors:
+			log.Printf("E! [telegraf] Error watching the config files: %v", err)
+		case event := <-w.watcher.Events:
+			if !w.changed(event) {
+				continue
+			}
+			log.Printf("D! [telegraf] Config file %s changed: %s", event.Name, event.Op)
+			timer.Reset(w.debounce)
+		case <-timer.C:
+			select {
+			case w.changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// changed reports whether the event changes the config: it is on the config
+// file or a *.conf file of the config directory. The Kubernetes mounts are
+// updated by swapping their ..data directory, which changes all their files.
+func (w *filesWatcher) changed(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	base := filepath.Base(name)
+	for _, file := range w.files {
+		if name == file || strings.HasPrefix(base, "..") && filepath.Dir(name) == filepath.Dir(file) {
+			return true
+		}
+	}
+	if w.directory == "" || !w.inDirectory(name) {
+		return false
+	}
+	if strings.HasPrefix(base, "..") {
+		return true
+	}
+	if event.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(name); err == nil && info.IsDir() {
+			// The files of a new subdirectory are loaded too.
+			if err := watchDirectory(w.watcher, name); err != nil {
+				log.Printf("E! [telegraf] Error watching %s: %v", name, err)
+			}
+			return true
+		}
+	}
+	return strings.HasSuffix(base, ".conf")
+}
+
+func (w *filesWatcher) inDirectory(name string) bool {
+	rel, err := filepath.Rel(w.directory, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
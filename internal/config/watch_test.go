@@ -0,0 +1,64 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitChange reports whether a change is sent on changes within a second.
+func waitChange(changes <-chan struct{}) bool {
+	select {
+	case <-changes:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telegraf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "telegraf.conf")
+	require.NoError(t, ioutil.WriteFile(path, []byte(remoteConf), 0644))
+	directory := filepath.Join(dir, "telegraf.d")
+	require.NoError(t, os.Mkdir(directory, 0755))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := WatchFiles(ctx, path, directory, 50*time.Millisecond)
+	require.NoError(t, err)
+
+	// The files written together are reported once.
+	for _, name := range []string{"a.conf", "b.conf", "c.conf"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(directory, name), []byte(remoteConf), 0644))
+	}
+	assert.True(t, waitChange(changes))
+	assert.False(t, waitChange(changes))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(directory, "README"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.conf"), nil, 0644))
+	assert.False(t, waitChange(changes))
+
+	// The config file is replaced.
+	tmp := filepath.Join(dir, "telegraf.conf.tmp")
+	require.NoError(t, ioutil.WriteFile(tmp, []byte(remoteConf), 0644))
+	require.NoError(t, os.Rename(tmp, path))
+	assert.True(t, waitChange(changes))
+
+	// The files of a new subdirectory are watched.
+	sub := filepath.Join(directory, "team")
+	require.NoError(t, os.Mkdir(sub, 0755))
+	assert.True(t, waitChange(changes))
+	require.NoError(t, os.Remove(filepath.Join(directory, "a.conf")))
+	assert.True(t, waitChange(changes))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sub, "team.conf"), []byte(remoteConf), 0644))
+	assert.True(t, waitChange(changes))
+}
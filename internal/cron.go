@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a schedule given by a cron expression, evaluated in UTC
+// unless it starts with a CRON_TZ=<location> prefix.
+type CronSchedule struct {
+	spec     string
+	location *time.Location
+
+	second, minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set when the day of month or of week is not
+	// restricted, the day then only has to match the other one.
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSeconds = cronField{0, 59, nil}
+	cronMinutes = cronField{0, 59, nil}
+	cronHours   = cronField{0, 23, nil}
+	cronDoms    = cronField{1, 31, nil}
+	cronMonths  = cronField{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is either 0 or 7.
+	cronDows = cronField{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// ParseCronSchedule parses a cron expression of six fields, seconds first,
+// or of five fields, minutes first:
+//
+//	second minute hour day-of-month month day-of-week
+//
+// Each field is a *, a value, a range a-b or a list of them separated by
+// commas, optionally followed by a /step. Months and days of week may be
+// given by their three letters name. The @yearly, @monthly, @weekly, @daily
+// and @hourly descriptors are also accepted.
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	c := &CronSchedule{spec: spec, location: time.UTC}
+
+	expr := strings.TrimSpace(spec)
+	if strings.HasPrefix(expr, "CRON_TZ=") {
+		i := strings.IndexAny(expr, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid schedule %q: missing expression", spec)
+		}
+		loc, err := time.LoadLocation(expr[len("CRON_TZ="):i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+		c.location = loc
+		expr = strings.TrimSpace(expr[i:])
+	}
+	if d, ok := cronDescriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 or 6 fields, found %d",
+			spec, len(fields))
+	}
+
+	var err error
+	parsers := []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&c.second, cronSeconds},
+		{&c.minute, cronMinutes},
+		{&c.hour, cronHours},
+		{&c.dom, cronDoms},
+		{&c.month, cronMonths},
+		{&c.dow, cronDows},
+	}
+	for i, p := range parsers {
+		*p.bits, err = parseCronField(fields[i], p.field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+	}
+	// Sunday as 7 is Sunday as 0.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = fields[3] == "*" || fields[3] == "?"
+	c.dowStar = fields[5] == "*" || fields[5] == "?"
+	return c, nil
+}
+
+func parseCronField(s string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rng == "*" || rng == "?":
+			low, high = f.min, f.max
+		case strings.Contains(rng, "-"):
+			i := strings.Index(rng, "-")
+			var err error
+			if low, err = f.value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if low, err = f.value(rng); err != nil {
+				return 0, err
+			}
+			high = low
+			// A value with a step runs to the end of the field.
+			if strings.Contains(part, "/") {
+				high = f.max
+			}
+		}
+		if low > high {
+			return 0, fmt.Errorf("invalid range %q", rng)
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d-%d]", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// String returns the cron expression of the schedule.
+func (c *CronSchedule) String() string {
+	return c.spec
+}
+
+// Next returns the first time of the schedule after t, or the zero time if
+// there is none within five years.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.In(c.location).Truncate(time.Second).Add(time.Second)
+	limit := t.Year() + 5
+
+	for t.Year() <= limit {
+		y, mo, d := t.Date()
+		h, mi, s := t.Clock()
+		switch {
+		case c.month&(1<<uint(mo)) == 0:
+			t = time.Date(y, mo+1, 1, 0, 0, 0, 0, c.location)
+		case !c.dayMatches(t):
+			t = time.Date(y, mo, d+1, 0, 0, 0, 0, c.location)
+		case c.hour&(1<<uint(h)) == 0:
+			t = time.Date(y, mo, d, h+1, 0, 0, 0, c.location)
+		case c.minute&(1<<uint(mi)) == 0:
+			t = time.Date(y, mo, d, h, mi+1, 0, 0, c.location)
+		case c.second&(1<<uint(s)) == 0:
+			t = time.Date(y, mo, d, h, mi, s+1, 0, c.location)
+		default:
+			return t.In(loc)
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t is scheduled. When both the day of
+// month and the day of week are restricted, either one has to match.
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from string
+		next string
+	}{
+		{"0 */5 * * * *", "2018-11-05T10:03:27Z", "2018-11-05T10:05:00Z"},
+		{"0 */5 * * * *", "2018-11-05T10:05:00Z", "2018-11-05T10:10:00Z"},
+		{"*/5 * * * *", "2018-11-05T10:59:59.5Z", "2018-11-05T11:00:00Z"},
+		{"30 0 * * * *", "2018-11-05T10:00:31Z", "2018-11-05T11:00:30Z"},
+		{"@hourly", "2018-12-31T23:10:00Z", "2019-01-01T00:00:00Z"},
+		{"0 0 12 * * mon-fri", "2018-11-09T13:00:00Z", "2018-11-12T12:00:00Z"},
+		{"0 0 0 1 jan,jul *", "2018-02-01T00:00:00Z", "2018-07-01T00:00:00Z"},
+		{"0 0 0 * * 7", "2018-11-05T00:00:00Z", "2018-11-11T00:00:00Z"},
+		// Either the day of month or the day of week matches
+		{"0 0 0 13 * 5", "2018-11-05T00:00:00Z", "2018-11-09T00:00:00Z"},
+		{"0 15-45/15 * * * *", "2018-11-05T10:45:00Z", "2018-11-05T11:15:00Z"},
+		{"0 10/20 * * * *", "2018-11-05T10:51:00Z", "2018-11-05T11:10:00Z"},
+		{"0 0 0 29 2 *", "2018-03-01T00:00:00Z", "2020-02-29T00:00:00Z"},
+		{"CRON_TZ=America/New_York 0 0 9 * * *", "2018-11-05T00:00:00Z", "2018-11-05T14:00:00Z"},
+	}
+	for _, tt := range tests {
+		c, err := ParseCronSchedule(tt.spec)
+		require.NoError(t, err, tt.spec)
+		from, err := time.Parse(time.RFC3339Nano, tt.from)
+		require.NoError(t, err)
+		next, err := time.Parse(time.RFC3339, tt.next)
+		require.NoError(t, err)
+		assert.Equal(t, next, c.Next(from).UTC(), "%s from %s", tt.spec, tt.from)
+	}
+}
+
+func TestCronScheduleNextKeepsLocation(t *testing.T) {
+	c, err := ParseCronSchedule("0 0 * * * *")
+	require.NoError(t, err)
+	loc := time.FixedZone("UTC+1", 3600)
+	next := c.Next(time.Date(2018, 11, 5, 10, 30, 0, 0, loc))
+	assert.Equal(t, loc, next.Location())
+	assert.Equal(t, time.Date(2018, 11, 5, 11, 0, 0, 0, loc), next)
+}
+
+func TestCronScheduleNever(t *testing.T) {
+	c, err := ParseCronSchedule("0 0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, c.Next(time.Now()).IsZero())
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * * *",
+		"* * 24 * * *",
+		"* * * 0 * *",
+		"* * * * 13 *",
+		"* * * * * 8",
+		"*/0 * * * * *",
+		"10-5 * * * * *",
+		"a * * * * *",
+		"CRON_TZ=Nowhere/Land * * * * * *",
+		"CRON_TZ=UTC",
+	} {
+		_, err := ParseCronSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
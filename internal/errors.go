@@ -0,0 +1,21 @@
+package internal
+
+import "fmt"
+
+// PartialWriteError is returned by an output when only some of the metrics of
+// a batch were written. The rejected metrics are kept by the output buffer
+// for a later write, the dropped ones are removed from the buffer and
+// reported undelivered, and the others are accepted.
+type PartialWriteError struct {
+	Err error
+	// MetricsReject holds the indices of the rejected metrics in the batch.
+	MetricsReject []int
+	// MetricsDrop holds the indices of the metrics in the batch which can
+	// never be written, ie as they cannot be serialized.
+	MetricsDrop []int
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("%d metrics not written: %v",
+		len(e.MetricsReject)+len(e.MetricsDrop), e.Err)
+}
@@ -0,0 +1,20 @@
+package models
+
+// pluginTags returns the tags of the internal stats of a plugin instance:
+// the plugin name under the key, and the alias of the instance when set.
+func pluginTags(key, name, alias string) map[string]string {
+	tags := map[string]string{key: name}
+	if alias != "" {
+		tags["alias"] = alias
+	}
+	return tags
+}
+
+// logName returns the name of a plugin instance in the logs, the name
+// followed by the alias of the instance when set, ie "akamill::primary".
+func logName(name, alias string) string {
+	if alias == "" {
+		return name
+	}
+	return name + "::" + alias
+}
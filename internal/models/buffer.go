@@ -7,57 +7,127 @@ import (
 	"github.com/influxdata/telegraf/selfstat"
 )
 
+// Overflow policies of a full buffer
+const (
+	// OverflowDropOldest overwrites the oldest metrics
+	OverflowDropOldest = "drop_oldest"
+	// OverflowDropNewest drops the metrics added to a full buffer
+	OverflowDropNewest = "drop_newest"
+	// OverflowBlockInputs makes the output wait for room in the buffer before
+	// accepting new metrics, holding back the inputs. The buffer itself
+	// overwrites the oldest metrics.
+	OverflowBlockInputs = "block_inputs"
+)
+
 var (
 	AgentMetricsWritten = selfstat.Register("agent", "metrics_written", map[string]string{})
 	AgentMetricsDropped = selfstat.Register("agent", "metrics_dropped", map[string]string{})
 )
 
+// MetricBuffer stores the metrics of an output until they are written.
+type MetricBuffer interface {
+	// Len returns the number of metrics currently in the buffer.
+	Len() int
+	// Add adds metrics to the buffer, dropping the oldest metrics when full.
+	Add(metrics ...telegraf.Metric)
+	// Batch returns up to batchSize of the oldest metrics.
+	Batch(batchSize int) []telegraf.Metric
+	// Accept removes the metrics of the last batch.
+	Accept(batch []telegraf.Metric)
+	// Reject keeps the metrics of the last batch for a later write.
+	Reject(batch []telegraf.Metric)
+	// AcceptPartial removes the metrics of the last batch, except the
+	// rejected ones, given by their index in the batch, which are added
+	// back for a later write. The dropped ones are removed undelivered.
+	AcceptPartial(batch []telegraf.Metric, rejected, dropped []int)
+	// Bytes returns the estimated size of the metrics in the buffer.
+	Bytes() int64
+	// Close releases the resources held by the buffer.
+	Close() error
+}
+
 // Buffer stores metrics in a circular buffer.
 type Buffer struct {
 	sync.Mutex
+	name  string
+	alias string
 	buf   []telegraf.Metric
-	first int // index of the first/oldest metric
-	last  int // one after the index of the last/newest metric
-	size  int // number of metrics currently in the buffer
-	cap   int // the capacity of the buffer
+	sizes []int64 // estimated size of each metric, when limited in bytes
+	first int     // index of the first/oldest metric
+	last  int     // one after the index of the last/newest metric
+	size  int     // number of metrics currently in the buffer
+	cap   int     // the capacity of the buffer
+
+	bytes      int64  // estimated size of the metrics in the buffer
+	bytesLimit int64  // maximum estimated size, 0 for no limit
+	overflow   string // overflow policy
 
 	batchFirst int // index of the first metric in the batch
 	batchLast  int // one after the index of the last metric in the batch
 	batchSize  int // number of metrics current in the batch
 
+	// drops counts the metrics dropped per measurement, up to
+	// maxDropMeasurements measurements.
+	drops map[string]selfstat.Stat
+
 	MetricsAdded   selfstat.Stat
 	MetricsWritten selfstat.Stat
 	MetricsDropped selfstat.Stat
 }
 
+// maxDropMeasurements limits the number of measurements the drops are
+// counted for, the drops of the other measurements are counted together as
+// the otherMeasurements measurement.
+const (
+	maxDropMeasurements = 100
+	otherMeasurements   = "_other"
+)
+
 // NewBuffer returns a new empty Buffer with the given capacity.
-func NewBuffer(name string, capacity int) *Buffer {
+func NewBuffer(name string, alias string, capacity int) *Buffer {
 	b := &Buffer{
-		buf:   make([]telegraf.Metric, capacity),
-		first: 0,
-		last:  0,
-		size:  0,
-		cap:   capacity,
+		name:     name,
+		alias:    alias,
+		buf:      make([]telegraf.Metric, capacity),
+		sizes:    make([]int64, capacity),
+		first:    0,
+		last:     0,
+		size:     0,
+		cap:      capacity,
+		overflow: OverflowDropOldest,
+		drops:    make(map[string]selfstat.Stat),
 
 		MetricsAdded: selfstat.Register(
 			"write",
 			"metrics_added",
-			map[string]string{"output": name},
+			pluginTags("output", name, alias),
 		),
 		MetricsWritten: selfstat.Register(
 			"write",
 			"metrics_written",
-			map[string]string{"output": name},
+			pluginTags("output", name, alias),
 		),
 		MetricsDropped: selfstat.Register(
 			"write",
 			"metrics_dropped",
-			map[string]string{"output": name},
+			pluginTags("output", name, alias),
 		),
 	}
 	return b
 }
 
+// SetOverflow limits the estimated size of the buffer to bytesLimit, unless
+// 0, and sets the policy applied when the buffer is full.
+func (b *Buffer) SetOverflow(bytesLimit int64, policy string) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.bytesLimit = bytesLimit
+	if policy != "" {
+		b.overflow = policy
+	}
+}
+
 // Len returns the number of metrics currently in the buffer.
 func (b *Buffer) Len() int {
 	b.Lock()
@@ -66,6 +136,14 @@ func (b *Buffer) Len() int {
 	return b.size
 }
 
+// Bytes returns the estimated size of the metrics currently in the buffer.
+func (b *Buffer) Bytes() int64 {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.bytes
+}
+
 func (b *Buffer) metricAdded() {
 	b.MetricsAdded.Incr(1)
 }
@@ -76,12 +154,34 @@ func (b *Buffer) metricWritten(metric telegraf.Metric) {
 	metric.Accept()
 }
 
+// metricDropped counts and rejects a dropped metric, the caller must hold the
+// lock.
 func (b *Buffer) metricDropped(metric telegraf.Metric) {
 	AgentMetricsDropped.Incr(1)
 	b.MetricsDropped.Incr(1)
+	b.measurementDrops(metric.Name()).Incr(1)
 	metric.Reject()
 }
 
+// measurementDrops returns the count of the metrics of the measurement
+// dropped, the caller must hold the lock.
+func (b *Buffer) measurementDrops(measurement string) selfstat.Stat {
+	if stat, ok := b.drops[measurement]; ok {
+		return stat
+	}
+	if len(b.drops) >= maxDropMeasurements {
+		measurement = otherMeasurements
+		if stat, ok := b.drops[measurement]; ok {
+			return stat
+		}
+	}
+	tags := pluginTags("output", b.name, b.alias)
+	tags["measurement"] = measurement
+	stat := selfstat.Register("write_drops", "metrics_dropped", tags)
+	b.drops[measurement] = stat
+	return stat
+}
+
 func (b *Buffer) inBatch() bool {
 	if b.batchSize == 0 {
 		return false
@@ -94,7 +194,59 @@ func (b *Buffer) inBatch() bool {
 	}
 }
 
-func (b *Buffer) add(m telegraf.Metric) {
+// metricSize returns the size of the metric when the buffer is limited in
+// bytes.
+func (b *Buffer) metricSize(m telegraf.Metric) int64 {
+	if b.bytesLimit == 0 {
+		return 0
+	}
+	return MetricSize(m)
+}
+
+// full reports whether adding a metric of the given size overflows the
+// buffer.
+func (b *Buffer) full(size int64) bool {
+	if b.size == b.cap {
+		return true
+	}
+	return b.bytesLimit > 0 && b.size > 0 && b.bytes+size > b.bytesLimit
+}
+
+// dropFirst removes the oldest metric from the buffer.
+func (b *Buffer) dropFirst() {
+	if b.batchSize > 0 {
+		// The oldest metric is in the outstanding batch, delay the dropping
+		// only in case the batch gets rejected.
+		b.batchSize--
+		b.batchFirst++
+		b.batchFirst %= b.cap
+	} else {
+		b.metricDropped(b.buf[b.first])
+	}
+
+	b.bytes -= b.sizes[b.first]
+	b.buf[b.first] = nil
+	b.sizes[b.first] = 0
+	b.first++
+	b.first %= b.cap
+	b.size--
+}
+
+// add adds the metric to the buffer, it returns false when the metric is
+// dropped by the drop_newest policy.
+func (b *Buffer) add(m telegraf.Metric) bool {
+	size := b.metricSize(m)
+	if b.overflow == OverflowDropNewest && b.full(size) {
+		b.metricDropped(m)
+		return false
+	}
+
+	// Make room for the metric when over the size limit, a metric larger
+	// than the limit is kept on its own.
+	for b.size > 0 && b.bytesLimit > 0 && b.bytes+size > b.bytesLimit {
+		b.dropFirst()
+	}
+
 	// Check if Buffer is full
 	if b.size == b.cap {
 		if b.batchSize == 0 {
@@ -115,7 +267,12 @@ func (b *Buffer) add(m telegraf.Metric) {
 
 	b.metricAdded()
 
+	if b.size == b.cap {
+		b.bytes -= b.sizes[b.last]
+	}
 	b.buf[b.last] = m
+	b.sizes[b.last] = size
+	b.bytes += size
 	b.last++
 	b.last %= b.cap
 
@@ -125,6 +282,7 @@ func (b *Buffer) add(m telegraf.Metric) {
 	}
 
 	b.size = min(b.size+1, b.cap)
+	return true
 }
 
 // Add adds metrics to the buffer
@@ -175,14 +333,52 @@ func (b *Buffer) Accept(batch []telegraf.Metric) {
 		b.metricWritten(m)
 	}
 
-	b.size -= b.batchSize
-	for i := 0; i < b.batchSize; i++ {
-		b.buf[b.first] = nil
-		b.first++
-		b.first %= b.cap
+	b.removeBatch()
+}
+
+// AcceptPartial removes the metrics contained in the last batch, the rejected
+// metrics are added back to the buffer as the newest metrics and the dropped
+// ones are counted as dropped.
+func (b *Buffer) AcceptPartial(batch []telegraf.Metric, rejected, dropped []int) {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, m := range b.acceptPartial(batch, rejected, dropped) {
+		b.add(m)
 	}
+}
 
-	b.resetBatch()
+// acceptPartial removes the metrics of the last batch and returns the
+// rejected metrics which are still to be written.
+func (b *Buffer) acceptPartial(batch []telegraf.Metric, rejected, dropped []int) []telegraf.Metric {
+	reject := make(map[int]bool, len(rejected))
+	for _, i := range rejected {
+		reject[i] = true
+	}
+	drop := make(map[int]bool, len(dropped))
+	for _, i := range dropped {
+		drop[i] = true
+	}
+
+	// The first metrics of the batch were overwritten while it was written.
+	overwritten := len(batch) - b.batchSize
+
+	var retry []telegraf.Metric
+	for i, m := range batch {
+		switch {
+		case drop[i]:
+			b.metricDropped(m)
+		case !reject[i]:
+			b.metricWritten(m)
+		case i < overwritten:
+			b.metricDropped(m)
+		default:
+			retry = append(retry, m)
+		}
+	}
+
+	b.removeBatch()
+	return retry
 }
 
 // Reject clears the current batch record so that calls to Accept will have no
@@ -192,8 +388,9 @@ func (b *Buffer) Reject(batch []telegraf.Metric) {
 	defer b.Unlock()
 
 	if len(batch) > b.batchSize {
-		// Part or all of the batch was dropped before reject was called.
-		for _, m := range batch[b.batchSize:] {
+		// Part or all of the batch was dropped before reject was called, the
+		// oldest metrics are the ones overwritten.
+		for _, m := range batch[:len(batch)-b.batchSize] {
 			b.metricDropped(m)
 		}
 	}
@@ -201,12 +398,55 @@ func (b *Buffer) Reject(batch []telegraf.Metric) {
 	b.resetBatch()
 }
 
+// Close is a no-op, the metrics left in memory are lost.
+func (b *Buffer) Close() error {
+	return nil
+}
+
+// removeBatch removes the metrics of the last batch still in the buffer.
+func (b *Buffer) removeBatch() {
+	b.size -= b.batchSize
+	for i := 0; i < b.batchSize; i++ {
+		b.bytes -= b.sizes[b.first]
+		b.buf[b.first] = nil
+		b.sizes[b.first] = 0
+		b.first++
+		b.first %= b.cap
+	}
+
+	b.resetBatch()
+}
+
 func (b *Buffer) resetBatch() {
 	b.batchFirst = 0
 	b.batchLast = 0
 	b.batchSize = 0
 }
 
+// MetricSize estimates the size of the metric in bytes from the size of its
+// name, tags and fields.
+func MetricSize(m telegraf.Metric) int64 {
+	// timestamp
+	size := int64(len(m.Name())) + 8
+	for _, tag := range m.TagList() {
+		size += int64(len(tag.Key) + len(tag.Value))
+	}
+	for _, field := range m.FieldList() {
+		size += int64(len(field.Key))
+		switch v := field.Value.(type) {
+		case string:
+			size += int64(len(v))
+		case []byte:
+			size += int64(len(v))
+		case bool:
+			size++
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
 func min(a, b int) int {
 	if b < a {
 		return b
@@ -1,11 +1,18 @@
 package models
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/selfstat"
+	"github.com/influxdata/telegraf/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -44,342 +51,706 @@ func Metric() telegraf.Metric {
 }
 
 func BenchmarkAddMetrics(b *testing.B) {
-	buf := NewBuffer("test", 10000)
+	buf := NewBuffer("test", "", 10000)
 	m := Metric()
 	for n := 0; n < b.N; n++ {
 		buf.Add(m)
 	}
 }
 
-func setup(b *Buffer) *Buffer {
-	b.MetricsAdded.Set(0)
-	b.MetricsWritten.Set(0)
-	b.MetricsDropped.Set(0)
-	return b
+// testBuffer gives the tests access to the stats of any buffer type.
+type testBuffer struct {
+	MetricBuffer
+	buffer         *Buffer
+	MetricsAdded   selfstat.Stat
+	MetricsWritten selfstat.Stat
+	MetricsDropped selfstat.Stat
+}
+
+type newBufferFunc func(capacity int) MetricBuffer
+
+// testBuffers runs the test against a memory and a disk buffer.
+func testBuffers(t *testing.T, test func(t *testing.T, newBuffer newBufferFunc)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, func(capacity int) MetricBuffer {
+			return NewBuffer("test", "", capacity)
+		})
+	})
+	t.Run("disk", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "buffer")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		var buffers []*DiskBuffer
+		test(t, func(capacity int) MetricBuffer {
+			b, err := NewDiskBuffer("test", "", capacity, DiskBufferConfig{
+				Directory: filepath.Join(dir, strconv.Itoa(len(buffers))),
+			})
+			require.NoError(t, err)
+			buffers = append(buffers, b)
+			return b
+		})
+		for _, b := range buffers {
+			require.NoError(t, b.Close())
+		}
+	})
+}
+
+func setup(b MetricBuffer) *testBuffer {
+	var stats *Buffer
+	switch b := b.(type) {
+	case *testBuffer:
+		return setup(b.MetricBuffer)
+	case *Buffer:
+		stats = b
+	case *DiskBuffer:
+		stats = b.Buffer
+	}
+	stats.MetricsAdded.Set(0)
+	stats.MetricsWritten.Set(0)
+	stats.MetricsDropped.Set(0)
+	return &testBuffer{
+		MetricBuffer:   b,
+		buffer:         stats,
+		MetricsAdded:   stats.MetricsAdded,
+		MetricsWritten: stats.MetricsWritten,
+		MetricsDropped: stats.MetricsDropped,
+	}
 }
 
 func TestBuffer_LenEmpty(t *testing.T) {
-	b := setup(NewBuffer("test", 5))
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		b := setup(newBuffer(5))
 
-	require.Equal(t, 0, b.Len())
+		require.Equal(t, 0, b.Len())
+	})
 }
 
 func TestBuffer_LenOne(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m)
 
-	require.Equal(t, 1, b.Len())
+		require.Equal(t, 1, b.Len())
+	})
 }
 
 func TestBuffer_LenFull(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m, m, m, m, m)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m, m, m, m, m)
 
-	require.Equal(t, 5, b.Len())
+		require.Equal(t, 5, b.Len())
+	})
 }
 
 func TestBuffer_LenOverfill(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	setup(b)
-	b.Add(m, m, m, m, m, m)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		setup(b)
+		b.Add(m, m, m, m, m, m)
 
-	require.Equal(t, 5, b.Len())
+		require.Equal(t, 5, b.Len())
+	})
 }
 
 func TestBuffer_BatchLenZero(t *testing.T) {
-	b := setup(NewBuffer("test", 5))
-	batch := b.Batch(0)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		b := setup(newBuffer(5))
+		batch := b.Batch(0)
 
-	require.Len(t, batch, 0)
+		require.Len(t, batch, 0)
+	})
 }
 
 func TestBuffer_BatchLenBufferEmpty(t *testing.T) {
-	b := setup(NewBuffer("test", 5))
-	batch := b.Batch(2)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		b := setup(newBuffer(5))
+		batch := b.Batch(2)
 
-	require.Len(t, batch, 0)
+		require.Len(t, batch, 0)
+	})
 }
 
 func TestBuffer_BatchLenUnderfill(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m)
-	batch := b.Batch(2)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m)
+		batch := b.Batch(2)
 
-	require.Len(t, batch, 1)
+		require.Len(t, batch, 1)
+	})
 }
 
 func TestBuffer_BatchLenFill(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m, m, m)
-	batch := b.Batch(2)
-	require.Len(t, batch, 2)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m, m, m)
+		batch := b.Batch(2)
+		require.Len(t, batch, 2)
+	})
 }
 
 func TestBuffer_BatchLenExact(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m, m)
-	batch := b.Batch(2)
-	require.Len(t, batch, 2)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m, m)
+		batch := b.Batch(2)
+		require.Len(t, batch, 2)
+	})
 }
 
 func TestBuffer_BatchLenLargerThanBuffer(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m, m, m, m, m)
-	batch := b.Batch(6)
-	require.Len(t, batch, 5)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m, m, m, m, m)
+		batch := b.Batch(6)
+		require.Len(t, batch, 5)
+	})
 }
 
 func TestBuffer_BatchWrap(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m, m, m, m, m)
-	batch := b.Batch(2)
-	b.Accept(batch)
-	b.Add(m, m)
-	batch = b.Batch(5)
-	require.Len(t, batch, 5)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m, m, m, m, m)
+		batch := b.Batch(2)
+		b.Accept(batch)
+		b.Add(m, m)
+		batch = b.Batch(5)
+		require.Len(t, batch, 5)
+	})
 }
 
 func TestBuffer_AddDropsOverwrittenMetrics(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
 
-	b.Add(m, m, m, m, m)
-	b.Add(m, m, m, m, m)
+		b.Add(m, m, m, m, m)
+		b.Add(m, m, m, m, m)
 
-	require.Equal(t, int64(5), b.MetricsDropped.Get())
-	require.Equal(t, int64(0), b.MetricsWritten.Get())
+		require.Equal(t, int64(5), b.MetricsDropped.Get())
+		require.Equal(t, int64(0), b.MetricsWritten.Get())
+	})
 }
 
 func TestBuffer_AcceptRemovesBatch(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m, m, m)
-	batch := b.Batch(2)
-	b.Accept(batch)
-	require.Equal(t, 1, b.Len())
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m, m, m)
+		batch := b.Batch(2)
+		b.Accept(batch)
+		require.Equal(t, 1, b.Len())
+	})
 }
 
 func TestBuffer_RejectLeavesBatch(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m, m, m)
-	batch := b.Batch(2)
-	b.Reject(batch)
-	require.Equal(t, 3, b.Len())
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m, m, m)
+		batch := b.Batch(2)
+		b.Reject(batch)
+		require.Equal(t, 3, b.Len())
+	})
 }
 
 func TestBuffer_AcceptWritesOverwrittenBatch(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
 
-	b.Add(m, m, m, m, m)
-	batch := b.Batch(5)
-	b.Add(m, m, m, m, m)
-	b.Accept(batch)
+		b.Add(m, m, m, m, m)
+		batch := b.Batch(5)
+		b.Add(m, m, m, m, m)
+		b.Accept(batch)
 
-	require.Equal(t, int64(0), b.MetricsDropped.Get())
-	require.Equal(t, int64(5), b.MetricsWritten.Get())
+		require.Equal(t, int64(0), b.MetricsDropped.Get())
+		require.Equal(t, int64(5), b.MetricsWritten.Get())
+	})
 }
 
 func TestBuffer_BatchRejectDropsOverwrittenBatch(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
 
-	b.Add(m, m, m, m, m)
-	batch := b.Batch(5)
-	b.Add(m, m, m, m, m)
-	b.Reject(batch)
+		b.Add(m, m, m, m, m)
+		batch := b.Batch(5)
+		b.Add(m, m, m, m, m)
+		b.Reject(batch)
 
-	require.Equal(t, int64(5), b.MetricsDropped.Get())
-	require.Equal(t, int64(0), b.MetricsWritten.Get())
+		require.Equal(t, int64(5), b.MetricsDropped.Get())
+		require.Equal(t, int64(0), b.MetricsWritten.Get())
+	})
 }
 
 func TestBuffer_MetricsOverwriteBatchAccept(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
 
-	b.Add(m, m, m, m, m)
-	batch := b.Batch(3)
-	b.Add(m, m, m)
-	b.Accept(batch)
-	require.Equal(t, int64(0), b.MetricsDropped.Get())
-	require.Equal(t, int64(3), b.MetricsWritten.Get())
+		b.Add(m, m, m, m, m)
+		batch := b.Batch(3)
+		b.Add(m, m, m)
+		b.Accept(batch)
+		require.Equal(t, int64(0), b.MetricsDropped.Get())
+		require.Equal(t, int64(3), b.MetricsWritten.Get())
+	})
 }
 
 func TestBuffer_MetricsOverwriteBatchReject(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
 
-	b.Add(m, m, m, m, m)
-	batch := b.Batch(3)
-	b.Add(m, m, m)
-	b.Reject(batch)
-	require.Equal(t, int64(3), b.MetricsDropped.Get())
-	require.Equal(t, int64(0), b.MetricsWritten.Get())
+		b.Add(m, m, m, m, m)
+		batch := b.Batch(3)
+		b.Add(m, m, m)
+		b.Reject(batch)
+		require.Equal(t, int64(3), b.MetricsDropped.Get())
+		require.Equal(t, int64(0), b.MetricsWritten.Get())
+	})
 }
 
 func TestBuffer_MetricsBatchAcceptRemoved(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
 
-	b.Add(m, m, m, m, m)
-	batch := b.Batch(3)
-	b.Add(m, m, m, m, m)
-	b.Accept(batch)
-	require.Equal(t, int64(2), b.MetricsDropped.Get())
-	require.Equal(t, int64(3), b.MetricsWritten.Get())
+		b.Add(m, m, m, m, m)
+		batch := b.Batch(3)
+		b.Add(m, m, m, m, m)
+		b.Accept(batch)
+		require.Equal(t, int64(2), b.MetricsDropped.Get())
+		require.Equal(t, int64(3), b.MetricsWritten.Get())
+	})
 }
 
 func TestBuffer_WrapWithBatch(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
 
-	b.Add(m, m, m)
-	b.Batch(3)
-	b.Add(m, m, m, m, m, m)
+		b.Add(m, m, m)
+		b.Batch(3)
+		b.Add(m, m, m, m, m, m)
 
-	require.Equal(t, int64(1), b.MetricsDropped.Get())
+		require.Equal(t, int64(1), b.MetricsDropped.Get())
+	})
 }
 
 func TestBuffer_BatchNotRemoved(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m, m, m, m, m)
-	b.Batch(2)
-	require.Equal(t, 5, b.Len())
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m, m, m, m, m)
+		b.Batch(2)
+		require.Equal(t, 5, b.Len())
+	})
 }
 
 func TestBuffer_BatchRejectAcceptNoop(t *testing.T) {
-	m := Metric()
-	b := setup(NewBuffer("test", 5))
-	b.Add(m, m, m, m, m)
-	batch := b.Batch(2)
-	b.Reject(batch)
-	b.Accept(batch)
-	require.Equal(t, 5, b.Len())
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := Metric()
+		b := setup(newBuffer(5))
+		b.Add(m, m, m, m, m)
+		batch := b.Batch(2)
+		b.Reject(batch)
+		b.Accept(batch)
+		require.Equal(t, 5, b.Len())
+	})
 }
 
 func TestBuffer_AcceptCallsMetricAccept(t *testing.T) {
-	var accept int
-	mm := &MockMetric{
-		Metric: Metric(),
-		AcceptF: func() {
-			accept++
-		},
-	}
-	b := setup(NewBuffer("test", 5))
-	b.Add(mm, mm, mm)
-	batch := b.Batch(2)
-	b.Accept(batch)
-	require.Equal(t, 2, accept)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		var accept int
+		mm := &MockMetric{
+			Metric: Metric(),
+			AcceptF: func() {
+				accept++
+			},
+		}
+		b := setup(newBuffer(5))
+		b.Add(mm, mm, mm)
+		batch := b.Batch(2)
+		b.Accept(batch)
+		require.Equal(t, 2, accept)
+	})
 }
 
 func TestBuffer_AddCallsMetricRejectWhenNoBatch(t *testing.T) {
-	var reject int
-	mm := &MockMetric{
-		Metric: Metric(),
-		RejectF: func() {
-			reject++
-		},
-	}
-	b := setup(NewBuffer("test", 5))
-	setup(b)
-	b.Add(mm, mm, mm, mm, mm)
-	b.Add(mm, mm)
-	require.Equal(t, 2, reject)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		var reject int
+		mm := &MockMetric{
+			Metric: Metric(),
+			RejectF: func() {
+				reject++
+			},
+		}
+		b := setup(newBuffer(5))
+		setup(b)
+		b.Add(mm, mm, mm, mm, mm)
+		b.Add(mm, mm)
+		require.Equal(t, 2, reject)
+	})
 }
 
 func TestBuffer_AddCallsMetricRejectWhenNotInBatch(t *testing.T) {
-	var reject int
-	mm := &MockMetric{
-		Metric: Metric(),
-		RejectF: func() {
-			reject++
-		},
-	}
-	b := setup(NewBuffer("test", 5))
-	setup(b)
-	b.Add(mm, mm, mm, mm, mm)
-	batch := b.Batch(2)
-	b.Add(mm, mm, mm, mm)
-	// metric[2] and metric[3] rejected
-	require.Equal(t, 2, reject)
-	b.Reject(batch)
-	// metric[1] and metric[2] now rejected
-	require.Equal(t, 4, reject)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		var reject int
+		mm := &MockMetric{
+			Metric: Metric(),
+			RejectF: func() {
+				reject++
+			},
+		}
+		b := setup(newBuffer(5))
+		setup(b)
+		b.Add(mm, mm, mm, mm, mm)
+		batch := b.Batch(2)
+		b.Add(mm, mm, mm, mm)
+		// metric[2] and metric[3] rejected
+		require.Equal(t, 2, reject)
+		b.Reject(batch)
+		// metric[1] and metric[2] now rejected
+		require.Equal(t, 4, reject)
+	})
 }
 
 func TestBuffer_RejectCallsMetricRejectWithOverwritten(t *testing.T) {
-	var reject int
-	mm := &MockMetric{
-		Metric: Metric(),
-		RejectF: func() {
-			reject++
-		},
-	}
-	b := setup(NewBuffer("test", 5))
-	b.Add(mm, mm, mm, mm, mm)
-	batch := b.Batch(5)
-	b.Add(mm, mm)
-	require.Equal(t, 0, reject)
-	b.Reject(batch)
-	require.Equal(t, 2, reject)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		var reject int
+		mm := &MockMetric{
+			Metric: Metric(),
+			RejectF: func() {
+				reject++
+			},
+		}
+		b := setup(newBuffer(5))
+		b.Add(mm, mm, mm, mm, mm)
+		batch := b.Batch(5)
+		b.Add(mm, mm)
+		require.Equal(t, 0, reject)
+		b.Reject(batch)
+		require.Equal(t, 2, reject)
+	})
+}
+
+func TestBuffer_RejectRejectsOverwrittenMetrics(t *testing.T) {
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		var accepted, rejected []int64
+		metrics := make([]telegraf.Metric, 5)
+		for i := range metrics {
+			i := int64(i)
+			metrics[i] = &MockMetric{
+				Metric:  valueMetric(i),
+				AcceptF: func() { accepted = append(accepted, i) },
+				RejectF: func() { rejected = append(rejected, i) },
+			}
+		}
+
+		b := setup(newBuffer(5))
+		b.Add(metrics...)
+		batch := b.Batch(4)
+		// The two oldest metrics of the batch are overwritten.
+		b.Add(valueMetrics(5, 7)...)
+		b.Reject(batch)
+		require.Equal(t, []int64{0, 1}, rejected)
+
+		// The metrics still buffered are only acknowledged when written.
+		b.Accept(b.Batch(3))
+		require.Equal(t, []int64{2, 3, 4}, accepted)
+		require.Equal(t, []int64{0, 1}, rejected)
+	})
 }
 
 func TestBuffer_AddOverwriteAndReject(t *testing.T) {
-	var reject int
-	mm := &MockMetric{
-		Metric: Metric(),
-		RejectF: func() {
-			reject++
-		},
-	}
-	b := setup(NewBuffer("test", 5))
-	b.Add(mm, mm, mm, mm, mm)
-	batch := b.Batch(5)
-	b.Add(mm, mm, mm, mm, mm)
-	b.Add(mm, mm, mm, mm, mm)
-	b.Add(mm, mm, mm, mm, mm)
-	b.Add(mm, mm, mm, mm, mm)
-	require.Equal(t, 15, reject)
-	b.Reject(batch)
-	require.Equal(t, 20, reject)
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		var reject int
+		mm := &MockMetric{
+			Metric: Metric(),
+			RejectF: func() {
+				reject++
+			},
+		}
+		b := setup(newBuffer(5))
+		b.Add(mm, mm, mm, mm, mm)
+		batch := b.Batch(5)
+		b.Add(mm, mm, mm, mm, mm)
+		b.Add(mm, mm, mm, mm, mm)
+		b.Add(mm, mm, mm, mm, mm)
+		b.Add(mm, mm, mm, mm, mm)
+		require.Equal(t, 15, reject)
+		b.Reject(batch)
+		require.Equal(t, 20, reject)
+	})
 }
 
 func TestBuffer_AddOverwriteAndRejectOffset(t *testing.T) {
-	var reject int
-	var accept int
-	mm := &MockMetric{
-		Metric: Metric(),
-		RejectF: func() {
-			reject++
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		var reject int
+		var accept int
+		mm := &MockMetric{
+			Metric: Metric(),
+			RejectF: func() {
+				reject++
+			},
+			AcceptF: func() {
+				accept++
+			},
+		}
+		b := setup(newBuffer(5))
+		b.Add(mm, mm, mm)
+		b.Add(mm, mm, mm, mm)
+		require.Equal(t, 2, reject)
+		batch := b.Batch(5)
+		b.Add(mm, mm, mm, mm)
+		require.Equal(t, 2, reject)
+		b.Add(mm, mm, mm, mm)
+		require.Equal(t, 5, reject)
+		b.Add(mm, mm, mm, mm)
+		require.Equal(t, 9, reject)
+		b.Add(mm, mm, mm, mm)
+		require.Equal(t, 13, reject)
+		b.Accept(batch)
+		require.Equal(t, 13, reject)
+		require.Equal(t, 5, accept)
+	})
+}
+
+func sizedMetric(name string, value string) telegraf.Metric {
+	m, err := metric.New(
+		name,
+		map[string]string{},
+		map[string]interface{}{
+			"value": value,
 		},
-		AcceptF: func() {
-			accept++
+		time.Unix(0, 0),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func TestBuffer_MetricSize(t *testing.T) {
+	m, err := metric.New(
+		"cpu",
+		map[string]string{"host": "localhost"},
+		map[string]interface{}{
+			"value": 42.0,
+			"state": "ok",
+			"up":    true,
 		},
+		time.Unix(0, 0),
+	)
+	require.NoError(t, err)
+
+	// name + timestamp + tags + fields
+	require.Equal(t, int64(3+8+13+(5+8)+(5+2)+(2+1)), MetricSize(m))
+}
+
+func TestBuffer_BytesLimitDropsOldest(t *testing.T) {
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		// each metric is 100 bytes
+		m := sizedMetric("cpu", strings.Repeat("x", 84))
+		require.Equal(t, int64(100), MetricSize(m))
+
+		b := setup(newBuffer(10))
+		b.buffer.SetOverflow(250, OverflowDropOldest)
+		b.Add(m, m, m)
+		require.Equal(t, 2, b.Len())
+		require.Equal(t, int64(200), b.Bytes())
+		require.Equal(t, int64(1), b.MetricsDropped.Get())
+
+		batch := b.Batch(2)
+		b.Accept(batch)
+		require.Equal(t, 0, b.Len())
+		require.Equal(t, int64(0), b.Bytes())
+	})
+}
+
+func TestBuffer_BytesLimitKeepsLargeMetric(t *testing.T) {
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		small := sizedMetric("cpu", "x")
+		large := sizedMetric("cpu", strings.Repeat("x", 500))
+
+		b := setup(newBuffer(10))
+		b.buffer.SetOverflow(250, OverflowDropOldest)
+		b.Add(small, small, large)
+		require.Equal(t, 1, b.Len())
+		require.Equal(t, int64(2), b.MetricsDropped.Get())
+		require.Equal(t, []telegraf.Metric{large}, b.Batch(10))
+	})
+}
+
+func TestBuffer_BytesLimitWithBatch(t *testing.T) {
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := sizedMetric("cpu", strings.Repeat("x", 84))
+
+		b := setup(newBuffer(10))
+		b.buffer.SetOverflow(300, OverflowDropOldest)
+		b.Add(m, m, m)
+		batch := b.Batch(2)
+		b.Add(m)
+		// The dropped metric is in the batch, it is only dropped on Reject
+		require.Equal(t, int64(0), b.MetricsDropped.Get())
+		b.Reject(batch)
+		require.Equal(t, int64(1), b.MetricsDropped.Get())
+		require.Equal(t, 3, b.Len())
+		require.Equal(t, int64(300), b.Bytes())
+	})
+}
+
+func TestBuffer_DropNewest(t *testing.T) {
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		b := setup(newBuffer(3))
+		b.buffer.SetOverflow(0, OverflowDropNewest)
+		metrics := []telegraf.Metric{
+			sizedMetric("cpu", "1"),
+			sizedMetric("cpu", "2"),
+			sizedMetric("cpu", "3"),
+			sizedMetric("cpu", "4"),
+			sizedMetric("cpu", "5"),
+		}
+		b.Add(metrics...)
+		require.Equal(t, 3, b.Len())
+		require.Equal(t, int64(2), b.MetricsDropped.Get())
+		require.Equal(t, metrics[:3], b.Batch(5))
+	})
+}
+
+func TestBuffer_DropNewestBytesLimit(t *testing.T) {
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		m := sizedMetric("cpu", strings.Repeat("x", 84))
+
+		b := setup(newBuffer(10))
+		b.buffer.SetOverflow(250, OverflowDropNewest)
+		b.Add(m, m, m)
+		require.Equal(t, 2, b.Len())
+		require.Equal(t, int64(1), b.MetricsDropped.Get())
+
+		b.Accept(b.Batch(1))
+		b.Add(m)
+		require.Equal(t, 2, b.Len())
+		require.Equal(t, int64(1), b.MetricsDropped.Get())
+	})
+}
+
+func TestBuffer_DropsPerMeasurement(t *testing.T) {
+	cpu := selfstat.Register("write_drops", "metrics_dropped",
+		map[string]string{"output": "drops_per_measurement", "measurement": "cpu"})
+	mem := selfstat.Register("write_drops", "metrics_dropped",
+		map[string]string{"output": "drops_per_measurement", "measurement": "mem"})
+	cpu.Set(0)
+	mem.Set(0)
+
+	b := NewBuffer("drops_per_measurement", "", 2)
+	b.Add(sizedMetric("cpu", "1"), sizedMetric("mem", "1"),
+		sizedMetric("cpu", "2"), sizedMetric("disk", "1"))
+
+	require.Equal(t, int64(1), cpu.Get())
+	require.Equal(t, int64(1), mem.Get())
+}
+
+func TestBuffer_DropsPerMeasurementLimit(t *testing.T) {
+	other := selfstat.Register("write_drops", "metrics_dropped",
+		map[string]string{"output": "drops_limit", "measurement": otherMeasurements})
+	other.Set(0)
+
+	b := NewBuffer("drops_limit", "", 1)
+	b.SetOverflow(0, OverflowDropNewest)
+	b.Add(sizedMetric("kept", "1"))
+	for i := 0; i < maxDropMeasurements+2; i++ {
+		b.Add(sizedMetric("m"+strconv.Itoa(i), "1"))
 	}
-	b := setup(NewBuffer("test", 5))
-	b.Add(mm, mm, mm)
-	b.Add(mm, mm, mm, mm)
-	require.Equal(t, 2, reject)
-	batch := b.Batch(5)
-	b.Add(mm, mm, mm, mm)
-	require.Equal(t, 2, reject)
-	b.Add(mm, mm, mm, mm)
-	require.Equal(t, 5, reject)
-	b.Add(mm, mm, mm, mm)
-	require.Equal(t, 9, reject)
-	b.Add(mm, mm, mm, mm)
-	require.Equal(t, 13, reject)
-	b.Accept(batch)
-	require.Equal(t, 13, reject)
-	require.Equal(t, 5, accept)
+	b.Add(sizedMetric("m0", "2"))
+
+	require.Len(t, b.drops, maxDropMeasurements+1)
+	require.Equal(t, int64(2), other.Get())
+	m0 := selfstat.Register("write_drops", "metrics_dropped",
+		map[string]string{"output": "drops_limit", "measurement": "m0"})
+	require.Equal(t, int64(2), m0.Get())
+}
+
+func TestBuffer_AcceptPartial(t *testing.T) {
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		b := setup(newBuffer(10))
+		b.Add(valueMetrics(0, 5)...)
+		batch := b.Batch(4)
+		b.AcceptPartial(batch, []int{1, 3}, nil)
+		require.Equal(t, int64(2), b.MetricsWritten.Get())
+		require.Equal(t, int64(0), b.MetricsDropped.Get())
+
+		// The rejected metrics are retried after the rest of the buffer.
+		require.Equal(t, 3, b.Len())
+		testutil.RequireMetricsEqual(t, []telegraf.Metric{
+			valueMetric(4), valueMetric(1), valueMetric(3),
+		}, b.Batch(10))
+	})
+}
+
+func TestBuffer_AcceptPartialDropped(t *testing.T) {
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		var accept, reject int
+		metrics := make([]telegraf.Metric, 4)
+		for i := range metrics {
+			metrics[i] = &MockMetric{
+				Metric:  valueMetric(int64(i)),
+				AcceptF: func() { accept++ },
+				RejectF: func() { reject++ },
+			}
+		}
+
+		b := setup(newBuffer(10))
+		b.Add(metrics...)
+		batch := b.Batch(3)
+		// The metrics the output cannot write are not retried.
+		b.AcceptPartial(batch, []int{2}, []int{0})
+		require.Equal(t, 1, accept)
+		require.Equal(t, 1, reject)
+		require.Equal(t, int64(1), b.MetricsWritten.Get())
+		require.Equal(t, int64(1), b.MetricsDropped.Get())
+		testutil.RequireMetricsEqual(t, []telegraf.Metric{
+			valueMetric(3), valueMetric(2),
+		}, b.Batch(10))
+	})
+}
+
+func TestBuffer_AcceptPartialDropsOverwritten(t *testing.T) {
+	testBuffers(t, func(t *testing.T, newBuffer newBufferFunc) {
+		var accept, reject int
+		metrics := make([]telegraf.Metric, 5)
+		for i := range metrics {
+			metrics[i] = &MockMetric{
+				Metric:  valueMetric(int64(i)),
+				AcceptF: func() { accept++ },
+				RejectF: func() { reject++ },
+			}
+		}
+
+		b := setup(newBuffer(5))
+		b.Add(metrics...)
+		batch := b.Batch(3)
+		b.Add(valueMetric(5))
+		// The first metric of the batch was overwritten, it cannot be
+		// retried.
+		b.AcceptPartial(batch, []int{0, 2}, nil)
+		require.Equal(t, 1, accept)
+		require.Equal(t, 1, reject)
+		require.Equal(t, int64(1), b.MetricsDropped.Get())
+		testutil.RequireMetricsEqual(t, []telegraf.Metric{
+			valueMetric(3), valueMetric(4), valueMetric(5), valueMetric(2),
+		}, b.Batch(10))
+	})
 }
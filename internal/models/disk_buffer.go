@@ -0,0 +1,207 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+const (
+	// Default size at which a new buffer segment file is started.
+	DEFAULT_BUFFER_SEGMENT_SIZE = 16 * 1024 * 1024
+)
+
+// DiskBufferConfig configures the write-ahead log of a DiskBuffer.
+type DiskBufferConfig struct {
+	// Directory holds the segment files, it must not be shared with another
+	// output.
+	Directory string
+	// SegmentSize is the size in bytes after which a new segment is started.
+	SegmentSize int64
+	// MaxSize is the size in bytes of the log after which whole segments
+	// are evicted according to the overflow policy, 0 for no limit.
+	MaxSize int64
+	// Fsync syncs the log to disk after every write.
+	Fsync bool
+}
+
+// DiskBuffer is a Buffer backed by a write-ahead log on disk. Every metric in
+// the buffer has a record in the log, and records are removed once the
+// metric is written or dropped, so the metrics pending after a crash or
+// restart are replayed when the buffer is opened again.
+type DiskBuffer struct {
+	*Buffer
+
+	mu      sync.Mutex
+	wal     *wal
+	maxSize int64
+
+	DiskSize selfstat.Stat
+}
+
+// NewDiskBuffer opens the buffer log in the configured directory, replaying
+// the metrics it contains.
+func NewDiskBuffer(name string, alias string, capacity int, conf DiskBufferConfig) (*DiskBuffer, error) {
+	if conf.SegmentSize <= 0 {
+		conf.SegmentSize = DEFAULT_BUFFER_SEGMENT_SIZE
+	}
+	// Segments are evicted whole, the log must hold more than one.
+	if conf.MaxSize > 0 && conf.MaxSize < 2*conf.SegmentSize {
+		return nil, fmt.Errorf("buffer_max_disk_size %d must be at least twice the buffer_segment_size %d",
+			conf.MaxSize, conf.SegmentSize)
+	}
+
+	w, metrics, err := openWAL(logName(name, alias), conf.Directory, conf.SegmentSize, conf.Fsync)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &DiskBuffer{
+		Buffer:  NewBuffer(name, alias, capacity),
+		wal:     w,
+		maxSize: conf.MaxSize,
+		DiskSize: selfstat.Register(
+			"write",
+			"buffer_disk_bytes",
+			pluginTags("output", name, alias),
+		),
+	}
+
+	if len(metrics) > 0 {
+		log.Printf("I! [outputs.%s] replaying %d buffered metrics from %s",
+			logName(name, alias), len(metrics), conf.Directory)
+		b.Buffer.Add(metrics...)
+		// Metrics over the capacity were dropped by the buffer
+		b.remove(len(metrics) - b.Buffer.Len())
+	}
+	b.DiskSize.Set(w.size())
+	return b, nil
+}
+
+// Add logs the metrics and adds them to the buffer. A metric which cannot be
+// logged is dropped.
+func (b *DiskBuffer) Add(metrics ...telegraf.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Buffer.Lock()
+	defer b.Buffer.Unlock()
+
+	b.addLogged(metrics)
+}
+
+// addLogged logs and adds the metrics, the caller must hold both locks.
+func (b *DiskBuffer) addLogged(metrics []telegraf.Metric) {
+	removed := 0
+	for _, m := range metrics {
+		if b.overflow == OverflowDropNewest && b.full(b.metricSize(m)) {
+			b.metricDropped(m)
+			continue
+		}
+
+		record, err := encodeRecord(m)
+		if err == nil && b.diskFull(int64(len(record))) {
+			if b.overflow == OverflowDropNewest {
+				b.metricDropped(m)
+				continue
+			}
+			b.remove(removed)
+			removed = 0
+			b.evictSegments(int64(len(record)))
+		}
+		if err == nil {
+			err = b.wal.append(record)
+		}
+		if err != nil {
+			log.Printf("E! [outputs.%s] dropping metric %s, unable to buffer on disk: %v",
+				logName(b.name, b.alias), m.Name(), err)
+			b.metricDropped(m)
+			continue
+		}
+
+		// Metrics overwritten by the buffer leave from the front of the log.
+		size := b.size
+		b.add(m)
+		removed += size + 1 - b.size
+	}
+	if err := b.wal.sync(); err != nil {
+		log.Printf("E! [outputs.%s] unable to sync buffer: %v", logName(b.name, b.alias), err)
+	}
+	b.remove(removed)
+}
+
+// diskFull reports whether n more bytes would take the log over its maximum
+// size. A record larger than the maximum is kept on its own.
+func (b *DiskBuffer) diskFull(n int64) bool {
+	size := b.wal.size()
+	return b.maxSize > 0 && size > 0 && size+n > b.maxSize
+}
+
+// evictSegments drops the metrics of the oldest segments until n more bytes
+// fit in the log, the caller must hold both locks.
+func (b *DiskBuffer) evictSegments(n int64) {
+	for b.diskFull(n) {
+		s := b.wal.segments[0]
+		count := s.count - s.acked
+		if count > b.size {
+			count = b.size
+		}
+		for i := 0; i < count; i++ {
+			b.dropFirst()
+		}
+		// The whole segment leaves the log, even past the buffered metrics.
+		b.remove(s.count - s.acked)
+	}
+}
+
+// DiskFull reports whether adding metrics of about n bytes would take the log
+// over its maximum size.
+func (b *DiskBuffer) DiskFull(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.diskFull(n)
+}
+
+// Accept removes the metrics contained in the last batch from the buffer and
+// the log.
+func (b *DiskBuffer) Accept(batch []telegraf.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	size := b.Buffer.Len()
+	b.Buffer.Accept(batch)
+	b.remove(size - b.Buffer.Len())
+}
+
+// AcceptPartial removes the metrics contained in the last batch from the
+// buffer and the log, the rejected metrics are logged again as the newest.
+func (b *DiskBuffer) AcceptPartial(batch []telegraf.Metric, rejected, dropped []int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Buffer.Lock()
+	defer b.Buffer.Unlock()
+
+	size := b.size
+	retry := b.acceptPartial(batch, rejected, dropped)
+	b.remove(size - b.size)
+	b.addLogged(retry)
+}
+
+// Close closes the log, the metrics still buffered are kept on disk.
+func (b *DiskBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.wal.close()
+}
+
+func (b *DiskBuffer) remove(n int) {
+	if err := b.wal.remove(n); err != nil {
+		log.Printf("E! [outputs.%s] unable to remove metrics from buffer log: %v",
+			logName(b.name, b.alias), err)
+	}
+	b.DiskSize.Set(b.wal.size())
+}
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func valueMetric(value int64) telegraf.Metric {
+	m, err := metric.New(
+		"cpu",
+		map[string]string{"host": "localhost"},
+		map[string]interface{}{
+			"value": value,
+			"usage": 42.5,
+			"state": "ok",
+			"up":    true,
+			"count": uint64(7),
+		},
+		time.Unix(0, value),
+		telegraf.Gauge,
+	)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func valueMetrics(from, to int64) []telegraf.Metric {
+	var metrics []telegraf.Metric
+	for i := from; i < to; i++ {
+		metrics = append(metrics, valueMetric(i))
+	}
+	return metrics
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "disk_buffer")
+	require.NoError(t, err)
+	return dir
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+walSegmentExt))
+	require.NoError(t, err)
+	return files
+}
+
+func TestDiskBuffer_ReplayAfterCrash(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	b, err := NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir, Fsync: true})
+	require.NoError(t, err)
+	b.Add(valueMetrics(0, 5)...)
+
+	// The first buffer is abandoned without Close, as on a crash.
+	b, err = NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir, Fsync: true})
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.Equal(t, 5, b.Len())
+	testutil.RequireMetricsEqual(t, valueMetrics(0, 5), b.Batch(10))
+}
+
+func TestDiskBuffer_ReplaySkipsAccepted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	b, err := NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	b.Add(valueMetrics(0, 5)...)
+	b.Accept(b.Batch(2))
+	b.Reject(b.Batch(2))
+	require.NoError(t, b.Close())
+
+	b, err = NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.Equal(t, 3, b.Len())
+	testutil.RequireMetricsEqual(t, valueMetrics(2, 5), b.Batch(10))
+}
+
+func TestDiskBuffer_ReplayOverwritten(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	b, err := NewDiskBuffer("test", "", 5, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	b.Add(valueMetrics(0, 8)...)
+	require.NoError(t, b.Close())
+
+	b, err = NewDiskBuffer("test", "", 5, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	testutil.RequireMetricsEqual(t, valueMetrics(3, 8), b.Batch(10))
+	require.NoError(t, b.Close())
+
+	// Replaying into a smaller buffer drops the oldest metrics from the log.
+	b, err = NewDiskBuffer("test", "", 2, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	b, err = NewDiskBuffer("test", "", 5, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	defer b.Close()
+	testutil.RequireMetricsEqual(t, valueMetrics(6, 8), b.Batch(10))
+}
+
+func TestDiskBuffer_SegmentsRemoved(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	b, err := NewDiskBuffer("test", "", 100, DiskBufferConfig{Directory: dir, SegmentSize: 200})
+	require.NoError(t, err)
+	defer b.Close()
+
+	b.Add(valueMetrics(0, 20)...)
+	require.True(t, len(segmentFiles(t, dir)) > 1)
+	require.True(t, b.DiskSize.Get() > 0)
+
+	b.Accept(b.Batch(10))
+	b.Accept(b.Batch(10))
+	require.Equal(t, 0, b.Len())
+	require.Len(t, segmentFiles(t, dir), 0)
+	require.Equal(t, int64(0), b.DiskSize.Get())
+
+	b.Add(valueMetrics(20, 22)...)
+	testutil.RequireMetricsEqual(t, valueMetrics(20, 22), b.Batch(10))
+}
+
+func TestDiskBuffer_MaxSizeEvictsSegments(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	record, err := encodeRecord(valueMetric(0))
+	require.NoError(t, err)
+	size := int64(len(record))
+
+	// Each segment holds three metrics, the log two segments.
+	b, err := NewDiskBuffer("test", "", 100, DiskBufferConfig{
+		Directory:   dir,
+		SegmentSize: 3 * size,
+		MaxSize:     6 * size,
+	})
+	require.NoError(t, err)
+	defer b.Close()
+
+	dropped := b.MetricsDropped.Get()
+	b.Add(valueMetrics(0, 6)...)
+	require.Len(t, segmentFiles(t, dir), 2)
+	require.False(t, b.DiskFull(0))
+	require.True(t, b.DiskFull(size))
+
+	// The oldest segments are evicted with the metrics they hold.
+	b.Add(valueMetrics(6, 10)...)
+	require.Len(t, segmentFiles(t, dir), 2)
+	require.True(t, b.DiskSize.Get() <= 6*size)
+	require.Equal(t, dropped+6, b.MetricsDropped.Get())
+	testutil.RequireMetricsEqual(t, valueMetrics(6, 10), b.Batch(10))
+}
+
+func TestDiskBuffer_MaxSizeDropNewest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	record, err := encodeRecord(valueMetric(0))
+	require.NoError(t, err)
+	size := int64(len(record))
+
+	b, err := NewDiskBuffer("test", "", 100, DiskBufferConfig{
+		Directory:   dir,
+		SegmentSize: 3 * size,
+		MaxSize:     6 * size,
+	})
+	require.NoError(t, err)
+	defer b.Close()
+	b.SetOverflow(0, OverflowDropNewest)
+
+	dropped := b.MetricsDropped.Get()
+	b.Add(valueMetrics(0, 10)...)
+	require.Equal(t, dropped+4, b.MetricsDropped.Get())
+	testutil.RequireMetricsEqual(t, valueMetrics(0, 6), b.Batch(10))
+}
+
+func TestDiskBuffer_MaxSizeTooSmall(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	_, err := NewDiskBuffer("test", "", 100, DiskBufferConfig{
+		Directory:   dir,
+		SegmentSize: 1000,
+		MaxSize:     1500,
+	})
+	require.Error(t, err)
+}
+
+func TestDiskBuffer_TruncatedRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	b, err := NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	b.Add(valueMetrics(0, 3)...)
+	require.NoError(t, b.Close())
+
+	// Cut the last record in half, as on a crash during a write.
+	files := segmentFiles(t, dir)
+	require.Len(t, files, 1)
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(files[0], info.Size()-10))
+
+	b, err = NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	testutil.RequireMetricsEqual(t, valueMetrics(0, 2), b.Batch(10))
+	b.Add(valueMetric(3))
+	require.NoError(t, b.Close())
+
+	b, err = NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	defer b.Close()
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		valueMetric(0), valueMetric(1), valueMetric(3),
+	}, b.Batch(10))
+}
+
+func TestDiskBuffer_CorruptRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	b, err := NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	b.Add(valueMetrics(0, 3)...)
+	require.NoError(t, b.Close())
+
+	// Flip a byte in the payload of the second record.
+	files := segmentFiles(t, dir)
+	require.Len(t, files, 1)
+	buf, err := ioutil.ReadFile(files[0])
+	require.NoError(t, err)
+	record, err := encodeRecord(valueMetric(0))
+	require.NoError(t, err)
+	buf[len(record)+walHeaderSize+1] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(files[0], buf, 0644))
+
+	b, err = NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	defer b.Close()
+	testutil.RequireMetricsEqual(t, valueMetrics(0, 1), b.Batch(10))
+}
+
+func TestDiskBuffer_ReplayAcceptPartial(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	b, err := NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	b.Add(valueMetrics(0, 4)...)
+	b.AcceptPartial(b.Batch(3), []int{0}, nil)
+	require.NoError(t, b.Close())
+
+	b, err = NewDiskBuffer("test", "", 10, DiskBufferConfig{Directory: dir})
+	require.NoError(t, err)
+	defer b.Close()
+	testutil.RequireMetricsEqual(t, []telegraf.Metric{
+		valueMetric(3), valueMetric(0),
+	}, b.Batch(10))
+}
+
+func TestRunningOutput_DiskBufferReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	conf := &OutputConfig{
+		Filter:     Filter{},
+		BufferType: "disk",
+		DiskBuffer: DiskBufferConfig{Directory: dir},
+	}
+
+	m := &mockOutput{failWrite: true}
+	ro := NewRunningOutput("test", m, conf, 1000, 10000)
+	require.NoError(t, ro.OpenDiskBuffer())
+	for _, metric := range valueMetrics(0, 5) {
+		ro.AddMetric(metric)
+	}
+	require.Error(t, ro.Write())
+	require.NoError(t, ro.Close())
+
+	m = &mockOutput{}
+	ro = NewRunningOutput("test", m, conf, 1000, 10000)
+	require.NoError(t, ro.OpenDiskBuffer())
+	require.NoError(t, ro.Write())
+	require.NoError(t, ro.Close())
+	testutil.RequireMetricsEqual(t, valueMetrics(0, 5), m.Metrics())
+
+	// Nothing is left to replay once written.
+	ro = NewRunningOutput("test", &mockOutput{}, conf, 1000, 10000)
+	require.NoError(t, ro.OpenDiskBuffer())
+	defer ro.Close()
+	require.Equal(t, 0, ro.buffer.Len())
+}
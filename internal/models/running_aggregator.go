@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/selfstat"
 )
 
@@ -31,32 +32,43 @@ func NewRunningAggregator(
 		MetricsPushed: selfstat.Register(
 			"aggregate",
 			"metrics_pushed",
-			map[string]string{"aggregator": config.Name},
+			pluginTags("aggregator", config.Name, config.Alias),
 		),
 		MetricsFiltered: selfstat.Register(
 			"aggregate",
 			"metrics_filtered",
-			map[string]string{"aggregator": config.Name},
+			pluginTags("aggregator", config.Name, config.Alias),
 		),
 		MetricsDropped: selfstat.Register(
 			"aggregate",
 			"metrics_dropped",
-			map[string]string{"aggregator": config.Name},
+			pluginTags("aggregator", config.Name, config.Alias),
 		),
 		PushTime: selfstat.Register(
 			"aggregate",
 			"push_time_ns",
-			map[string]string{"aggregator": config.Name},
+			pluginTags("aggregator", config.Name, config.Alias),
 		),
 	}
 }
 
 // AggregatorConfig is the common config for all aggregators.
 type AggregatorConfig struct {
-	Name         string
+	Name string
+	// Alias tells the instances of the same plugin apart in the internal
+	// stats and the logs.
+	Alias string
+	// Source is the config file the plugin comes from.
+	Source string
+	// ID identifies the plugin instance, it is derived from a hash of its
+	// configuration and so stays the same across reloads until changed.
+	ID           string
 	DropOriginal bool
 	Period       time.Duration
 	Delay        time.Duration
+	// Schedule, when set, gives the starts of the periods instead of the
+	// period, aligning them to the wall clock.
+	Schedule *internal.CronSchedule
 
 	NameOverride      string
 	MeasurementPrefix string
@@ -69,13 +81,39 @@ func (r *RunningAggregator) Name() string {
 	return "aggregators." + r.Config.Name
 }
 
+// LogName returns the name of the aggregator in the logs, followed by its
+// alias when set.
+func (r *RunningAggregator) LogName() string {
+	return "aggregators." + logName(r.Config.Name, r.Config.Alias)
+}
+
 func (r *RunningAggregator) Period() time.Duration {
 	return r.Config.Period
 }
 
 func (r *RunningAggregator) SetPeriodStart(start time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
 	r.periodStart = start
-	r.periodEnd = r.periodStart.Add(r.Config.Period).Add(r.Config.Delay)
+	r.periodEnd = r.endOfPeriod(start)
+}
+
+// PeriodEnd returns the time the current period is pushed at.
+func (r *RunningAggregator) PeriodEnd() time.Time {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.periodEnd
+}
+
+// endOfPeriod returns the end of the period starting at start, delayed to
+// let the late metrics in.
+func (r *RunningAggregator) endOfPeriod(start time.Time) time.Time {
+	if r.Config.Schedule != nil {
+		return r.Config.Schedule.Next(start).Add(r.Config.Delay)
+	}
+	return start.Add(r.Config.Period).Add(r.Config.Delay)
 }
 
 func (r *RunningAggregator) MakeMetric(metric telegraf.Metric) telegraf.Metric {
@@ -136,7 +174,7 @@ func (r *RunningAggregator) Push(acc telegraf.Accumulator) {
 	defer r.Unlock()
 
 	r.periodStart = r.periodEnd
-	r.periodEnd = r.periodStart.Add(r.Config.Period).Add(r.Config.Delay)
+	r.periodEnd = r.endOfPeriod(r.periodStart)
 	r.push(acc)
 	r.Aggregator.Reset()
 }
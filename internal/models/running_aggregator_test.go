@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/testutil"
 
 	"github.com/stretchr/testify/require"
@@ -176,3 +177,45 @@ func (t *TestAggregator) Add(in telegraf.Metric) {
 		}
 	}
 }
+
+func TestAddWithSchedule(t *testing.T) {
+	schedule, err := internal.ParseCronSchedule("0 */5 * * * *")
+	require.NoError(t, err)
+	a := &TestAggregator{}
+	ra := NewRunningAggregator(a, &AggregatorConfig{
+		Name: "TestRunningAggregator",
+		Filter: Filter{
+			NamePass: []string{"*"},
+		},
+		Schedule: schedule,
+		Delay:    time.Second,
+	})
+	require.NoError(t, ra.Config.Filter.Compile())
+	acc := testutil.Accumulator{}
+
+	start := time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC)
+	ra.SetPeriodStart(start)
+	require.Equal(t, start.Add(5*time.Minute+time.Second), ra.PeriodEnd())
+
+	m := testutil.MustMetric("RITest",
+		map[string]string{},
+		map[string]interface{}{
+			"value": int64(101),
+		},
+		start.Add(4*time.Minute),
+		telegraf.Untyped)
+	require.False(t, ra.Add(m))
+	late := testutil.MustMetric("RITest",
+		map[string]string{},
+		map[string]interface{}{
+			"value": int64(202),
+		},
+		start.Add(6*time.Minute),
+		telegraf.Untyped)
+	require.False(t, ra.Add(late))
+	ra.Push(&acc)
+
+	require.Equal(t, 1, len(acc.Metrics))
+	require.Equal(t, int64(101), acc.Metrics[0].Fields["sum"])
+	require.Equal(t, start.Add(10*time.Minute+time.Second), ra.PeriodEnd())
+}
@@ -1,22 +1,35 @@
 package models
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/selfstat"
 )
 
 var GlobalMetricsGathered = selfstat.Register("agent", "metrics_gathered", map[string]string{})
 
 type RunningInput struct {
+	// lastGather is the time the last gather completed in unix nanoseconds,
+	// first in the struct for its atomic access to be aligned.
+	lastGather int64
+	// gathering counts the gathers running, including the abandoned ones.
+	gathering int32
+
 	Input  telegraf.Input
 	Config *InputConfig
+	// Processors are applied to the metrics of the input only, before the
+	// processors of the agent.
+	Processors RunningProcessors
 
 	defaultTags map[string]string
 
 	MetricsGathered selfstat.Stat
 	GatherTime      selfstat.Stat
+	GatherErrors    selfstat.Stat
+	GatherTimeouts  selfstat.Stat
 }
 
 func NewRunningInput(input telegraf.Input, config *InputConfig) *RunningInput {
@@ -26,19 +39,37 @@ func NewRunningInput(input telegraf.Input, config *InputConfig) *RunningInput {
 		MetricsGathered: selfstat.Register(
 			"gather",
 			"metrics_gathered",
-			map[string]string{"input": config.Name},
+			pluginTags("input", config.Name, config.Alias),
 		),
 		GatherTime: selfstat.RegisterTiming(
 			"gather",
 			"gather_time_ns",
-			map[string]string{"input": config.Name},
+			pluginTags("input", config.Name, config.Alias),
+		),
+		GatherErrors: selfstat.Register(
+			"gather",
+			"errors",
+			pluginTags("input", config.Name, config.Alias),
+		),
+		GatherTimeouts: selfstat.Register(
+			"gather",
+			"timeouts",
+			pluginTags("input", config.Name, config.Alias),
 		),
 	}
 }
 
 // InputConfig is the common config for all inputs.
 type InputConfig struct {
-	Name     string
+	Name string
+	// Alias tells the instances of the same plugin apart in the internal
+	// stats and the logs.
+	Alias string
+	// Source is the config file the plugin comes from.
+	Source string
+	// ID identifies the plugin instance, it is derived from a hash of its
+	// configuration and so stays the same across reloads until changed.
+	ID       string
 	Interval time.Duration
 
 	NameOverride      string
@@ -46,12 +77,32 @@ type InputConfig struct {
 	MeasurementSuffix string
 	Tags              map[string]string
 	Filter            Filter
+
+	// Route is set as the route tag on every metric of the input, selecting
+	// the outputs the metrics are delivered to.
+	Route string
+
+	// GatherTimeout is how long a gather runs before it is abandoned, 0 to
+	// wait for it.
+	GatherTimeout time.Duration
+	// SkipIfRunning skips the gathers due while an abandoned gather still
+	// runs.
+	SkipIfRunning bool
+	// Schedule, when set, gives the times the input gathers at instead of
+	// the interval.
+	Schedule *internal.CronSchedule
 }
 
 func (r *RunningInput) Name() string {
 	return "inputs." + r.Config.Name
 }
 
+// LogName returns the name of the input in the logs, followed by its alias
+// when set, ie "inputs.tableprov::primary".
+func (r *RunningInput) LogName() string {
+	return "inputs." + logName(r.Config.Name, r.Config.Alias)
+}
+
 func (r *RunningInput) metricFiltered(metric telegraf.Metric) {
 	metric.Drop()
 }
@@ -76,19 +127,52 @@ func (r *RunningInput) MakeMetric(metric telegraf.Metric) telegraf.Metric {
 		return nil
 	}
 
+	if r.Config.Route != "" {
+		m.AddTag(RouteTag, r.Config.Route)
+	}
+
 	r.MetricsGathered.Incr(1)
 	GlobalMetricsGathered.Incr(1)
 	return m
 }
 
+// Process applies the processors of the input to the metric.
+func (r *RunningInput) Process(metric telegraf.Metric) []telegraf.Metric {
+	return r.Processors.Apply(metric)
+}
+
 func (r *RunningInput) Gather(acc telegraf.Accumulator) error {
+	atomic.AddInt32(&r.gathering, 1)
+	defer atomic.AddInt32(&r.gathering, -1)
+
 	start := time.Now()
 	err := r.Input.Gather(acc)
 	elapsed := time.Since(start)
 	r.GatherTime.Incr(elapsed.Nanoseconds())
+	atomic.StoreInt64(&r.lastGather, time.Now().UnixNano())
 	return err
 }
 
+// LastGather returns the time the last gather completed, the zero time if
+// the input never gathered.
+func (r *RunningInput) LastGather() time.Time {
+	n := atomic.LoadInt64(&r.lastGather)
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// IsGathering reports whether a gather of the input is running.
+func (r *RunningInput) IsGathering() bool {
+	return atomic.LoadInt32(&r.gathering) > 0
+}
+
+// IncrErrors counts an error of the input.
+func (r *RunningInput) IncrErrors() {
+	r.GatherErrors.Incr(1)
+}
+
 func (r *RunningInput) SetDefaultTags(tags map[string]string) {
 	r.defaultTags = tags
 }
@@ -261,3 +261,53 @@ type testInput struct{}
 func (t *testInput) Description() string                   { return "" }
 func (t *testInput) SampleConfig() string                  { return "" }
 func (t *testInput) Gather(acc telegraf.Accumulator) error { return nil }
+
+func TestMakeMetricRoute(t *testing.T) {
+	now := time.Now()
+	ri := NewRunningInput(&testInput{}, &InputConfig{
+		Name:  "TestRunningInput",
+		Route: "tableprov",
+		Filter: Filter{
+			TagInclude: []string{"a"},
+		},
+	})
+	require.NoError(t, ri.Config.Filter.Compile())
+
+	m, err := metric.New("RITest",
+		map[string]string{"a": "x", RouteTag: "system"},
+		map[string]interface{}{
+			"value": int64(101),
+		},
+		now)
+	require.NoError(t, err)
+
+	actual := ri.MakeMetric(m)
+
+	expected, err := metric.New("RITest",
+		map[string]string{"a": "x", RouteTag: "tableprov"},
+		map[string]interface{}{
+			"value": int64(101),
+		},
+		now)
+	require.NoError(t, err)
+
+	testutil.RequireMetricEqual(t, expected, actual)
+}
+
+func TestRunningInputAlias(t *testing.T) {
+	primary := NewRunningInput(&testInput{}, &InputConfig{Name: "alias", Alias: "primary"})
+	backup := NewRunningInput(&testInput{}, &InputConfig{Name: "alias", Alias: "backup"})
+	plain := NewRunningInput(&testInput{}, &InputConfig{Name: "alias"})
+
+	assert.Equal(t, "inputs.alias::primary", primary.LogName())
+	assert.Equal(t, "inputs.alias", plain.LogName())
+
+	primary.MetricsGathered.Incr(2)
+	backup.MetricsGathered.Incr(1)
+	assert.Equal(t, int64(2), primary.MetricsGathered.Get())
+	assert.Equal(t, int64(1), backup.MetricsGathered.Get())
+	assert.Equal(t, int64(0), plain.MetricsGathered.Get())
+	assert.Equal(t, map[string]string{"input": "alias", "alias": "primary"},
+		primary.MetricsGathered.Tags())
+	assert.Equal(t, map[string]string{"input": "alias"}, plain.MetricsGathered.Tags())
+}
@@ -3,9 +3,11 @@ package models
 import (
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/selfstat"
 )
 
@@ -15,35 +17,86 @@ const (
 
 	// Default number of metrics kept. It should be a multiple of batch size.
 	DEFAULT_METRIC_BUFFER_LIMIT = 10000
+
+	// RouteTag is the tag selecting the outputs a metric is delivered to. The
+	// name is reserved so that it does not clash with the tags of the inputs,
+	// the tag is removed before the metric reaches an output.
+	RouteTag = "_route"
+
+	// RouteAll subscribes an output to every route, including unrouted
+	// metrics.
+	RouteAll = "*"
 )
 
 // OutputConfig containing name and filter
 type OutputConfig struct {
-	Name   string
+	Name string
+	// Alias tells the instances of the same plugin apart in the internal
+	// stats and the logs.
+	Alias string
+	// Source is the config file the plugin comes from.
+	Source string
+	// ID identifies the plugin instance, it is derived from a hash of its
+	// configuration and so stays the same across reloads until changed.
+	ID     string
 	Filter Filter
 
 	FlushInterval     time.Duration
 	MetricBufferLimit int
 	MetricBatchSize   int
+
+	// MetricBufferBytesLimit limits the estimated size of the buffered
+	// metrics, 0 for no limit.
+	MetricBufferBytesLimit int64
+	// OverflowPolicy is applied when the buffer is full, one of
+	// drop_oldest, drop_newest or block_inputs.
+	OverflowPolicy string
+
+	// BufferType is either "memory" or "disk". The disk buffer keeps the
+	// unsent metrics across restarts.
+	BufferType string
+	DiskBuffer DiskBufferConfig
+
+	// Routes lists the routes the output is subscribed to. An output without
+	// routes receives the metrics which do not carry a route tag.
+	Routes []string
 }
 
 // RunningOutput contains the output configuration
 type RunningOutput struct {
+	// lastWrite is the time of the last successful write in unix
+	// nanoseconds, first in the struct for its atomic access to be aligned.
+	lastWrite int64
+
 	Name              string
 	Output            telegraf.Output
 	Config            *OutputConfig
 	MetricBufferLimit int
 	MetricBatchSize   int
+	// Processors are applied to the metrics of the output only, once
+	// selected by its filters.
+	Processors RunningProcessors
 
 	MetricsFiltered selfstat.Stat
 	BufferSize      selfstat.Stat
 	BufferLimit     selfstat.Stat
 	WriteTime       selfstat.Stat
+	Connected       selfstat.Stat
+
+	// connected is set to 1 once the output is connected.
+	connected int32
 
 	batch      []telegraf.Metric
-	buffer     *Buffer
+	batchBytes int64
+	buffer     MetricBuffer
 	BatchReady chan time.Time
 
+	// bufferFreed is signaled when metrics leave the buffer, and unblocked
+	// is closed once AddMetric must no longer block.
+	bufferFreed chan struct{}
+	unblocked   chan struct{}
+	unblockOnce sync.Once
+
 	aggMutex   sync.Mutex
 	batchMutex sync.Mutex
 }
@@ -67,11 +120,15 @@ func NewRunningOutput(
 	if batchSize == 0 {
 		batchSize = DEFAULT_METRIC_BATCH_SIZE
 	}
+	buffer := NewBuffer(name, conf.Alias, bufferLimit)
+	buffer.SetOverflow(conf.MetricBufferBytesLimit, conf.OverflowPolicy)
 	ro := &RunningOutput{
 		Name:              name,
 		batch:             make([]telegraf.Metric, 0, batchSize),
-		buffer:            NewBuffer(name, bufferLimit),
+		buffer:            buffer,
 		BatchReady:        make(chan time.Time, 1),
+		bufferFreed:       make(chan struct{}, 1),
+		unblocked:         make(chan struct{}),
 		Output:            output,
 		Config:            conf,
 		MetricBufferLimit: bufferLimit,
@@ -79,22 +136,27 @@ func NewRunningOutput(
 		MetricsFiltered: selfstat.Register(
 			"write",
 			"metrics_filtered",
-			map[string]string{"output": name},
+			pluginTags("output", name, conf.Alias),
 		),
 		BufferSize: selfstat.Register(
 			"write",
 			"buffer_size",
-			map[string]string{"output": name},
+			pluginTags("output", name, conf.Alias),
 		),
 		BufferLimit: selfstat.Register(
 			"write",
 			"buffer_limit",
-			map[string]string{"output": name},
+			pluginTags("output", name, conf.Alias),
 		),
 		WriteTime: selfstat.RegisterTiming(
 			"write",
 			"write_time_ns",
-			map[string]string{"output": name},
+			pluginTags("output", name, conf.Alias),
+		),
+		Connected: selfstat.Register(
+			"write",
+			"connected",
+			pluginTags("output", name, conf.Alias),
 		),
 	}
 
@@ -102,6 +164,42 @@ func NewRunningOutput(
 	return ro
 }
 
+// Connect connects the output. Until it succeeds the output is disconnected
+// and its metrics are only buffered.
+func (ro *RunningOutput) Connect() error {
+	err := ro.Output.Connect()
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&ro.connected, 1)
+	ro.Connected.Set(1)
+	return nil
+}
+
+// IsConnected reports whether the output was connected.
+func (ro *RunningOutput) IsConnected() bool {
+	return atomic.LoadInt32(&ro.connected) == 1
+}
+
+// LogName returns the name of the output in the logs, followed by its alias
+// when set, ie "outputs.akamill::primary".
+func (ro *RunningOutput) LogName() string {
+	return "outputs." + logName(ro.Name, ro.Config.Alias)
+}
+
+// OpenDiskBuffer replaces the memory buffer with a buffer logged to disk,
+// replaying the metrics left over from a previous run.
+func (ro *RunningOutput) OpenDiskBuffer() error {
+	buffer, err := NewDiskBuffer(ro.Name, ro.Config.Alias, ro.MetricBufferLimit, ro.Config.DiskBuffer)
+	if err != nil {
+		return err
+	}
+	buffer.SetOverflow(ro.Config.MetricBufferBytesLimit, ro.Config.OverflowPolicy)
+	ro.buffer = buffer
+	ro.BufferSize.Set(int64(buffer.Len()))
+	return nil
+}
+
 func (ro *RunningOutput) metricFiltered(metric telegraf.Metric) {
 	ro.MetricsFiltered.Incr(1)
 	metric.Drop()
@@ -122,6 +220,17 @@ func (ro *RunningOutput) AddMetric(metric telegraf.Metric) {
 		return
 	}
 
+	if len(ro.Processors) == 0 {
+		ro.addMetric(metric)
+		return
+	}
+	for _, m := range ro.Processors.Apply(metric) {
+		ro.addMetric(m)
+	}
+}
+
+// addMetric adds a metric selected and processed to the output.
+func (ro *RunningOutput) addMetric(metric telegraf.Metric) {
 	if output, ok := ro.Output.(telegraf.AggregatingOutput); ok {
 		ro.aggMutex.Lock()
 		output.Add(metric)
@@ -131,7 +240,14 @@ func (ro *RunningOutput) AddMetric(metric telegraf.Metric) {
 
 	ro.batchMutex.Lock()
 
+	if ro.Config.OverflowPolicy == OverflowBlockInputs {
+		ro.waitForRoom(metric)
+	}
+
 	ro.batch = append(ro.batch, metric)
+	if ro.Config.MetricBufferBytesLimit > 0 || ro.Config.DiskBuffer.MaxSize > 0 {
+		ro.batchBytes += MetricSize(metric)
+	}
 	if len(ro.batch) == ro.MetricBatchSize {
 		ro.addBatchToBuffer()
 
@@ -151,6 +267,86 @@ func (ro *RunningOutput) AddMetric(metric telegraf.Metric) {
 func (ro *RunningOutput) addBatchToBuffer() {
 	ro.buffer.Add(ro.batch...)
 	ro.batch = ro.batch[:0]
+	ro.batchBytes = 0
+}
+
+// full reports whether the metric would overflow the buffer once the
+// current batch is added to it.
+func (ro *RunningOutput) full(metric telegraf.Metric) bool {
+	if ro.buffer.Len()+len(ro.batch) >= ro.MetricBufferLimit {
+		return true
+	}
+	if ro.buffer.Len()+len(ro.batch) == 0 {
+		return false
+	}
+	if db, ok := ro.buffer.(*DiskBuffer); ok && db.DiskFull(ro.batchBytes+MetricSize(metric)) {
+		return true
+	}
+	limit := ro.Config.MetricBufferBytesLimit
+	return limit > 0 && ro.buffer.Bytes()+ro.batchBytes+MetricSize(metric) > limit
+}
+
+// waitForRoom blocks until the buffer has room for the metric, holding back
+// the inputs. It must be called with the batchMutex held.
+func (ro *RunningOutput) waitForRoom(metric telegraf.Metric) {
+	if !ro.full(metric) {
+		return
+	}
+
+	log.Printf("W! [%s] buffer full, blocking inputs until metrics are written",
+		ro.LogName())
+	for ro.full(metric) {
+		select {
+		case ro.BatchReady <- time.Now():
+		default:
+		}
+
+		ro.batchMutex.Unlock()
+		select {
+		case <-ro.bufferFreed:
+		case <-ro.unblocked:
+		}
+		ro.batchMutex.Lock()
+
+		select {
+		case <-ro.unblocked:
+			return
+		default:
+		}
+	}
+}
+
+// Unblock stops AddMetric from waiting for room in the buffer, so that the
+// agent can shut down while the output is failing. The buffer then drops the
+// oldest metrics when full.
+func (ro *RunningOutput) Unblock() {
+	ro.unblockOnce.Do(func() {
+		close(ro.unblocked)
+	})
+}
+
+func (ro *RunningOutput) bufferAccepted(batch []telegraf.Metric) {
+	ro.buffer.Accept(batch)
+	ro.signalFreed()
+}
+
+// writeFailed returns the batch to the buffer after a failed write. When the
+// output wrote part of the batch, only the rejected metrics are kept and the
+// ones it cannot write are dropped.
+func (ro *RunningOutput) writeFailed(batch []telegraf.Metric, err error) {
+	if perr, ok := err.(*internal.PartialWriteError); ok {
+		ro.buffer.AcceptPartial(batch, perr.MetricsReject, perr.MetricsDrop)
+		ro.signalFreed()
+		return
+	}
+	ro.buffer.Reject(batch)
+}
+
+func (ro *RunningOutput) signalFreed() {
+	select {
+	case ro.bufferFreed <- struct{}{}:
+	default:
+	}
 }
 
 // Write writes all metrics to the output, stopping when all have been sent on
@@ -181,10 +377,10 @@ func (ro *RunningOutput) Write() error {
 
 		err := ro.write(batch)
 		if err != nil {
-			ro.buffer.Reject(batch)
+			ro.writeFailed(batch, err)
 			return err
 		}
-		ro.buffer.Accept(batch)
+		ro.bufferAccepted(batch)
 	}
 	return nil
 }
@@ -198,10 +394,10 @@ func (ro *RunningOutput) WriteBatch() error {
 
 	err := ro.write(batch)
 	if err != nil {
-		ro.buffer.Reject(batch)
+		ro.writeFailed(batch, err)
 		return err
 	}
-	ro.buffer.Accept(batch)
+	ro.bufferAccepted(batch)
 
 	return nil
 }
@@ -213,14 +409,51 @@ func (ro *RunningOutput) write(metrics []telegraf.Metric) error {
 	ro.WriteTime.Incr(elapsed.Nanoseconds())
 
 	if err == nil {
-		log.Printf("D! [outputs.%s] wrote batch of %d metrics in %s\n",
-			ro.Name, len(metrics), elapsed)
+		atomic.StoreInt64(&ro.lastWrite, time.Now().UnixNano())
+		log.Printf("D! [%s] wrote batch of %d metrics in %s\n",
+			ro.LogName(), len(metrics), elapsed)
+	}
+	return err
+}
+
+// LastWrite returns the time of the last successful write, the zero time if
+// the output never wrote.
+func (ro *RunningOutput) LastWrite() time.Time {
+	n := atomic.LoadInt64(&ro.lastWrite)
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// BufferLength returns the number of metrics waiting to be written.
+func (ro *RunningOutput) BufferLength() int {
+	ro.batchMutex.Lock()
+	n := len(ro.batch)
+	ro.batchMutex.Unlock()
+	return ro.buffer.Len() + n
+}
+
+// Close closes the output and its buffer. An output which never connected is
+// not closed.
+func (ro *RunningOutput) Close() error {
+	var err error
+	if ro.IsConnected() {
+		err = ro.Output.Close()
+		atomic.StoreInt32(&ro.connected, 0)
+		ro.Connected.Set(0)
+	} else if n := ro.buffer.Len(); n > 0 {
+		log.Printf("W! [%s] never connected, %d buffered metrics not written",
+			ro.LogName(), n)
+	}
+	if berr := ro.buffer.Close(); err == nil {
+		err = berr
 	}
 	return err
 }
 
 func (ro *RunningOutput) LogBufferStatus() {
 	nBuffer := ro.buffer.Len()
-	log.Printf("D! [outputs.%s] buffer fullness: %d / %d metrics. ",
-		ro.Name, nBuffer, ro.MetricBufferLimit)
+	log.Printf("D! [%s] buffer fullness: %d / %d metrics. ",
+		ro.LogName(), nBuffer, ro.MetricBufferLimit)
 }
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/testutil"
 
 	"github.com/stretchr/testify/assert"
@@ -412,6 +414,139 @@ func TestRunningOutputWriteFailOrder3(t *testing.T) {
 	assert.Equal(t, expected, m.Metrics())
 }
 
+func TestRunningOutputBlockInputs(t *testing.T) {
+	conf := &OutputConfig{
+		Filter:         Filter{},
+		OverflowPolicy: OverflowBlockInputs,
+	}
+
+	m := &mockOutput{}
+	m.failWrite = true
+	ro := NewRunningOutput("test", m, conf, 2, 4)
+
+	for _, metric := range first5[:4] {
+		ro.AddMetric(metric)
+	}
+	assert.Error(t, ro.Write())
+
+	added := make(chan struct{})
+	go func() {
+		ro.AddMetric(first5[4])
+		close(added)
+	}()
+
+	select {
+	case <-added:
+		t.Fatal("AddMetric did not block on a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.failWrite = false
+	require.NoError(t, ro.Write())
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("AddMetric still blocked after write")
+	}
+
+	require.NoError(t, ro.Write())
+	assert.Len(t, m.Metrics(), 5)
+}
+
+func TestRunningOutputUnblock(t *testing.T) {
+	conf := &OutputConfig{
+		Filter:         Filter{},
+		OverflowPolicy: OverflowBlockInputs,
+	}
+
+	m := &mockOutput{}
+	m.failWrite = true
+	ro := NewRunningOutput("test", m, conf, 2, 4)
+
+	for _, metric := range first5[:4] {
+		ro.AddMetric(metric)
+	}
+
+	added := make(chan struct{})
+	go func() {
+		ro.AddMetric(first5[4])
+		close(added)
+	}()
+
+	ro.Unblock()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("AddMetric still blocked after Unblock")
+	}
+}
+
+func TestRunningOutputPartialWrite(t *testing.T) {
+	conf := &OutputConfig{
+		Filter: Filter{},
+	}
+
+	m := &mockOutput{reject: []int{1, 2}}
+	ro := NewRunningOutput("test", m, conf, 1000, 10000)
+	for _, metric := range first5 {
+		ro.AddMetric(metric)
+	}
+
+	require.Error(t, ro.Write())
+	assert.Equal(t, 2, ro.buffer.Len())
+	assert.Equal(t, []telegraf.Metric{first5[0], first5[3], first5[4]}, m.Metrics())
+
+	require.NoError(t, ro.Write())
+	assert.Equal(t, 0, ro.buffer.Len())
+	assert.Equal(t, []telegraf.Metric{
+		first5[0], first5[3], first5[4], first5[1], first5[2],
+	}, m.Metrics())
+}
+
+// Test that the processors of the output are applied to the metrics it
+// selected, in order.
+func TestRunningOutputProcessors(t *testing.T) {
+	conf := &OutputConfig{
+		Filter: Filter{
+			NameDrop: []string{"metric1"},
+		},
+	}
+	assert.NoError(t, conf.Filter.Compile())
+
+	drop := &MockProcessor{
+		ApplyF: func(in ...telegraf.Metric) []telegraf.Metric {
+			var out []telegraf.Metric
+			for _, m := range in {
+				if m.Name() != "metric2" {
+					out = append(out, m)
+				}
+			}
+			return out
+		},
+	}
+	m := &mockOutput{}
+	ro := NewRunningOutput("test", m, conf, 1000, 10000)
+	ro.Processors = RunningProcessors{
+		{Processor: drop, Config: &ProcessorConfig{Name: "drop"}},
+		{Processor: TagProcessor("stage", "first"), Config: &ProcessorConfig{Name: "first"}},
+		{Processor: TagProcessor("stage", "second"), Config: &ProcessorConfig{Name: "second"}},
+	}
+
+	for _, metric := range []telegraf.Metric{
+		testutil.TestMetric(101, "metric1"),
+		testutil.TestMetric(101, "metric2"),
+		testutil.TestMetric(101, "metric3"),
+	} {
+		ro.AddMetric(metric)
+	}
+	require.NoError(t, ro.Write())
+
+	require.Len(t, m.Metrics(), 1)
+	assert.Equal(t, "metric3", m.Metrics()[0].Name())
+	stage, _ := m.Metrics()[0].GetTag("stage")
+	assert.Equal(t, "second", stage)
+}
+
 type mockOutput struct {
 	sync.Mutex
 
@@ -419,6 +554,8 @@ type mockOutput struct {
 
 	// if true, mock a write failure
 	failWrite bool
+	// indices of the metrics of the next write to reject
+	reject []int
 }
 
 func (m *mockOutput) Connect() error {
@@ -448,8 +585,22 @@ func (m *mockOutput) Write(metrics []telegraf.Metric) error {
 		m.metrics = []telegraf.Metric{}
 	}
 
-	for _, metric := range metrics {
-		m.metrics = append(m.metrics, metric)
+	rejected := make(map[int]bool)
+	for _, i := range m.reject {
+		rejected[i] = true
+	}
+	for i, metric := range metrics {
+		if !rejected[i] {
+			m.metrics = append(m.metrics, metric)
+		}
+	}
+	if len(m.reject) > 0 {
+		err := &internal.PartialWriteError{
+			Err:           fmt.Errorf("Failed Write!"),
+			MetricsReject: m.reject,
+		}
+		m.reject = nil
+		return err
 	}
 	return nil
 }
@@ -463,6 +614,8 @@ func (m *mockOutput) Metrics() []telegraf.Metric {
 type perfOutput struct {
 	// if true, mock a write failure
 	failWrite bool
+	// indices of the metrics of the next write to reject
+	reject []int
 }
 
 func (m *perfOutput) Connect() error {
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/influxdata/telegraf"
@@ -20,13 +21,53 @@ func (rp RunningProcessors) Len() int           { return len(rp) }
 func (rp RunningProcessors) Swap(i, j int)      { rp[i], rp[j] = rp[j], rp[i] }
 func (rp RunningProcessors) Less(i, j int) bool { return rp[i].Config.Order < rp[j].Config.Order }
 
+// Apply applies the processors to the metrics, one after the other.
+func (rp RunningProcessors) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	for _, processor := range rp {
+		in = processor.Apply(in...)
+	}
+	return in
+}
+
+// Start starts the services of the processors, the processors started are
+// stopped again when one fails to start.
+func (rp RunningProcessors) Start() error {
+	for i, processor := range rp {
+		if err := processor.Start(); err != nil {
+			rp[:i].Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops the services of the processors.
+func (rp RunningProcessors) Stop() {
+	for _, processor := range rp {
+		processor.Stop()
+	}
+}
+
 // FilterConfig containing a name and filter
 type ProcessorConfig struct {
-	Name   string
+	Name string
+	// Alias tells the instances of the same plugin apart in the logs.
+	Alias string
+	// Source is the config file the plugin comes from.
+	Source string
+	// ID identifies the plugin instance, it is derived from a hash of its
+	// configuration and so stays the same across reloads until changed.
+	ID     string
 	Order  int64
 	Filter Filter
 }
 
+// LogName returns the name of the processor in the logs, followed by its
+// alias when set.
+func (rp *RunningProcessor) LogName() string {
+	return "processors." + logName(rp.Config.Name, rp.Config.Alias)
+}
+
 func (rp *RunningProcessor) metricFiltered(metric telegraf.Metric) {
 	metric.Drop()
 }
@@ -67,3 +108,22 @@ func (rp *RunningProcessor) Apply(in ...telegraf.Metric) []telegraf.Metric {
 
 	return ret
 }
+
+// Start starts the processor when it is a service processor.
+func (rp *RunningProcessor) Start() error {
+	sp, ok := rp.Processor.(telegraf.ServiceProcessor)
+	if !ok {
+		return nil
+	}
+	if err := sp.Start(); err != nil {
+		return fmt.Errorf("service for processor %s failed to start: %v", rp.LogName(), err)
+	}
+	return nil
+}
+
+// Stop stops the processor when it is a service processor.
+func (rp *RunningProcessor) Stop() {
+	if sp, ok := rp.Processor.(telegraf.ServiceProcessor); ok {
+		sp.Stop()
+	}
+}
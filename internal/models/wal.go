@@ -0,0 +1,548 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+const (
+	walSegmentExt  = ".wal"
+	walHeadFile    = "head"
+	walHeaderSize  = 8
+	walMaxRecord   = 64 * 1024 * 1024
+	walFieldInt    = 1
+	walFieldUint   = 2
+	walFieldFloat  = 3
+	walFieldString = 4
+	walFieldBool   = 5
+)
+
+var errWALCorrupt = errors.New("corrupt record")
+
+// wal is a write-ahead log of metrics split over segment files. Metrics are
+// appended to the newest segment and removed from the front of the log once
+// they have left the buffer. The position of the front is kept in the head
+// file, so that only the metrics still buffered are replayed on startup.
+//
+// Each record is the length and CRC-32 of the payload followed by the
+// encoded metric.
+type wal struct {
+	name        string
+	dir         string
+	segmentSize int64
+	fsync       bool
+
+	segments []*walSegment
+	nextID   uint64
+	tail     *os.File // newest segment, opened on the first append
+}
+
+type walSegment struct {
+	id    uint64
+	size  int64
+	count int // number of records in the segment
+	acked int // number of records removed from the front of the segment
+}
+
+func (s *walSegment) path(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", s.id, walSegmentExt))
+}
+
+// openWAL opens the log in dir and returns the metrics which have not been
+// removed yet. Corrupt segments are truncated at the last valid record.
+func openWAL(name string, dir string, segmentSize int64, fsync bool) (*wal, []telegraf.Metric, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	w := &wal{
+		name:        name,
+		dir:         dir,
+		segmentSize: segmentSize,
+		fsync:       fsync,
+		nextID:      1,
+	}
+
+	headID, headAcked, err := w.readHead()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids, err := w.segmentIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var metrics []telegraf.Metric
+	for _, id := range ids {
+		if id >= w.nextID {
+			w.nextID = id + 1
+		}
+
+		s := &walSegment{id: id}
+		if id < headID {
+			// Fully removed, but not deleted before shutdown.
+			os.Remove(s.path(dir))
+			continue
+		}
+		if id == headID {
+			s.acked = headAcked
+		}
+
+		replayed, err := w.replay(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		if s.acked > s.count {
+			s.acked = s.count
+		}
+		if s.count == 0 || s.acked == s.count {
+			os.Remove(s.path(dir))
+			continue
+		}
+		metrics = append(metrics, replayed...)
+		w.segments = append(w.segments, s)
+	}
+	if w.nextID <= headID {
+		w.nextID = headID + 1
+	}
+	return w, metrics, nil
+}
+
+func (w *wal) segmentIDs() ([]uint64, error) {
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, walSegmentExt), 10, 64)
+		if err != nil {
+			log.Printf("W! [outputs.%s] ignoring unknown buffer file %s", w.name, filepath.Join(w.dir, name))
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// replay reads all records of the segment, returning the metrics which
+// follow the acked records. The segment is truncated at the first invalid
+// record.
+func (w *wal) replay(s *walSegment) ([]telegraf.Metric, error) {
+	path := s.path(w.dir)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var metrics []telegraf.Metric
+	reader := bufio.NewReader(file)
+	for {
+		payload, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+
+		var m telegraf.Metric
+		if err == nil && s.count >= s.acked {
+			m, err = decodeMetric(payload)
+		}
+		if err != nil {
+			log.Printf("W! [outputs.%s] %s: %v at offset %d, dropping the rest of the segment",
+				w.name, path, err, s.size)
+			if err := os.Truncate(path, s.size); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		if m != nil {
+			metrics = append(metrics, m)
+		}
+		s.count++
+		s.size += int64(walHeaderSize + len(payload))
+	}
+	return metrics, nil
+}
+
+// append writes an encoded record to the end of the log. On error the
+// partially written record is truncated away.
+func (w *wal) append(record []byte) error {
+	if w.tail == nil || w.segments[len(w.segments)-1].size >= w.segmentSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	s := w.segments[len(w.segments)-1]
+	if _, err := w.tail.Write(record); err != nil {
+		w.tail.Truncate(s.size)
+		return err
+	}
+	s.size += int64(len(record))
+	s.count++
+	return nil
+}
+
+// sync flushes the newest segment to disk when fsync is enabled.
+func (w *wal) sync() error {
+	if !w.fsync || w.tail == nil {
+		return nil
+	}
+	return w.tail.Sync()
+}
+
+// rotate closes the current segment and starts a new one.
+func (w *wal) rotate() error {
+	if err := w.closeTail(); err != nil {
+		return err
+	}
+
+	s := &walSegment{id: w.nextID}
+	file, err := os.OpenFile(s.path(w.dir), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.nextID++
+	w.tail = file
+	w.segments = append(w.segments, s)
+	return nil
+}
+
+func (w *wal) closeTail() error {
+	if w.tail == nil {
+		return nil
+	}
+	err := w.tail.Sync()
+	if cerr := w.tail.Close(); err == nil {
+		err = cerr
+	}
+	w.tail = nil
+	return err
+}
+
+// remove removes n records from the front of the log, deleting the segments
+// which no longer hold any record.
+func (w *wal) remove(n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	for n > 0 && len(w.segments) > 0 {
+		s := w.segments[0]
+		k := n
+		if k > s.count-s.acked {
+			k = s.count - s.acked
+		}
+		s.acked += k
+		n -= k
+
+		if s.acked < s.count {
+			break
+		}
+		if len(w.segments) == 1 && w.tail != nil {
+			if err := w.closeTail(); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(s.path(w.dir)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		w.segments = w.segments[1:]
+	}
+	return w.writeHead()
+}
+
+// size returns the number of bytes used by the segments.
+func (w *wal) size() int64 {
+	var size int64
+	for _, s := range w.segments {
+		size += s.size
+	}
+	return size
+}
+
+func (w *wal) close() error {
+	return w.closeTail()
+}
+
+// readHead returns the segment and the number of records removed from it
+// at the front of the log.
+func (w *wal) readHead() (uint64, int, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(w.dir, walHeadFile))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var id uint64
+	var acked int
+	if _, err := fmt.Sscanf(string(buf), "%d %d", &id, &acked); err != nil {
+		log.Printf("W! [outputs.%s] invalid head file in %s, replaying all segments: %v",
+			w.name, w.dir, err)
+		return 0, 0, nil
+	}
+	return id, acked, nil
+}
+
+func (w *wal) writeHead() error {
+	id, acked := w.nextID, 0
+	if len(w.segments) > 0 {
+		id, acked = w.segments[0].id, w.segments[0].acked
+	}
+
+	path := filepath.Join(w.dir, walHeadFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "%d %d\n", id, acked)
+	if err == nil && w.fsync {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [walHeaderSize]byte
+	n, err := io.ReadFull(r, header[:])
+	if n == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, errWALCorrupt
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > walMaxRecord {
+		return nil, errWALCorrupt
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errWALCorrupt
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errWALCorrupt
+	}
+	return payload, nil
+}
+
+// encodeRecord returns the metric framed as a log record.
+func encodeRecord(m telegraf.Metric) ([]byte, error) {
+	payload, err := encodeMetric(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > walMaxRecord {
+		return nil, fmt.Errorf("metric too large: %d bytes", len(payload))
+	}
+
+	record := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[walHeaderSize:], payload)
+	return record, nil
+}
+
+func encodeMetric(m telegraf.Metric) ([]byte, error) {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+
+	putUvarint := func(v uint64) {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], v)])
+	}
+	putString := func(s string) {
+		putUvarint(uint64(len(s)))
+		buf.WriteString(s)
+	}
+
+	putString(m.Name())
+	buf.Write(scratch[:binary.PutVarint(scratch[:], m.Time().UnixNano())])
+	buf.WriteByte(byte(m.Type()))
+	if m.IsAggregate() {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+
+	tags := m.TagList()
+	putUvarint(uint64(len(tags)))
+	for _, tag := range tags {
+		putString(tag.Key)
+		putString(tag.Value)
+	}
+
+	fields := m.FieldList()
+	putUvarint(uint64(len(fields)))
+	for _, field := range fields {
+		putString(field.Key)
+		switch v := field.Value.(type) {
+		case int64:
+			buf.WriteByte(walFieldInt)
+			buf.Write(scratch[:binary.PutVarint(scratch[:], v)])
+		case uint64:
+			buf.WriteByte(walFieldUint)
+			putUvarint(v)
+		case float64:
+			buf.WriteByte(walFieldFloat)
+			binary.BigEndian.PutUint64(scratch[:8], math.Float64bits(v))
+			buf.Write(scratch[:8])
+		case string:
+			buf.WriteByte(walFieldString)
+			putString(v)
+		case bool:
+			buf.WriteByte(walFieldBool)
+			if v {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported type %T for field %s", field.Value, field.Key)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeMetric(payload []byte) (telegraf.Metric, error) {
+	r := bytes.NewReader(payload)
+
+	getString := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return "", errWALCorrupt
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", errWALCorrupt
+		}
+		return string(b), nil
+	}
+
+	name, err := getString()
+	if err != nil {
+		return nil, err
+	}
+	ns, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, errWALCorrupt
+	}
+	tp, err := r.ReadByte()
+	if err != nil {
+		return nil, errWALCorrupt
+	}
+	aggregate, err := r.ReadByte()
+	if err != nil {
+		return nil, errWALCorrupt
+	}
+
+	ntags, err := binary.ReadUvarint(r)
+	if err != nil || ntags > uint64(r.Len()) {
+		return nil, errWALCorrupt
+	}
+	tags := make(map[string]string, ntags)
+	for i := uint64(0); i < ntags; i++ {
+		key, err := getString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := getString()
+		if err != nil {
+			return nil, err
+		}
+		tags[key] = value
+	}
+
+	nfields, err := binary.ReadUvarint(r)
+	if err != nil || nfields > uint64(r.Len()) {
+		return nil, errWALCorrupt
+	}
+	fields := make(map[string]interface{}, nfields)
+	for i := uint64(0); i < nfields; i++ {
+		key, err := getString()
+		if err != nil {
+			return nil, err
+		}
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, errWALCorrupt
+		}
+		switch kind {
+		case walFieldInt:
+			v, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, errWALCorrupt
+			}
+			fields[key] = v
+		case walFieldUint:
+			v, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errWALCorrupt
+			}
+			fields[key] = v
+		case walFieldFloat:
+			var b [8]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return nil, errWALCorrupt
+			}
+			fields[key] = math.Float64frombits(binary.BigEndian.Uint64(b[:]))
+		case walFieldString:
+			v, err := getString()
+			if err != nil {
+				return nil, err
+			}
+			fields[key] = v
+		case walFieldBool:
+			v, err := r.ReadByte()
+			if err != nil {
+				return nil, errWALCorrupt
+			}
+			fields[key] = v == 1
+		default:
+			return nil, errWALCorrupt
+		}
+	}
+	if r.Len() != 0 {
+		return nil, errWALCorrupt
+	}
+
+	m, err := metric.New(name, tags, fields, time.Unix(0, ns), telegraf.ValueType(tp))
+	if err != nil {
+		return nil, err
+	}
+	m.SetAggregate(aggregate == 1)
+	return m, nil
+}
@@ -14,22 +14,42 @@ The commands & flags are:
   version             print the version to stdout
 
   --aggregator-filter <filter>   filter the aggregators to enable, separator is :
-  --config <file>                configuration file to load
+  --config <file>                configuration file to load, or HTTP(S) URL to
+                                 fetch it from
+  --config-check                 load and check the config file and directory,
+                                 reporting every problem, and exit
   --config-directory <directory> directory containing additional *.conf files
   --debug                        turn on debug logging
+  --effective-config-format <format>
+                                 format of the config printed by
+                                 --print-effective-config, toml (default) or json
   --input-filter <filter>        filter the inputs to enable, separator is :
   --input-list                   print available input plugins.
   --output-filter <filter>       filter the outputs to enable, separator is :
   --output-list                  print available output plugins.
   --pidfile <file>               file to write our pid to
   --pprof-addr <address>         pprof address to listen on, don't activate pprof if empty
+  --print-effective-config       print the config the agent runs with, merged
+                                 from the config file and directory with the
+                                 plugin defaults and the secrets redacted, and exit
   --processor-filter <filter>    filter the processors to enable, separator is :
   --quiet                        run in quiet mode
   --sample-config                print out full sample configuration
-  --test                         gather metrics, print them out, and exit;
-                                 processors, aggregators, and outputs are not run
+  --once                         gather, process, aggregate and write the metrics
+                                 to the outputs once, and exit
+  --test                         gather metrics through the processors and
+                                 aggregators, print them out, and exit; outputs
+                                 are not run
+  --test-format <format>         data format of the metrics printed by --test,
+                                 ie influx (default), json or tableprov_csv
+  --test-restriction <file>      evaluate a restriction file against the table
+                                 names given as arguments, or read from stdin
+  --test-wait <duration>         how long the service inputs run in --test and
+                                 --once modes, ie 5s (default)
   --usage <plugin>               print usage for a plugin, ie, 'telegraf --usage mysql'
   --version                      display the version and exit
+  --watch-config                 reload the config when the config file or the
+                                 *.conf files of the config directory change
 
   --console                      run as console application (windows only)
   --service <service>            operate on the service (windows only)
@@ -46,6 +66,21 @@ Examples:
   # run a single telegraf collection, outputing metrics to stdout
   telegraf --config telegraf.conf --test
 
+  # print the tableprov snapshots gathered in 10s, decoded to text
+  telegraf --config telegraf.conf --test --test-wait 10s --test-format tableprov_csv
+
+  # run a single collection to the outputs, ie from cron
+  telegraf --config telegraf.conf --once
+
+  # check the config before deploying it
+  telegraf --config telegraf.conf --config-directory telegraf.d --config-check
+
+  # show the config the agent runs with, and where each plugin comes from
+  telegraf --config telegraf.conf --config-directory telegraf.d --print-effective-config
+
+  # show which tables a restriction file drops on this host
+  telegraf --config telegraf.conf --test-restriction restriction.xml gm_table1 gm_table2
+
   # run telegraf with all plugins defined in config file
   telegraf --config telegraf.conf
 
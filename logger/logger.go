@@ -1,38 +1,224 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/influxdata/wlog"
 )
 
 var prefixRegex = regexp.MustCompile("^[DIWE]!")
 
+// Log levels, in increasing order of severity.
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelPrefixes = map[byte]int{
+	'D': levelDebug,
+	'I': levelInfo,
+	'W': levelWarn,
+	'E': levelError,
+}
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// Config configures the logging output.
+type Config struct {
+	// Debug sets the log level to DEBUG and Quiet to ERROR.
+	Debug bool
+	Quiet bool
+	// Logfile is the file the logs go to, stderr when empty.
+	Logfile string
+	// Format is either "text" (default) or "json".
+	Format string
+	// Levels overrides the log level of the plugins, by plugin name, ie
+	// {"inputs.tableprov": "debug"}, or by instance name with its alias, ie
+	// {"inputs.tableprov::primary": "debug"}.
+	Levels map[string]string
+
+	// RotationInterval rotates the logfile once opened for that long, never
+	// when 0.
+	RotationInterval time.Duration
+	// RotationMaxSize rotates the logfile before it grows over this size in
+	// bytes, never when 0.
+	RotationMaxSize int64
+	// RotationMaxArchives is the number of rotated logfiles kept, -1 to keep
+	// them all.
+	RotationMaxArchives int
+}
+
 // newTelegrafWriter returns a logging-wrapped writer.
 func newTelegrafWriter(w io.Writer) io.Writer {
 	return &telegrafLog{
-		writer: wlog.NewWriter(w),
+		writer: w,
+		level:  levelInfo,
 	}
 }
 
+// telegrafLog writes the log lines prefixed by their level, ie "E! ", and
+// usually the name of the plugin logging them, ie "[inputs.tableprov] ".
+// Lines under the level of their plugin are dropped.
 type telegrafLog struct {
 	writer io.Writer
+	json   bool
+	level  int
+	levels map[string]int
+}
+
+// record is a log line in the JSON format.
+type record struct {
+	Time       string `json:"time"`
+	Level      string `json:"level"`
+	PluginType string `json:"plugin_type,omitempty"`
+	PluginName string `json:"plugin_name,omitempty"`
+	Alias      string `json:"alias,omitempty"`
+	Message    string `json:"message"`
 }
 
 func (t *telegrafLog) Write(b []byte) (n int, err error) {
+	n = len(b)
+	b = redact(b)
+	level, plugin, msg := parseLine(b)
+
+	// The level of a plugin instance overrides the level of the plugin.
+	name, alias := plugin, ""
+	if i := strings.Index(plugin, "::"); i > 0 {
+		name, alias = plugin[:i], plugin[i+2:]
+	}
+	threshold := t.level
+	if l, ok := t.levels[name]; ok {
+		threshold = l
+	}
+	if l, ok := t.levels[plugin]; ok {
+		threshold = l
+	}
+	if level < threshold {
+		return n, nil
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if t.json {
+		r := record{
+			Time:    now,
+			Level:   levelNames[level],
+			Alias:   alias,
+			Message: msg,
+		}
+		if i := strings.Index(name, "."); i > 0 {
+			r.PluginType, r.PluginName = name[:i], name[i+1:]
+		} else {
+			r.PluginName = name
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := t.writer.Write(append(line, '\n')); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+
 	var line []byte
 	if !prefixRegex.Match(b) {
-		line = append([]byte(time.Now().UTC().Format(time.RFC3339)+" I! "), b...)
+		line = append([]byte(now+" I! "), b...)
 	} else {
-		line = append([]byte(time.Now().UTC().Format(time.RFC3339)+" "), b...)
+		line = append([]byte(now+" "), b...)
+	}
+	if _, err := t.writer.Write(line); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+var (
+	secretsMu sync.RWMutex
+	// secrets are redacted from the logs, the longest first.
+	secrets [][]byte
+)
+
+// AddSecrets redacts the secrets from the logs from now on, ie the values
+// read from the secret files of the config.
+func AddSecrets(s ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, secret := range s {
+		if secret != "" && !hasSecret(secret) {
+			secrets = append(secrets, []byte(secret))
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+}
+
+func hasSecret(secret string) bool {
+	for _, known := range secrets {
+		if string(known) == secret {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(b []byte) []byte {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+
+	for _, secret := range secrets {
+		b = bytes.Replace(b, secret, []byte("****"), -1)
+	}
+	return b
+}
+
+// parseLine returns the level, the plugin name and the message of a log
+// line, ie "W! [inputs.tableprov]: message". Lines without level are at
+// the info level, and lines without plugin have an empty name.
+func parseLine(b []byte) (int, string, string) {
+	level := levelInfo
+	rest := b
+	if prefixRegex.Match(b) {
+		level = levelPrefixes[b[0]]
+		rest = b[2:]
 	}
-	return t.writer.Write(line)
+	rest = bytes.TrimLeft(rest, ": ")
+
+	var plugin string
+	if len(rest) > 0 && rest[0] == '[' {
+		if i := bytes.IndexByte(rest, ']'); i > 0 {
+			plugin = string(rest[1:i])
+			rest = bytes.TrimLeft(rest[i+1:], ": ")
+		}
+	}
+	return level, plugin, string(bytes.TrimRight(rest, "\r\n "))
 }
 
+func parseLevel(name string) (int, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q, expected one of %s",
+		name, strings.Join(levelNames, ", "))
+}
+
+// logfile is the file the logs currently go to, closed when the logging is
+// set up again.
+var logfile io.Closer
+
 // SetupLogging configures the logging output.
 //   debug   will set the log level to DEBUG
 //   quiet   will set the log level to ERROR
@@ -40,30 +226,60 @@ func (t *telegrafLog) Write(b []byte) (n int, err error) {
 //           interpreted as stderr. If there is an error opening the file the
 //           logger will fallback to stderr.
 func SetupLogging(debug, quiet bool, logfile string) {
-	log.SetFlags(0)
-	if debug {
-		wlog.SetLevel(wlog.DEBUG)
+	SetupLoggingConfig(Config{
+		Debug:   debug,
+		Quiet:   quiet,
+		Logfile: logfile,
+	})
+}
+
+// SetupLoggingConfig configures the logging output, it returns an error when
+// the format or a level is invalid. If there is an error opening the logfile
+// the logger will fallback to stderr.
+func SetupLoggingConfig(config Config) error {
+	t := &telegrafLog{level: levelInfo}
+	if config.Debug {
+		t.level = levelDebug
 	}
-	if quiet {
-		wlog.SetLevel(wlog.ERROR)
+	if config.Quiet {
+		t.level = levelError
 	}
 
-	var oFile *os.File
-	if logfile != "" {
-		if _, err := os.Stat(logfile); os.IsNotExist(err) {
-			if oFile, err = os.Create(logfile); err != nil {
-				log.Printf("E! Unable to create %s (%s), using stderr", logfile, err)
-				oFile = os.Stderr
-			}
+	switch config.Format {
+	case "", "text":
+	case "json":
+		t.json = true
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", config.Format)
+	}
+
+	t.levels = make(map[string]int, len(config.Levels))
+	for plugin, name := range config.Levels {
+		level, err := parseLevel(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", plugin, err)
+		}
+		t.levels[plugin] = level
+	}
+
+	log.SetFlags(0)
+	previous := logfile
+	logfile = nil
+	t.writer = os.Stderr
+	if config.Logfile != "" {
+		w, err := newRotatingWriter(config.Logfile, config.RotationInterval,
+			config.RotationMaxSize, config.RotationMaxArchives)
+		if err != nil {
+			log.Printf("E! Unable to open %s (%s), using stderr", config.Logfile, err)
 		} else {
-			if oFile, err = os.OpenFile(logfile, os.O_APPEND|os.O_WRONLY, os.ModeAppend); err != nil {
-				log.Printf("E! Unable to append to %s (%s), using stderr", logfile, err)
-				oFile = os.Stderr
-			}
+			t.writer = w
+			logfile = w
 		}
-	} else {
-		oFile = os.Stderr
 	}
 
-	log.SetOutput(newTelegrafWriter(oFile))
+	log.SetOutput(t)
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
 }
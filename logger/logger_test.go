@@ -2,10 +2,15 @@ package logger
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -73,3 +78,165 @@ func BenchmarkTelegrafLogWrite(b *testing.B) {
 		w.Write(msg)
 	}
 }
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		level  int
+		plugin string
+		msg    string
+	}{
+		{"E! [agent] Error writing\n", levelError, "agent", "Error writing"},
+		{"W! [inputs.tableprov]: too many snapshots", levelWarn, "inputs.tableprov", "too many snapshots"},
+		{"W!: [agent] skipping", levelWarn, "agent", "skipping"},
+		{"[outputs.akamill]: 10 bytes written \n", levelInfo, "outputs.akamill", "10 bytes written"},
+		{"D! Reloading", levelDebug, "", "Reloading"},
+	}
+	for _, tt := range tests {
+		level, plugin, msg := parseLine([]byte(tt.line))
+		assert.Equal(t, tt.level, level, tt.line)
+		assert.Equal(t, tt.plugin, plugin, tt.line)
+		assert.Equal(t, tt.msg, msg, tt.line)
+	}
+}
+
+func TestJSONLog(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "")
+	assert.NoError(t, err)
+	defer func() { os.Remove(tmpfile.Name()) }()
+
+	err = SetupLoggingConfig(Config{Logfile: tmpfile.Name(), Format: "json"})
+	assert.NoError(t, err)
+	log.Printf("W! [inputs.tableprov]: too many snapshots")
+
+	f, err := ioutil.ReadFile(tmpfile.Name())
+	assert.NoError(t, err)
+	var r map[string]string
+	assert.NoError(t, json.Unmarshal(f, &r))
+	assert.Equal(t, "warn", r["level"])
+	assert.Equal(t, "inputs", r["plugin_type"])
+	assert.Equal(t, "tableprov", r["plugin_name"])
+	assert.Equal(t, "too many snapshots", r["message"])
+	assert.NotEmpty(t, r["time"])
+}
+
+func TestPluginLogLevels(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "")
+	assert.NoError(t, err)
+	defer func() { os.Remove(tmpfile.Name()) }()
+
+	err = SetupLoggingConfig(Config{
+		Logfile: tmpfile.Name(),
+		Levels: map[string]string{
+			"inputs.tableprov": "debug",
+			"outputs.akamill":  "error",
+		},
+	})
+	assert.NoError(t, err)
+	log.Printf("D! [inputs.tableprov] Starting Cycle")
+	log.Printf("D! [agent] TEST")           // <- should be ignored
+	log.Printf("W! [outputs.akamill] TEST") // <- should be ignored
+
+	f, err := ioutil.ReadFile(tmpfile.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Z D! [inputs.tableprov] Starting Cycle\n"), f[19:])
+
+	err = SetupLoggingConfig(Config{Levels: map[string]string{"agent": "verbose"}})
+	assert.Error(t, err)
+	err = SetupLoggingConfig(Config{Format: "xml"})
+	assert.Error(t, err)
+}
+
+func TestAliasLogLevels(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "")
+	assert.NoError(t, err)
+	defer func() { os.Remove(tmpfile.Name()) }()
+
+	err = SetupLoggingConfig(Config{
+		Logfile: tmpfile.Name(),
+		Format:  "json",
+		Levels: map[string]string{
+			"outputs.akamill":          "error",
+			"outputs.akamill::primary": "debug",
+		},
+	})
+	assert.NoError(t, err)
+	log.Printf("D! [outputs.akamill::primary] TEST")
+	log.Printf("W! [outputs.akamill::backup] TEST") // <- should be ignored
+
+	f, err := ioutil.ReadFile(tmpfile.Name())
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(f)), "\n")
+	assert.Len(t, lines, 1)
+	var r record
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &r))
+	assert.Equal(t, "outputs", r.PluginType)
+	assert.Equal(t, "akamill", r.PluginName)
+	assert.Equal(t, "primary", r.Alias)
+	assert.Equal(t, "TEST", r.Message)
+}
+
+func TestRedactSecrets(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "")
+	assert.NoError(t, err)
+	defer func() { os.Remove(tmpfile.Name()) }()
+
+	AddSecrets("hunter2", "hunter2!", "")
+	defer func() { secrets = nil }()
+	SetupLogging(false, false, tmpfile.Name())
+	log.Printf("I! [outputs.akamill] password hunter2! and hunter2")
+
+	f, err := ioutil.ReadFile(tmpfile.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Z I! [outputs.akamill] password **** and ****\n"), f[19:])
+}
+
+func TestRotateLogfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "telegraf.log")
+
+	w, err := newRotatingWriter(logfile, 0, 10, 2)
+	assert.NoError(t, err)
+	for _, line := range []string{"1234567\n", "abcdefg\n", "ABCDEFG\n", "last\n"} {
+		_, err = w.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	f, err := ioutil.ReadFile(logfile)
+	assert.NoError(t, err)
+	assert.Equal(t, "last\n", string(f))
+
+	archives, err := filepath.Glob(filepath.Join(dir, "telegraf.*.log"))
+	assert.NoError(t, err)
+	assert.Len(t, archives, 2)
+	sort.Strings(archives)
+	f, err = ioutil.ReadFile(archives[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdefg\n", string(f))
+}
+
+func TestRotateLogfileInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "telegraf.log")
+
+	w, err := newRotatingWriter(logfile, time.Millisecond, 0, -1)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("first\n"))
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = w.Write([]byte("second\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	f, err := ioutil.ReadFile(logfile)
+	assert.NoError(t, err)
+	assert.Equal(t, "second\n", string(f))
+	archives, err := filepath.Glob(filepath.Join(dir, "telegraf.*.log"))
+	assert.NoError(t, err)
+	assert.Len(t, archives, 1)
+}
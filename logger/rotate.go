@@ -0,0 +1,146 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveTimeFormat names the rotated logfiles, ie telegraf.log is rotated
+// to telegraf.20181105T100000.000000000.log, sorting them by age.
+const archiveTimeFormat = "20060102T150405.000000000"
+
+// rotatingWriter appends to a file, moving it aside to an archive once it
+// is too old or too large and keeping a limited number of archives.
+type rotatingWriter struct {
+	filename    string
+	interval    time.Duration
+	maxSize     int64
+	maxArchives int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newRotatingWriter(
+	filename string,
+	interval time.Duration,
+	maxSize int64,
+	maxArchives int,
+) (*rotatingWriter, error) {
+	w := &rotatingWriter{
+		filename:    filename,
+		interval:    interval,
+		maxSize:     maxSize,
+		maxArchives: maxArchives,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.opened = time.Now()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.rotationDue(int64(len(p))) {
+		// The logs keep going to the current file when it cannot rotate.
+		if err := w.rotate(); err != nil && w.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingWriter) rotationDue(n int64) bool {
+	if w.maxSize > 0 && w.size > 0 && w.size+n > w.maxSize {
+		return true
+	}
+	return w.interval > 0 && time.Since(w.opened) >= w.interval
+}
+
+// rotate moves the file to a new archive, opens a new file and removes the
+// archives over the limit.
+func (w *rotatingWriter) rotate() error {
+	ext := filepath.Ext(w.filename)
+	base := strings.TrimSuffix(w.filename, ext)
+	archive := base + "." + time.Now().UTC().Format(archiveTimeFormat) + ext
+	if err := os.Rename(w.filename, archive); err != nil {
+		return err
+	}
+
+	w.file.Close()
+	w.file = nil
+	if err := w.open(); err != nil {
+		return err
+	}
+	return w.removeArchives(base, ext)
+}
+
+// removeArchives removes the oldest archives of the file over the limit.
+func (w *rotatingWriter) removeArchives(base, ext string) error {
+	if w.maxArchives < 0 {
+		return nil
+	}
+
+	matches, err := filepath.Glob(base + ".*" + ext)
+	if err != nil {
+		return err
+	}
+	var archives []string
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(match, base+"."), ext)
+		if _, err := time.Parse(archiveTimeFormat, stamp); err == nil {
+			archives = append(archives, match)
+		}
+	}
+	sort.Strings(archives)
+
+	for len(archives) > w.maxArchives {
+		if err := os.Remove(archives[0]); err != nil {
+			return err
+		}
+		archives = archives[1:]
+	}
+	return nil
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
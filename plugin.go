@@ -0,0 +1,9 @@
+package telegraf
+
+// AliasedPlugin is a plugin naming its instance in its own log lines, ie
+// "[inputs.tableprov::primary]", so that they can be told apart.
+type AliasedPlugin interface {
+	// SetAlias sets the alias of the plugin instance once it is configured,
+	// the alias is empty when not set.
+	SetAlias(alias string)
+}
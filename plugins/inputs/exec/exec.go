@@ -3,6 +3,8 @@ package exec
 import (
 	"bytes"
 	"fmt"
+	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
@@ -28,9 +30,16 @@ const sampleConfig = `
     "/tmp/collect_*.sh"
   ]
 
-  ## Timeout for each command to complete.
+  ## Timeout for each command to complete, the command and the processes it
+  ## started are killed when it expires.
   timeout = "5s"
 
+  ## Environment variables of the commands, overriding the ones of telegraf.
+  # environment = ["LANG=C"]
+
+  ## Maximum number of commands run at the same time, 0 for no limit.
+  # concurrency = 0
+
   ## measurement name suffix (for separating different commands)
   name_suffix = "_mycollector"
 
@@ -44,9 +53,11 @@ const sampleConfig = `
 const MaxStderrBytes = 512
 
 type Exec struct {
-	Commands []string
-	Command  string
-	Timeout  internal.Duration
+	Commands    []string
+	Command     string
+	Timeout     internal.Duration
+	Environment []string
+	Concurrency int
 
 	parser parsers.Parser
 
@@ -60,8 +71,9 @@ func NewExec() *Exec {
 	}
 }
 
+// Runner runs a command, returning its stdout and stderr.
 type Runner interface {
-	Run(*Exec, string, telegraf.Accumulator) ([]byte, error)
+	Run(*Exec, string, telegraf.Accumulator) ([]byte, []byte, error)
 }
 
 type CommandRunner struct{}
@@ -90,13 +102,16 @@ func (c CommandRunner) Run(
 	e *Exec,
 	command string,
 	acc telegraf.Accumulator,
-) ([]byte, error) {
+) ([]byte, []byte, error) {
 	split_cmd, err := shellquote.Split(command)
 	if err != nil || len(split_cmd) == 0 {
-		return nil, fmt.Errorf("exec: unable to parse command, %s", err)
+		return nil, nil, fmt.Errorf("exec: unable to parse command, %s", err)
 	}
 
 	cmd := exec.Command(split_cmd[0], split_cmd[1:]...)
+	if len(e.Environment) > 0 {
+		cmd.Env = append(os.Environ(), e.Environment...)
+	}
 
 	var (
 		out    bytes.Buffer
@@ -105,44 +120,67 @@ func (c CommandRunner) Run(
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 
-	if err := internal.RunTimeout(cmd, e.Timeout.Duration); err != nil {
-		switch e.parser.(type) {
-		case *nagios.NagiosParser:
+	err = runTimeout(cmd, e.Timeout.Duration)
+	switch e.parser.(type) {
+	case *nagios.NagiosParser:
+		// The exit code of the nagios plugins is their state.
+		if err == nil || isExitError(err) {
 			AddNagiosState(err, acc)
-		default:
-			var errMessage = ""
-			if stderr.Len() > 0 {
-				stderr = removeCarriageReturns(stderr)
-				// Limit the number of bytes.
-				didTruncate := false
-				if stderr.Len() > MaxStderrBytes {
-					stderr.Truncate(MaxStderrBytes)
-					didTruncate = true
-				}
-				if i := bytes.IndexByte(stderr.Bytes(), '\n'); i > 0 {
-					// Only show truncation if the newline wasn't the last character.
-					if i < stderr.Len()-1 {
-						didTruncate = true
-					}
-					stderr.Truncate(i)
-				}
-				if didTruncate {
-					stderr.WriteString("...")
-				}
-
-				errMessage = fmt.Sprintf(": %s", stderr.String())
-			}
-			return nil, fmt.Errorf("exec: %s for command '%s'%s", err, command, errMessage)
-		}
-	} else {
-		switch e.parser.(type) {
-		case *nagios.NagiosParser:
-			AddNagiosState(nil, acc)
+			err = nil
 		}
 	}
 
 	out = removeCarriageReturns(out)
-	return out.Bytes(), nil
+	stderr = removeCarriageReturns(stderr)
+	return out.Bytes(), stderr.Bytes(), err
+}
+
+// runTimeout runs the command, killing its process group when the timeout
+// expires so that the processes it started do not outlive it.
+func runTimeout(cmd *exec.Cmd, timeout time.Duration) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		if err := killProcessGroup(cmd); err != nil {
+			log.Printf("E! [inputs.exec] killing command %s: %s", cmd.Path, err)
+		}
+	})
+
+	err := cmd.Wait()
+	if !timer.Stop() {
+		return internal.TimeoutErr
+	}
+	return err
+}
+
+func isExitError(err error) bool {
+	_, ok := err.(*exec.ExitError)
+	return ok
+}
+
+// truncate returns the first line of the stderr of a command, limited to
+// MaxStderrBytes.
+func truncate(stderr []byte) string {
+	buf := bytes.NewBuffer(stderr)
+	// Limit the number of bytes.
+	didTruncate := false
+	if buf.Len() > MaxStderrBytes {
+		buf.Truncate(MaxStderrBytes)
+		didTruncate = true
+	}
+	if i := bytes.IndexByte(buf.Bytes(), '\n'); i > 0 {
+		// Only show truncation if the newline wasn't the last character.
+		if i < buf.Len()-1 {
+			didTruncate = true
+		}
+		buf.Truncate(i)
+	}
+	if didTruncate {
+		buf.WriteString("...")
+	}
+	return buf.String()
 }
 
 // removeCarriageReturns removes all carriage returns from the input if the
@@ -174,11 +212,18 @@ func removeCarriageReturns(b bytes.Buffer) bytes.Buffer {
 func (e *Exec) ProcessCommand(command string, acc telegraf.Accumulator, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	out, err := e.runner.Run(e, command, acc)
+	out, stderr, err := e.runner.Run(e, command, acc)
 	if err != nil {
-		acc.AddError(err)
+		var errMessage = ""
+		if len(bytes.TrimSpace(stderr)) > 0 {
+			errMessage = fmt.Sprintf(": %s", truncate(stderr))
+		}
+		acc.AddError(fmt.Errorf("exec: %s for command '%s'%s", err, command, errMessage))
 		return
 	}
+	if len(bytes.TrimSpace(stderr)) > 0 {
+		acc.AddError(fmt.Errorf("exec: stderr of command '%s': %s", command, truncate(stderr)))
+	}
 
 	metrics, err := e.parser.Parse(out)
 	if err != nil {
@@ -241,9 +286,23 @@ func (e *Exec) Gather(acc telegraf.Accumulator) error {
 		}
 	}
 
+	// The slots of the commands running, when their number is limited.
+	var running chan struct{}
+	if e.Concurrency > 0 {
+		running = make(chan struct{}, e.Concurrency)
+	}
+
 	wg.Add(len(commands))
 	for _, command := range commands {
-		go e.ProcessCommand(command, acc, &wg)
+		if running == nil {
+			go e.ProcessCommand(command, acc, &wg)
+			continue
+		}
+		running <- struct{}{}
+		go func(command string) {
+			defer func() { <-running }()
+			e.ProcessCommand(command, acc, &wg)
+		}(command)
 	}
 	wg.Wait()
 	return nil
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/plugins/parsers"
 
 	"github.com/influxdata/telegraf/testutil"
@@ -74,28 +77,30 @@ var crTests = []CarriageReturnTest{
 }
 
 type runnerMock struct {
-	out []byte
-	err error
+	out    []byte
+	stderr []byte
+	err    error
 }
 
-func newRunnerMock(out []byte, err error) Runner {
+func newRunnerMock(out []byte, stderr []byte, err error) Runner {
 	return &runnerMock{
-		out: out,
-		err: err,
+		out:    out,
+		stderr: stderr,
+		err:    err,
 	}
 }
 
-func (r runnerMock) Run(e *Exec, command string, acc telegraf.Accumulator) ([]byte, error) {
+func (r runnerMock) Run(e *Exec, command string, acc telegraf.Accumulator) ([]byte, []byte, error) {
 	if r.err != nil {
-		return nil, r.err
+		return nil, r.stderr, r.err
 	}
-	return r.out, nil
+	return r.out, r.stderr, nil
 }
 
 func TestExec(t *testing.T) {
 	parser, _ := parsers.NewJSONParser("exec", []string{}, nil)
 	e := &Exec{
-		runner:   newRunnerMock([]byte(validJson), nil),
+		runner:   newRunnerMock([]byte(validJson), nil, nil),
 		Commands: []string{"testcommand arg1"},
 		parser:   parser,
 	}
@@ -121,7 +126,7 @@ func TestExec(t *testing.T) {
 func TestExecMalformed(t *testing.T) {
 	parser, _ := parsers.NewJSONParser("exec", []string{}, nil)
 	e := &Exec{
-		runner:   newRunnerMock([]byte(malformedJson), nil),
+		runner:   newRunnerMock([]byte(malformedJson), nil, nil),
 		Commands: []string{"badcommand arg1"},
 		parser:   parser,
 	}
@@ -134,16 +139,33 @@ func TestExecMalformed(t *testing.T) {
 func TestCommandError(t *testing.T) {
 	parser, _ := parsers.NewJSONParser("exec", []string{}, nil)
 	e := &Exec{
-		runner:   newRunnerMock(nil, fmt.Errorf("exit status code 1")),
+		runner:   newRunnerMock(nil, []byte("no such host\nretrying"), fmt.Errorf("exit status code 1")),
 		Commands: []string{"badcommand"},
 		parser:   parser,
 	}
 
 	var acc testutil.Accumulator
-	require.Error(t, acc.GatherError(e.Gather))
+	err := acc.GatherError(e.Gather)
+	require.Error(t, err)
+	assert.Equal(t, "exec: exit status code 1 for command 'badcommand': no such host...", err.Error())
 	assert.Equal(t, acc.NFields(), 0, "No new points should have been added")
 }
 
+func TestCommandStderr(t *testing.T) {
+	parser, _ := parsers.NewValueParser("metric", "integer", nil)
+	e := &Exec{
+		runner:   newRunnerMock([]byte("42\n"), []byte("deprecated option\n"), nil),
+		Commands: []string{"warncommand"},
+		parser:   parser,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, e.Gather(&acc))
+	require.Len(t, acc.Errors, 1)
+	assert.Equal(t, "exec: stderr of command 'warncommand': deprecated option", acc.Errors[0].Error())
+	acc.AssertContainsFields(t, "metric", map[string]interface{}{"value": int64(42)})
+}
+
 func TestExecCommandWithGlob(t *testing.T) {
 	parser, _ := parsers.NewValueParser("metric", "string", nil)
 	e := NewExec()
@@ -209,3 +231,75 @@ func TestRemoveCarriageReturns(t *testing.T) {
 		}
 	}
 }
+
+func TestExecEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on windows")
+	}
+	parser, _ := parsers.NewValueParser("metric", "string", nil)
+	e := NewExec()
+	e.Commands = []string{`sh -c "echo $EXEC_TEST_VALUE"`}
+	e.Environment = []string{"EXEC_TEST_VALUE=metric_value"}
+	e.SetParser(parser)
+
+	var acc testutil.Accumulator
+	require.NoError(t, acc.GatherError(e.Gather))
+	acc.AssertContainsFields(t, "metric", map[string]interface{}{"value": "metric_value"})
+}
+
+func TestExecTimeoutKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test on windows")
+	}
+	parser, _ := parsers.NewValueParser("metric", "string", nil)
+	e := NewExec()
+	// The background sleep holds the stdout of the command open, the gather
+	// only returns once it is killed too.
+	e.Commands = []string{`sh -c "sleep 10 & sleep 10"`}
+	e.Timeout = internal.Duration{Duration: 100 * time.Millisecond}
+	e.SetParser(parser)
+
+	var acc testutil.Accumulator
+	start := time.Now()
+	err := acc.GatherError(e.Gather)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Command timed out")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+type concurrencyRunner struct {
+	sync.Mutex
+	running, max int
+}
+
+func (r *concurrencyRunner) Run(e *Exec, command string, acc telegraf.Accumulator) ([]byte, []byte, error) {
+	r.Lock()
+	r.running++
+	if r.running > r.max {
+		r.max = r.running
+	}
+	r.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	r.Lock()
+	r.running--
+	r.Unlock()
+	return []byte("1"), nil, nil
+}
+
+func TestExecConcurrency(t *testing.T) {
+	parser, _ := parsers.NewValueParser("metric", "integer", nil)
+	runner := &concurrencyRunner{}
+	e := &Exec{
+		runner:      runner,
+		Commands:    []string{"a", "b", "c", "d", "e", "f"},
+		Concurrency: 2,
+		parser:      parser,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, acc.GatherError(e.Gather))
+	assert.Len(t, acc.Metrics, 6)
+	assert.Equal(t, 2, runner.max)
+}
@@ -0,0 +1,170 @@
+package file
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/globpath"
+	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/influxdata/telegraf/plugins/parsers"
+)
+
+const sampleConfig = `
+  ## Files to parse each interval.
+  ## These accept standard unix glob matching rules, but with the addition of
+  ## ** as a "super asterisk". ie:
+  ##   /var/run/**.status   -> recursively find all .status files in /var/run
+  ##   /var/run/*/*.status  -> find all .status files with a parent dir in /var/run
+  ##   /var/run/edge.status -> only read the edge status file
+  files = ["/var/run/edge.status"]
+
+  ## Only parse the files whose contents changed since they were last
+  ## parsed, the files are parsed once on start.
+  # only_on_change = false
+
+  ## Name of the tag holding the path of the file, no tag is added if empty.
+  # file_tag = "filename"
+
+  ## Data format to consume.
+  ## Each data format has its own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_INPUT.md
+  data_format = "influx"
+`
+
+// defaultFileTag is the tag holding the path of the file by default.
+const defaultFileTag = "filename"
+
+type File struct {
+	Files        []string `toml:"files"`
+	OnlyOnChange bool     `toml:"only_on_change"`
+	FileTag      string   `toml:"file_tag"`
+
+	parser parsers.Parser
+
+	// maps the patterns of the files to their compiled glob
+	globs map[string]*globpath.GlobPath
+	// maps the paths of the files parsed to their last version
+	parsed map[string]fileVersion
+}
+
+// fileVersion identifies a version of a file, the contents are only hashed
+// when the modification time or the size changed.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+func NewFile() *File {
+	return &File{
+		FileTag: defaultFileTag,
+		globs:   make(map[string]*globpath.GlobPath),
+		parsed:  make(map[string]fileVersion),
+	}
+}
+
+func (f *File) SampleConfig() string {
+	return sampleConfig
+}
+
+func (f *File) Description() string {
+	return "Parse the contents of files in any of the supported input data formats"
+}
+
+func (f *File) SetParser(parser parsers.Parser) {
+	f.parser = parser
+}
+
+func (f *File) Gather(acc telegraf.Accumulator) error {
+	matched := make(map[string]bool)
+	for _, pattern := range f.Files {
+		g, ok := f.globs[pattern]
+		if !ok {
+			var err error
+			if g, err = globpath.Compile(pattern); err != nil {
+				acc.AddError(fmt.Errorf("invalid file pattern %q: %v", pattern, err))
+				continue
+			}
+			f.globs[pattern] = g
+		}
+
+		for _, path := range g.Match() {
+			if matched[path] {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				acc.AddError(fmt.Errorf("%s: %v", path, err))
+				continue
+			}
+			if info.IsDir() {
+				continue
+			}
+			matched[path] = true
+			if err := f.readFile(acc, path, info); err != nil {
+				acc.AddError(fmt.Errorf("%s: %v", path, err))
+			}
+		}
+	}
+
+	// The files no longer matched are parsed again if they come back.
+	for path := range f.parsed {
+		if !matched[path] {
+			delete(f.parsed, path)
+		}
+	}
+	return nil
+}
+
+// readFile parses the file and adds its metrics, unless only the changes are
+// parsed and the file did not change.
+func (f *File) readFile(acc telegraf.Accumulator, path string, info os.FileInfo) error {
+	last, seen := f.parsed[path]
+	if f.OnlyOnChange && seen &&
+		info.ModTime().Equal(last.modTime) && info.Size() == last.size {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	version := fileVersion{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		hash:    sha256.Sum256(contents),
+	}
+	if f.OnlyOnChange && seen && version.hash == last.hash {
+		f.parsed[path] = version
+		return nil
+	}
+
+	metrics, err := f.parser.Parse(contents)
+	if err != nil {
+		// The file is parsed again on the next gather, it may have been
+		// read while being written.
+		delete(f.parsed, path)
+		return err
+	}
+	f.parsed[path] = version
+
+	for _, m := range metrics {
+		tags := m.Tags()
+		if f.FileTag != "" {
+			tags[f.FileTag] = path
+		}
+		acc.AddFields(m.Name(), m.Fields(), tags, m.Time())
+	}
+	return nil
+}
+
+func init() {
+	inputs.Add("file", func() telegraf.Input {
+		return NewFile()
+	})
+}
@@ -0,0 +1,117 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFile(t *testing.T, dataFormat string, files ...string) *File {
+	parser, err := parsers.NewParser(&parsers.Config{
+		DataFormat: dataFormat,
+		MetricName: "file",
+	})
+	require.NoError(t, err)
+
+	f := NewFile()
+	f.Files = files
+	f.SetParser(parser)
+	return f
+}
+
+func TestGather(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telegraf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	edge := filepath.Join(dir, "edge.status")
+	require.NoError(t, ioutil.WriteFile(edge, []byte("edge,role=cache up=1i\nedge,role=proxy up=0i\n"), 0644))
+	origin := filepath.Join(dir, "sub", "origin.status")
+	require.NoError(t, os.Mkdir(filepath.Dir(origin), 0755))
+	require.NoError(t, ioutil.WriteFile(origin, []byte("origin up=1i\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a metric"), 0644))
+
+	f := newTestFile(t, "influx", filepath.Join(dir, "**.status"), edge)
+	var acc testutil.Accumulator
+	require.NoError(t, acc.GatherError(f.Gather))
+
+	// The files matched by several patterns are read once.
+	assert.Len(t, acc.Metrics, 3)
+	assert.True(t, acc.HasPoint("edge",
+		map[string]string{"role": "cache", "filename": edge}, "up", int64(1)))
+	assert.True(t, acc.HasPoint("edge",
+		map[string]string{"role": "proxy", "filename": edge}, "up", int64(0)))
+	assert.True(t, acc.HasPoint("origin",
+		map[string]string{"filename": origin}, "up", int64(1)))
+
+	// The files are read on each gather by default.
+	acc.ClearMetrics()
+	require.NoError(t, acc.GatherError(f.Gather))
+	assert.Len(t, acc.Metrics, 3)
+}
+
+func TestGatherOnlyOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telegraf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "edge.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"up": 1}`), 0644))
+
+	f := newTestFile(t, "json", path)
+	f.OnlyOnChange = true
+	f.FileTag = "status_file"
+
+	var acc testutil.Accumulator
+	require.NoError(t, acc.GatherError(f.Gather))
+	assert.True(t, acc.HasPoint("file", map[string]string{"status_file": path}, "up", float64(1)))
+
+	// The file is touched without changing.
+	acc.ClearMetrics()
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	require.NoError(t, acc.GatherError(f.Gather))
+	assert.Len(t, acc.Metrics, 0)
+
+	// The file changed, with the same size.
+	acc.ClearMetrics()
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"up": 0}`), 0644))
+	require.NoError(t, os.Chtimes(path, later, later))
+	require.NoError(t, acc.GatherError(f.Gather))
+	assert.Len(t, acc.Metrics, 0)
+	require.NoError(t, os.Chtimes(path, later.Add(time.Minute), later.Add(time.Minute)))
+	require.NoError(t, acc.GatherError(f.Gather))
+	assert.True(t, acc.HasPoint("file", map[string]string{"status_file": path}, "up", float64(0)))
+
+	// A missing file is reported, and read again once it comes back.
+	acc.ClearMetrics()
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, f.Gather(&acc))
+	assert.Len(t, acc.Errors, 1)
+	acc.Errors = nil
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"up": 0}`), 0644))
+	require.NoError(t, acc.GatherError(f.Gather))
+	assert.Len(t, acc.Metrics, 1)
+}
+
+func TestGatherParseError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telegraf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bad := filepath.Join(dir, "bad.status")
+	require.NoError(t, ioutil.WriteFile(bad, []byte("not line protocol\n"), 0644))
+	good := filepath.Join(dir, "good.status")
+	require.NoError(t, ioutil.WriteFile(good, []byte("edge up=1i\n"), 0644))
+
+	f := newTestFile(t, "influx", filepath.Join(dir, "*.status"))
+	var acc testutil.Accumulator
+	require.NoError(t, f.Gather(&acc))
+	assert.Len(t, acc.Errors, 1)
+	assert.Contains(t, acc.Errors[0].Error(), bad)
+	assert.True(t, acc.HasPoint("edge", map[string]string{"filename": good}, "up", int64(1)))
+}
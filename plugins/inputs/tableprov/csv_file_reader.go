@@ -8,10 +8,10 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
 	ps "github.com/mitchellh/go-ps"
 )
 
@@ -19,14 +19,14 @@ const backupDirectory = "/usr/local/akamai/goblin_telegraf/tableprov/"
 const bakExt = ".valid"
 const tmpExt = ".invalid"
 
-// scanTableprovFile turns all of the data in the file into a metric.
-func (tp *Tableprov) scanTableprovFile(file string, acc telegraf.Accumulator, wg *sync.WaitGroup) {
-	defer wg.Done()
+// scanTableprovFile turns all of the data in the file into a metric. It
+// returns whether a snapshot of the table was published.
+func (tp *Tableprov) scanTableprovFile(file string, acc telegraf.TrackingAccumulator) bool {
 	tbl := tp.Tables[file]
 
 	// Check the process to see if it's running
 	if !tp.checkPIDFile(tbl.pidFile) {
-		return
+		return false
 	}
 
 	// Check the file to see if we should use a backup file
@@ -34,17 +34,23 @@ func (tp *Tableprov) scanTableprovFile(file string, acc telegraf.Accumulator, wg
 	tbl.usingbackup = usingbackup
 	if err != nil {
 		// We couldn't find any file to open and scan, not even a backup
-		log.Printf("[inputs.tableprov]: could not find tableprov table at %s", file)
+		log.Printf("W! [%s] could not find tableprov table at %s", tp.logName(), file)
 		tbl.errors++
 		tbl.valid = false
-		return
+		return false
 	}
 
 	// Optimization - If a table is unchanged, and we have previously
 	// found it invalid, we won't re-scan it since we wouldn't have sent it anyway.
 	if !changed && !tbl.valid {
-		log.Printf("[inputs.tableprov]: skip same invalid table: %s\n", tbl.name)
-		return
+		log.Printf("D! [%s] skip same invalid table: %s\n", tp.logName(), tbl.name)
+		return false
+	}
+
+	// Only publish a snapshot again when the table changed or the last one
+	// was not delivered.
+	if tp.skipPublish(tbl, changed) {
+		return false
 	}
 
 	// Assign the file we use to the variable tbpvFile
@@ -77,7 +83,7 @@ func (tp *Tableprov) scanTableprovFile(file string, acc telegraf.Accumulator, wg
 		acc.AddError(err)
 		tbl.errors++
 		tbl.valid = false
-		return
+		return false
 	}
 	defer f.Close()
 	var fileContents bytes.Buffer
@@ -87,37 +93,46 @@ func (tp *Tableprov) scanTableprovFile(file string, acc telegraf.Accumulator, wg
 		acc.AddError(err)
 		tbl.errors++
 		tbl.valid = false
-		return
+		return false
 	}
 
 	// Only validate changed files
 	if changed {
 		if err = tp.validate(fileContents, file, tbl); err != nil {
-			log.Printf("[inputs.tableprov]: checked tableprov table: %s - INVALID %s \n",
+			log.Printf("W! [%s] checked tableprov table: %s - INVALID %s \n", tp.logName(),
 				tbl.name, err.Error())
 			tbl.errors++
 			tbl.valid = false
-			return
+			return false
 		}
-		log.Printf("[inputs.tableprov]: checked tableprov table: %s - OK\n", tbl.name)
+		log.Printf("D! [%s] checked tableprov table: %s - OK\n", tp.logName(), tbl.name)
 		tbl.valid = true
 
 		// Move validated file to backup
 		os.Rename(tmp(file), bak(file))
 	}
 
-	// Send the metric
-	fields := make(map[string]interface{})
-	tags := make(map[string]string)
+	// Send the snapshot, one metric per chunk
+	var group []telegraf.Metric
 	timestamp := time.Now()
 	start := 0
 	for chunkNumber, end := range chunkBoundaries {
-		fields["tableprov"] = fileContents.String()[start:end]
-		tags["chunkNumber"] = strconv.Itoa(chunkNumber)
-		tags["isLast"] = strconv.FormatBool((end == fileContents.Len()))
-		acc.AddFields(tbl.name, fields, tags, timestamp)
+		fields := map[string]interface{}{
+			"tableprov": fileContents.String()[start:end],
+		}
+		tags := map[string]string{
+			"chunkNumber": strconv.Itoa(chunkNumber),
+			"isLast":      strconv.FormatBool((end == fileContents.Len())),
+		}
+		m, err := metric.New(tbl.name, tags, fields, timestamp)
+		if err != nil {
+			acc.AddError(err)
+			return false
+		}
+		group = append(group, m)
 		start = end
 	}
+	return tp.publish(acc, tbl, group)
 }
 
 // checkPIDFile will check a PID file for the process id associated with the table.
@@ -133,30 +148,30 @@ func (tp *Tableprov) checkPIDFile(PIDfile string) bool {
 		pidTxt := scanner.Text()
 		f.Close()
 		if err := scanner.Err(); err != nil {
-			log.Printf("[inputs.tableprov]: %v", err)
+			log.Printf("E! [%s] %v", tp.logName(), err)
 			return false
 		}
 		pidData := strings.Split(pidTxt, ",")
 		if len(pidData) == 1 {
 			pid, err := strconv.Atoi(pidData[0])
 			if err != nil {
-				log.Printf("[inputs.tableprov]: %v", err)
+				log.Printf("E! [%s] %v", tp.logName(), err)
 				return false
 			}
 			p, err := ps.FindProcess(pid)
 			if p == nil || err != nil {
-				log.Printf("[inputs.tableprov]: %s - No process found with PID %d", PIDfile, pid)
+				log.Printf("W! [%s] %s - No process found with PID %d", tp.logName(), PIDfile, pid)
 				return false
 			}
 		} else if len(pidData) == 2 {
 			pid, err := strconv.Atoi(pidData[0])
 			if err != nil {
-				log.Printf("[inputs.tableprov]: %v", err)
+				log.Printf("E! [%s] %v", tp.logName(), err)
 				return false
 			}
 			p, err := ps.FindProcess(pid)
 			if p == nil || err != nil || p.Executable() != pidData[1] {
-				log.Printf("[inputs.tableprov]: No process found with PID %d and name %s", pid, pidData[1])
+				log.Printf("W! [%s] No process found with PID %d and name %s", tp.logName(), pid, pidData[1])
 				return false
 			}
 		}
@@ -8,6 +8,8 @@
 //                              and exposed to the rest of Telegraf.
 //        tableprov_config.go   contains the code for reading in the tableprov
 //                              config file.
+//        tableprov_delivery.go contains the code for tracking the delivery of
+//                              table snapshots to the outputs.
 //        file_reader.go        contains the code for reading in and chunking
 //                              large tableprov CSV files.
 //        validator.go          contains the code for validating tableprov CSV files
@@ -17,10 +19,12 @@
 package tableprov
 
 import (
+	"context"
 	"errors"
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
@@ -30,16 +34,32 @@ import (
 
 // Tableprov is the parent struct for both Tableprov and Tableprov2
 type Tableprov struct {
-	Config         string
-	MaxMetricBytes int
-	parser         parsers.Parser
+	Config                  string
+	MaxMetricBytes          int
+	MaxUndeliveredSnapshots int `toml:"max_undelivered_snapshots"`
+	parser                  parsers.Parser
 
-	HostIP  string
-	Tables  map[string]*TblInfo
-	Indices map[string]*TblInfo
+	HostIP  string              `toml:"-"`
+	Tables  map[string]*TblInfo `toml:"-"`
+	Indices map[string]*TblInfo `toml:"-"`
+
+	// gatherMu serializes the Gathers, an abandoned Gather may still run
+	// when the next one starts.
+	gatherMu sync.Mutex
+
+	mu          sync.Mutex
+	undelivered map[telegraf.TrackingID]*TblInfo
+	inflight    int // snapshots published and not yet reported
+	stopped     bool
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+
+	alias string
 }
 
 const defaultTableChunkSize = 900000
+const defaultMaxUndeliveredSnapshots = 1000
 
 // SampleConfig describes the expected configuration parameters
 func (tp *Tableprov) SampleConfig() string {
@@ -47,6 +67,10 @@ func (tp *Tableprov) SampleConfig() string {
 	## Tableprov Config filepath
 	config = "/usr/local/akamai/etc/staticinfo/tableprov.conf"
 	max_metric_bytes = 900000
+
+	## Maximum number of table snapshots waiting to be delivered by the
+	## outputs. A snapshot is published again until it is delivered.
+	# max_undelivered_snapshots = 1000
 	`
 }
 
@@ -55,12 +79,28 @@ func (tp *Tableprov) Description() string {
 	return "Convert Tableprov csv files into metrics"
 }
 
+// SetAlias sets the alias of the instance, naming it in the logs
+func (tp *Tableprov) SetAlias(alias string) {
+	tp.alias = alias
+}
+
+// logName returns the name of the instance in the logs
+func (tp *Tableprov) logName() string {
+	if tp.alias == "" {
+		return "inputs.tableprov"
+	}
+	return "inputs.tableprov::" + tp.alias
+}
+
 // Start sets up the list of Tableprov csv files to monitor
 func (tp *Tableprov) Start(acc telegraf.Accumulator) error {
 	// Set default MaxMetricBytes
 	if tp.MaxMetricBytes == 0 {
 		tp.MaxMetricBytes = defaultTableChunkSize
 	}
+	if tp.MaxUndeliveredSnapshots <= 0 {
+		tp.MaxUndeliveredSnapshots = defaultMaxUndeliveredSnapshots
+	}
 	// Get IP address
 	tp.HostIP = utils.GetIP()
 	if tp.HostIP == "" {
@@ -75,34 +115,64 @@ func (tp *Tableprov) Start(acc telegraf.Accumulator) error {
 		return errors.New("[inputs.tableprov]: unable to create backup directory")
 	}
 
+	// Track the delivery of the table snapshots
+	tp.undelivered = make(map[telegraf.TrackingID]*TblInfo)
+	tp.inflight = 0
+	tp.stopped = false
+	tp.ctx, tp.cancel = context.WithCancel(context.Background())
+
 	return nil
 }
 
 // Gather runs once every interval, but the plugin will only pick up files
-// that have been modified.
+// that have been modified. It returns once every file was scanned, so that the
+// agent knows when the cycle ended. The snapshots are published through the
+// accumulator of the Gather, so that an abandoned Gather no longer publishes,
+// as tracked groups whose delivery is received in the background.
 func (tp *Tableprov) Gather(acc telegraf.Accumulator) error {
+	tp.gatherMu.Lock()
+	defer tp.gatherMu.Unlock()
+
 	tp.updateConfig(acc)
 
+	tacc := acc.WithTracking(tp.MaxUndeliveredSnapshots)
+	var published int32
 	var wg sync.WaitGroup
 	wg.Add(len(tp.Tables))
-	log.Printf("[inputs.tableprov]: Starting Cycle\n")
+	log.Printf("D! [%s] Starting Cycle\n", tp.logName())
 	for file := range tp.Tables {
-		go tp.scanTableprovFile(file, acc, &wg)
+		go func(file string) {
+			defer wg.Done()
+			if tp.scanTableprovFile(file, tacc) {
+				atomic.AddInt32(&published, 1)
+			}
+		}(file)
 	}
-	go func() {
-		wg.Wait()
-		tp.createTableprovTablesMetric(acc)
-	}()
+	wg.Wait()
+	tp.receiveDeliveries(tacc, int(published))
+	tp.createTableprovTablesMetric(acc)
 	return nil
 }
 
-// Stop is a noop, but required for the ServiceInput interface
+// Stop stops publishing snapshots and tracking the delivery of the published
+// ones. The scans of an abandoned Gather no longer publish.
 func (tp *Tableprov) Stop() {
+	tp.mu.Lock()
+	tp.stopped = true
+	tp.mu.Unlock()
+
+	if tp.cancel != nil {
+		tp.cancel()
+	}
+	tp.wg.Wait()
 }
 
 // init initializes the package.
 func init() {
 	inputs.Add("tableprov", func() telegraf.Input {
-		return &Tableprov{}
+		return &Tableprov{
+			MaxMetricBytes:          defaultTableChunkSize,
+			MaxUndeliveredSnapshots: defaultMaxUndeliveredSnapshots,
+		}
 	})
 }
@@ -60,14 +60,14 @@ func (tp *Tableprov) updateConfig(acc telegraf.Accumulator) {
 		if _, ok := tp.Indices[file]; !ok {
 			// Register a new index
 			tp.Indices[file] = newIdx
-			log.Printf("[inputs.tableprov]: Registered a new index (%s)", newIdx.name)
+			log.Printf("I! [%s] Registered a new index (%s)", tp.logName(), newIdx.name)
 		}
 	}
 	for file, oldIdx := range tp.Indices {
 		if _, ok := newIndices[file]; !ok {
 			// Remove a deleted index
 			delete(tp.Indices, file)
-			log.Printf("[inputs.tableprov]: Deleted index (%s)", oldIdx.name)
+			log.Printf("I! [%s] Deleted index (%s)", tp.logName(), oldIdx.name)
 		}
 	}
 
@@ -76,14 +76,14 @@ func (tp *Tableprov) updateConfig(acc telegraf.Accumulator) {
 		if _, ok := tp.Tables[file]; !ok {
 			// Register a new table
 			tp.Tables[file] = newTable
-			log.Printf("[inputs.tableprov]: Registered a new table: %s", newTable.name)
+			log.Printf("I! [%s] Registered a new table: %s", tp.logName(), newTable.name)
 		}
 	}
 	for file, oldTable := range tp.Tables {
 		if _, ok := newTables[file]; !ok {
 			// Remove a deleted table
 			delete(tp.Tables, file)
-			log.Printf("[inputs.tableprov]: Deleted table: %s", oldTable.name)
+			log.Printf("I! [%s] Deleted table: %s", tp.logName(), oldTable.name)
 		}
 	}
 }
@@ -110,13 +110,13 @@ func (tp *Tableprov) populateTablesFrom(indexName string, indexPath string, dir
 				version: "", timestamp: minTime, csvfilefmt: 0, valid: true,
 			}
 		}
-		log.Printf("[inputs.tableprov]: could not find index file at %s\n", indexPath)
+		log.Printf("W! [%s] could not find index file at %s\n", tp.logName(), indexPath)
 		return
 	}
 	defer indexFile.Close()
 	fileInfo, err := os.Stat(indexPath)
 	if err != nil {
-		log.Printf("[inputs.tableprov]: could not stat index file at %s\n", indexPath)
+		log.Printf("W! [%s] could not stat index file at %s\n", tp.logName(), indexPath)
 		return
 	}
 
@@ -145,7 +145,7 @@ func (tp *Tableprov) populateTablesFrom(indexName string, indexPath string, dir
 			tableFile = tableInfo[1]
 			pidFile = tableInfo[2]
 		} else {
-			log.Printf("[inputs.tableprov]: Invalid index file %s\n", indexPath)
+			log.Printf("W! [%s] Invalid index file %s\n", tp.logName(), indexPath)
 			return
 		}
 		tables[dir+"/"+basename(tableFile)+".csv"] = &TblInfo{
@@ -0,0 +1,102 @@
+package tableprov
+
+import (
+	"log"
+
+	"github.com/influxdata/telegraf"
+)
+
+// publish sends the chunks of a table snapshot to the tracking accumulator of
+// the gather as a group. The table is pending until the outputs report the
+// delivery of the group. It returns false when the snapshot is not published,
+// the table is then published again on the next scan.
+func (tp *Tableprov) publish(acc telegraf.TrackingAccumulator, tbl *TblInfo, group []telegraf.Metric) bool {
+	tp.mu.Lock()
+	if tp.stopped {
+		tp.mu.Unlock()
+		return false
+	}
+	// The table may have changed since its last delivered snapshot, it must
+	// not be taken as published while delayed.
+	tbl.published = false
+	if tp.inflight >= tp.MaxUndeliveredSnapshots {
+		tp.mu.Unlock()
+		log.Printf("W! [%s] too many undelivered snapshots, delaying table: %s", tp.logName(), tbl.name)
+		return false
+	}
+	tp.inflight++
+	tbl.pending = true
+	tp.mu.Unlock()
+
+	// The lock is not held while the accumulator blocks. The delivery
+	// reports of the gather are only received once all its snapshots are
+	// published, see receiveDeliveries.
+	id := acc.AddTrackingMetricGroup(group)
+
+	tp.mu.Lock()
+	tp.undelivered[id] = tbl
+	tp.mu.Unlock()
+	return true
+}
+
+// receiveDeliveries handles the delivery reports of the n snapshots published
+// through the tracking accumulator of a gather, in the background until they
+// are all received or the plugin is stopped. The accumulator has room for the
+// reports of all of them, as at most MaxUndeliveredSnapshots are in flight.
+func (tp *Tableprov) receiveDeliveries(acc telegraf.TrackingAccumulator, n int) {
+	if n == 0 {
+		return
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if tp.stopped {
+		return
+	}
+	ctx := tp.ctx
+	tp.wg.Add(1)
+	go func() {
+		defer tp.wg.Done()
+		for ; n > 0; n-- {
+			select {
+			case <-ctx.Done():
+				return
+			case info := <-acc.Delivered():
+				tp.onDelivery(info)
+			}
+		}
+	}()
+}
+
+// onDelivery marks the table of a snapshot as published once every chunk was
+// written by the outputs. An undelivered snapshot is published again on the
+// next interval.
+func (tp *Tableprov) onDelivery(info telegraf.DeliveryInfo) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	tbl, ok := tp.undelivered[info.ID()]
+	if !ok {
+		return
+	}
+	delete(tp.undelivered, info.ID())
+	tp.inflight--
+
+	tbl.pending = false
+	tbl.published = info.Delivered()
+	if !tbl.published {
+		log.Printf("W! [%s] snapshot of table %s was not delivered, retrying", tp.logName(), tbl.name)
+	}
+}
+
+// skipPublish reports whether the table is left alone this interval, because
+// its last snapshot is still pending or was delivered and nothing changed.
+func (tp *Tableprov) skipPublish(tbl *TblInfo, changed bool) bool {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	if tbl.pending {
+		return true
+	}
+	return tbl.published && !changed
+}
@@ -2,8 +2,14 @@ package tableprov
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/testutil"
 )
 
 // TestValidateTableprovFile ensures that we only accept files that tableprov would accept,
@@ -112,3 +118,203 @@ func TestCheckNames(t *testing.T) {
 		}
 	}
 }
+
+type deliveryInfo struct {
+	id        telegraf.TrackingID
+	delivered bool
+}
+
+func (d *deliveryInfo) ID() telegraf.TrackingID {
+	return d.id
+}
+
+func (d *deliveryInfo) Delivered() bool {
+	return d.delivered
+}
+
+// trackingAccumulator keeps the metrics added, to acknowledge them, and
+// reports the delivery of the tracked groups as the agent does.
+type trackingAccumulator struct {
+	testutil.Accumulator
+	metrics   []telegraf.Metric
+	delivered chan telegraf.DeliveryInfo
+}
+
+func newTrackingAccumulator() *trackingAccumulator {
+	return &trackingAccumulator{delivered: make(chan telegraf.DeliveryInfo, 10)}
+}
+
+func (a *trackingAccumulator) AddMetric(m telegraf.Metric) {
+	a.metrics = append(a.metrics, m)
+}
+
+func (a *trackingAccumulator) AddTrackingMetricGroup(group []telegraf.Metric) telegraf.TrackingID {
+	group, id := metric.WithGroupTracking(group, func(info telegraf.DeliveryInfo) {
+		a.delivered <- info
+	})
+	for _, m := range group {
+		a.AddMetric(m)
+	}
+	return id
+}
+
+func (a *trackingAccumulator) Delivered() <-chan telegraf.DeliveryInfo {
+	return a.delivered
+}
+
+func newDeliveryTableprov(maxUndelivered int) *Tableprov {
+	tp := &Tableprov{
+		MaxUndeliveredSnapshots: maxUndelivered,
+		undelivered:             make(map[telegraf.TrackingID]*TblInfo),
+	}
+	tp.ctx, tp.cancel = context.WithCancel(context.Background())
+	return tp
+}
+
+// publishSnapshot publishes a one chunk snapshot of the table and returns its
+// tracking id.
+func publishSnapshot(t *testing.T, tp *Tableprov, tbl *TblInfo) telegraf.TrackingID {
+	m, err := metric.New(tbl.name,
+		map[string]string{"chunkNumber": "0", "isLast": "true"},
+		map[string]interface{}{"tableprov": "v1\n"},
+		time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tp.publish(&testutil.Accumulator{}, tbl, []telegraf.Metric{m}) {
+		t.Fatalf("snapshot of %s was not published", tbl.name)
+	}
+	for id, pending := range tp.undelivered {
+		if pending == tbl {
+			return id
+		}
+	}
+	t.Fatalf("snapshot of %s is not tracked", tbl.name)
+	return 0
+}
+
+// TestSnapshotDelivery ensures that a table is only published once its
+// snapshot is delivered, and that undelivered snapshots are retried
+func TestSnapshotDelivery(t *testing.T) {
+	tp := newDeliveryTableprov(10)
+	tbl := &TblInfo{name: "table"}
+
+	id := publishSnapshot(t, tp, tbl)
+	if !tp.skipPublish(tbl, true) {
+		t.Errorf("pending table was published again")
+	}
+
+	tp.onDelivery(&deliveryInfo{id: id, delivered: false})
+	if tbl.published || tbl.pending {
+		t.Errorf("undelivered table => published %v pending %v", tbl.published, tbl.pending)
+	}
+	if tp.skipPublish(tbl, false) {
+		t.Errorf("undelivered snapshot was not retried")
+	}
+
+	id = publishSnapshot(t, tp, tbl)
+	tp.onDelivery(&deliveryInfo{id: id, delivered: true})
+	if !tbl.published || tbl.pending {
+		t.Errorf("delivered table => published %v pending %v", tbl.published, tbl.pending)
+	}
+	if !tp.skipPublish(tbl, false) {
+		t.Errorf("unchanged delivered table was published again")
+	}
+	if tp.skipPublish(tbl, true) {
+		t.Errorf("changed table was not published")
+	}
+	if len(tp.undelivered) != 0 || tp.inflight != 0 {
+		t.Errorf("%d snapshots still tracked, %d in flight, wanted 0",
+			len(tp.undelivered), tp.inflight)
+	}
+}
+
+// TestSnapshotDelayed ensures that a changed table, whose previous snapshot
+// was delivered, is published again on the next scan when it could not be
+// published because of too many undelivered snapshots
+func TestSnapshotDelayed(t *testing.T) {
+	tp := newDeliveryTableprov(1)
+	changed := &TblInfo{name: "changed"}
+	tp.onDelivery(&deliveryInfo{id: publishSnapshot(t, tp, changed), delivered: true})
+	if !changed.published {
+		t.Fatalf("snapshot of %s was not delivered", changed.name)
+	}
+
+	// Another snapshot fills the cap while the table changes.
+	other := &TblInfo{name: "other"}
+	id := publishSnapshot(t, tp, other)
+	if tp.publish(&testutil.Accumulator{}, changed, []telegraf.Metric{testutil.TestMetric(1)}) {
+		t.Fatalf("snapshot published over the cap")
+	}
+
+	// The next scan sees the table unchanged, it must publish it anyway.
+	if tp.skipPublish(changed, false) {
+		t.Errorf("delayed table is not published again")
+	}
+	tp.onDelivery(&deliveryInfo{id: id, delivered: true})
+	publishSnapshot(t, tp, changed)
+}
+
+// TestNoPublishAfterStop ensures that the scans of an abandoned Gather do not
+// publish once the plugin is stopped
+func TestNoPublishAfterStop(t *testing.T) {
+	acc := &testutil.Accumulator{}
+	tp := newDeliveryTableprov(10)
+	tp.Stop()
+
+	tbl := &TblInfo{name: "table"}
+	if tp.publish(acc, tbl, []telegraf.Metric{testutil.TestMetric(1)}) {
+		t.Errorf("snapshot published after stop")
+	}
+	if acc.NMetrics() != 0 {
+		t.Errorf("published %d metrics after stop, wanted 0", acc.NMetrics())
+	}
+	if tbl.pending {
+		t.Errorf("table is pending after stop")
+	}
+}
+
+// TestPublishDeliveryReport ensures that the snapshots are published through
+// the tracking accumulator given, and that their delivery is received once
+// all their chunks are acknowledged
+func TestPublishDeliveryReport(t *testing.T) {
+	tp := newDeliveryTableprov(10)
+	defer tp.Stop()
+	tbl := &TblInfo{name: "table"}
+
+	acc := newTrackingAccumulator()
+	tp.publish(acc, tbl, []telegraf.Metric{testutil.TestMetric(1), testutil.TestMetric(2)})
+	if len(acc.metrics) != 2 {
+		t.Fatalf("published %d metrics, wanted 2", len(acc.metrics))
+	}
+	tp.receiveDeliveries(acc, 1)
+
+	// An abandoned Gather rejects its metrics
+	acc.metrics[0].Accept()
+	acc.metrics[1].Reject()
+	tp.wg.Wait()
+	if tbl.published || tbl.pending {
+		t.Errorf("rejected table => published %v pending %v", tbl.published, tbl.pending)
+	}
+
+	acc = newTrackingAccumulator()
+	tp.publish(acc, tbl, []telegraf.Metric{testutil.TestMetric(1)})
+	acc.metrics[0].Accept()
+	tp.receiveDeliveries(acc, 1)
+	tp.wg.Wait()
+	if !tbl.published || tbl.pending {
+		t.Errorf("delivered table => published %v pending %v", tbl.published, tbl.pending)
+	}
+}
+
+// TestLogName ensures that the log lines name the instance by its alias
+func TestLogName(t *testing.T) {
+	tp := &Tableprov{}
+	if name := tp.logName(); name != "inputs.tableprov" {
+		t.Errorf("log name without alias => %s", name)
+	}
+	tp.SetAlias("primary")
+	if name := tp.logName(); name != "inputs.tableprov::primary" {
+		t.Errorf("log name with alias => %s", name)
+	}
+}
@@ -30,6 +30,8 @@ type TblInfo struct {
 	pidFile     string
 	csvfilefmt  int
 	valid       bool
+	pending     bool // a snapshot is waiting to be delivered
+	published   bool // the last snapshot was delivered
 }
 
 // createTableprovTablesMetric makes a summary of all the tableprov tables processed
@@ -56,6 +56,7 @@ const (
 	defaultClientTimeout = 5 * time.Second
 	defaultContentType   = "text/plain; charset=utf-8"
 	defaultMethod        = http.MethodPost
+	defaultMaxBytes      = 1000000
 )
 
 type HTTP struct {
@@ -74,6 +75,20 @@ type HTTP struct {
 
 	client     *http.Client
 	serializer serializers.Serializer
+	alias      string
+}
+
+// SetAlias sets the alias of the instance, naming it in the logs.
+func (h *HTTP) SetAlias(alias string) {
+	h.alias = alias
+}
+
+// logName returns the name of the instance in the logs.
+func (h *HTTP) logName() string {
+	if h.alias == "" {
+		return "outputs.akamill"
+	}
+	return "outputs.akamill::" + h.alias
 }
 
 func (h *HTTP) SetSerializer(serializer serializers.Serializer) {
@@ -88,7 +103,7 @@ func (h *HTTP) Connect() error {
 		InsecureSkipVerify: h.InsecureSkipVerify,
 	}
 	if h.MaxBytes <= 0 {
-		h.MaxBytes = 1000000
+		h.MaxBytes = defaultMaxBytes
 	}
 	if h.Method == "" {
 		h.Method = http.MethodPost
@@ -130,42 +145,70 @@ func (h *HTTP) SampleConfig() string {
 	return sampleConfig
 }
 
+// Write sends the metrics in post messages of at most MaxBytes. When only
+// some of the posts fail, the metrics of the failed posts are reported in a
+// PartialWriteError so that the others are not sent again. The metrics which
+// cannot be serialized are reported dropped, they would never be written.
 func (h *HTTP) Write(metrics []telegraf.Metric) error {
 	start := time.Now()
 	var bytesWritten int
 	var numPostMessages int
 	var reqBody bytes.Buffer
 
-	for _, m := range metrics {
-		body, err := h.serializer.Serialize(m)
-		if reqBody.Len()+len(body) > h.MaxBytes {
-			if err := h.write(reqBody.Bytes()); err != nil {
-				log.Printf("[outputs.akamill]: %v", err)
-			}
-			numPostMessages++
+	// Index of the metrics in the current post message
+	var posted []int
+	var rejected []int
+	var dropped []int
+	var lastErr error
+	post := func() {
+		if reqBody.Len() > h.MaxBytes {
+			log.Printf("W! [%s] an input plugin is creating metrics larger than %d bytes", h.logName(), h.MaxBytes)
+		}
+		if err := h.write(reqBody.Bytes()); err != nil {
+			log.Printf("E! [%s] %v", h.logName(), err)
+			rejected = append(rejected, posted...)
+			lastErr = err
+		} else {
 			bytesWritten += reqBody.Len()
-			reqBody.Reset()
 		}
-		_, err = reqBody.Write(body)
+		numPostMessages++
+		reqBody.Reset()
+		posted = posted[:0]
+	}
+
+	for i, m := range metrics {
+		body, err := h.serializer.Serialize(m)
 		if err != nil {
-			log.Printf("[outputs.akamill]: %v", err)
+			log.Printf("E! [%s] could not serialize metric %s: %v", h.logName(), m.Name(), err)
+			dropped = append(dropped, i)
+			lastErr = fmt.Errorf("could not serialize metric %s: %v", m.Name(), err)
+			continue
+		}
+		if reqBody.Len() > 0 && reqBody.Len()+len(body) > h.MaxBytes {
+			post()
 		}
+		reqBody.Write(body)
+		posted = append(posted, i)
 	}
 	if reqBody.Len() > 0 {
-		if reqBody.Len() > h.MaxBytes {
-			log.Printf("[outputs.akamill]: WARNING! an input plugin is creating metrics larger than %d bytes", h.MaxBytes)
-		}
-		if err := h.write(reqBody.Bytes()); err != nil {
-			log.Printf("[outputs.akamill]: %v", err)
-		}
-		numPostMessages++
-		bytesWritten += reqBody.Len()
+		post()
 	}
 
 	elapsed := time.Since(start)
-	log.Printf("[outputs.akamill]: %d bytes written in %d post messages in %s \n", bytesWritten, numPostMessages, elapsed)
-
-	return nil
+	log.Printf("D! [%s] %d bytes written in %d post messages in %s \n", h.logName(), bytesWritten, numPostMessages, elapsed)
+
+	switch {
+	case len(rejected) == 0 && len(dropped) == 0:
+		return nil
+	case len(rejected) == len(metrics):
+		return lastErr
+	default:
+		return &internal.PartialWriteError{
+			Err:           lastErr,
+			MetricsReject: rejected,
+			MetricsDrop:   dropped,
+		}
+	}
 }
 
 func (h *HTTP) write(reqBody []byte) error {
@@ -196,8 +239,9 @@ func (h *HTTP) write(reqBody []byte) error {
 func init() {
 	outputs.Add("akamill", func() telegraf.Output {
 		return &HTTP{
-			Timeout: internal.Duration{Duration: defaultClientTimeout},
-			Method:  defaultMethod,
+			Timeout:  internal.Duration{Duration: defaultClientTimeout},
+			Method:   defaultMethod,
+			MaxBytes: defaultMaxBytes,
 		}
 	})
 }
@@ -1,15 +1,21 @@
 package akamill
 
 import (
+	"bytes"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/plugins/serializers"
 	"github.com/influxdata/telegraf/plugins/serializers/influx"
 	"github.com/stretchr/testify/require"
 )
@@ -289,3 +295,112 @@ func TestBasicAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestPartialWrite(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	u, err := url.Parse(fmt.Sprintf("http://%s", ts.Listener.Addr().String()))
+	require.NoError(t, err)
+
+	// Each metric is sent in its own post message, the second one fails.
+	var posts int
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		if posts == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	plugin := &HTTP{
+		URL:      u.String(),
+		MaxBytes: 10,
+	}
+	plugin.SetSerializer(influx.NewSerializer())
+	require.NoError(t, plugin.Connect())
+
+	err = plugin.Write([]telegraf.Metric{getMetric(), getMetric(), getMetric()})
+	require.Equal(t, 3, posts)
+	perr, ok := err.(*internal.PartialWriteError)
+	require.True(t, ok)
+	require.Equal(t, []int{1}, perr.MetricsReject)
+
+	// Nothing is written when all post messages fail.
+	ts.Config.Handler = http.NotFoundHandler()
+	err = plugin.Write([]telegraf.Metric{getMetric(), getMetric()})
+	require.Error(t, err)
+	_, ok = err.(*internal.PartialWriteError)
+	require.False(t, ok)
+}
+
+// failingSerializer fails to serialize the metrics named "invalid".
+type failingSerializer struct {
+	serializers.Serializer
+}
+
+func (s *failingSerializer) Serialize(m telegraf.Metric) ([]byte, error) {
+	if m.Name() == "invalid" {
+		return nil, fmt.Errorf("invalid metric")
+	}
+	return s.Serializer.Serialize(m)
+}
+
+func TestSerializeError(t *testing.T) {
+	var posts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	plugin := &HTTP{
+		URL:      ts.URL,
+		MaxBytes: defaultMaxBytes,
+	}
+	plugin.SetSerializer(&failingSerializer{influx.NewSerializer()})
+	require.NoError(t, plugin.Connect())
+
+	invalid, err := metric.New("invalid", nil, map[string]interface{}{"value": 1.0}, time.Unix(0, 0))
+	require.NoError(t, err)
+
+	// The metrics which cannot be serialized are dropped, the others are
+	// written.
+	err = plugin.Write([]telegraf.Metric{getMetric(), invalid, getMetric()})
+	require.Equal(t, 1, posts)
+	perr, ok := err.(*internal.PartialWriteError)
+	require.True(t, ok)
+	require.Empty(t, perr.MetricsReject)
+	require.Equal(t, []int{1}, perr.MetricsDrop)
+
+	// Nothing is posted when no metric can be serialized, which is not a
+	// success.
+	err = plugin.Write([]telegraf.Metric{invalid})
+	require.Equal(t, 1, posts)
+	perr, ok = err.(*internal.PartialWriteError)
+	require.True(t, ok)
+	require.Equal(t, []int{0}, perr.MetricsDrop)
+}
+
+func TestOversizedPostWarning(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	plugin := &HTTP{
+		URL:      ts.URL,
+		MaxBytes: 10,
+	}
+	plugin.SetSerializer(influx.NewSerializer())
+	require.NoError(t, plugin.Connect())
+
+	// Every post message is larger than MaxBytes, not only the last one.
+	require.NoError(t, plugin.Write([]telegraf.Metric{getMetric(), getMetric()}))
+	require.Equal(t, 2, strings.Count(buf.String(), "creating metrics larger than 10 bytes"))
+}
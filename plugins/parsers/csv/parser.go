@@ -0,0 +1,315 @@
+package csv
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// Config configures the parsing of csv data.
+type Config struct {
+	// MetricName is the name of the metrics, unless read from the
+	// MeasurementColumn.
+	MetricName string
+	// ColumnNames are the names of the columns, read from the header rows
+	// when empty.
+	ColumnNames []string
+	// ColumnTypes are the types of the columns, by position: int, float,
+	// bool or string. The types of the other columns are guessed from their
+	// values.
+	ColumnTypes []string
+	// Comment is the character starting the comment lines.
+	Comment string
+	// Delimiter is the character separating the columns, a comma by
+	// default.
+	Delimiter string
+	// HeaderRowCount is the number of header rows, their values are joined
+	// to name the columns. When zero, the first row is the header unless
+	// the ColumnNames are given.
+	HeaderRowCount int
+	// MeasurementColumn is the column holding the name of the metrics.
+	MeasurementColumn string
+	// SkipColumns is the number of columns to skip at the start of the rows.
+	SkipColumns int
+	// SkipRows is the number of rows to skip before the header rows.
+	SkipRows int
+	// TagColumns are the columns added as tags.
+	TagColumns []string
+	// TimestampColumn is the column holding the time of the metrics, in the
+	// TimestampFormat: unix, unix_ms, unix_us, unix_ns or a Go reference time
+	// layout. The metrics are timestamped when parsed by default.
+	TimestampColumn string
+	TimestampFormat string
+	// TrimSpace trims the leading spaces of the values.
+	TrimSpace bool
+
+	DefaultTags map[string]string
+}
+
+// Parser parses csv data, each row being a metric with a field for each of
+// its columns, except for the tag, measurement and timestamp columns.
+type Parser struct {
+	Config
+
+	delimiter rune
+	comment   rune
+	timeFunc  func() time.Time
+}
+
+// NewParser returns a csv parser, checking its config.
+func NewParser(c *Config) (*Parser, error) {
+	p := &Parser{
+		Config:    *c,
+		delimiter: ',',
+		timeFunc:  time.Now,
+	}
+
+	if c.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(c.Delimiter)
+		if size != len(c.Delimiter) {
+			return nil, fmt.Errorf("csv_delimiter must be a single character, got %q", c.Delimiter)
+		}
+		p.delimiter = r
+	}
+	if c.Comment != "" {
+		r, size := utf8.DecodeRuneInString(c.Comment)
+		if size != len(c.Comment) {
+			return nil, fmt.Errorf("csv_comment must be a single character, got %q", c.Comment)
+		}
+		p.comment = r
+	}
+	if c.HeaderRowCount < 0 || c.SkipRows < 0 || c.SkipColumns < 0 {
+		return nil, fmt.Errorf("csv_header_row_count, csv_skip_rows and csv_skip_columns must not be negative")
+	}
+	for _, typ := range c.ColumnTypes {
+		switch typ {
+		case "", "int", "float", "bool", "string":
+		default:
+			return nil, fmt.Errorf("invalid csv column type %q, expected int, float, bool or string", typ)
+		}
+	}
+	if c.TimestampColumn != "" && c.TimestampFormat == "" {
+		return nil, fmt.Errorf("csv_timestamp_format is required with csv_timestamp_column")
+	}
+	return p, nil
+}
+
+func (p *Parser) reader(buf []byte) *csv.Reader {
+	r := csv.NewReader(bytes.NewReader(buf))
+	r.Comma = p.delimiter
+	r.Comment = p.comment
+	r.TrimLeadingSpace = p.TrimSpace
+	// The rows may have fewer columns than the header, the missing values
+	// are left out.
+	r.FieldsPerRecord = -1
+	return r
+}
+
+// Parse parses the rows of the csv data, after the skipped and header rows.
+func (p *Parser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	r := p.reader(buf)
+
+	for i := 0; i < p.SkipRows; i++ {
+		if _, err := r.Read(); err != nil {
+			if err == io.EOF {
+				return []telegraf.Metric{}, nil
+			}
+			return nil, err
+		}
+	}
+
+	headerRows := p.HeaderRowCount
+	if headerRows == 0 && len(p.ColumnNames) == 0 {
+		headerRows = 1
+	}
+	var names []string
+	for i := 0; i < headerRows; i++ {
+		header, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return []telegraf.Metric{}, nil
+			}
+			return nil, err
+		}
+		header = skipColumns(header, p.SkipColumns)
+		for j, name := range header {
+			name = strings.TrimSpace(name)
+			if j < len(names) {
+				names[j] += name
+			} else {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(p.ColumnNames) > 0 {
+		names = p.ColumnNames
+	}
+
+	metrics := make([]telegraf.Metric, 0)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		m, err := p.parseRecord(names, skipColumns(record, p.SkipColumns))
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// ParseLine parses a row of csv data, the names of the columns must be
+// given.
+func (p *Parser) ParseLine(line string) (telegraf.Metric, error) {
+	if len(p.ColumnNames) == 0 {
+		return nil, fmt.Errorf("csv_column_names are required to parse a single line")
+	}
+	record, err := p.reader([]byte(line)).Read()
+	if err != nil {
+		return nil, err
+	}
+	return p.parseRecord(p.ColumnNames, skipColumns(record, p.SkipColumns))
+}
+
+func (p *Parser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+func skipColumns(record []string, n int) []string {
+	if n >= len(record) {
+		return nil
+	}
+	return record[n:]
+}
+
+func (p *Parser) parseRecord(names, record []string) (telegraf.Metric, error) {
+	name := p.MetricName
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	fields := make(map[string]interface{})
+	tm := p.timeFunc()
+
+	for i, value := range record {
+		if i >= len(names) {
+			break
+		}
+		column := names[i]
+		if p.TrimSpace {
+			value = strings.TrimSpace(value)
+		}
+
+		switch {
+		case column == p.MeasurementColumn:
+			name = value
+			continue
+		case column == p.TimestampColumn:
+			t, err := parseTimestamp(p.TimestampFormat, value)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %v", column, err)
+			}
+			tm = t
+			continue
+		case isTagColumn(p.TagColumns, column):
+			tags[column] = value
+			continue
+		}
+
+		var typ string
+		if i < len(p.ColumnTypes) {
+			typ = p.ColumnTypes[i]
+		}
+		v, err := parseValue(typ, value)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", column, err)
+		}
+		if v != nil {
+			fields[column] = v
+		}
+	}
+
+	return metric.New(name, tags, fields, tm)
+}
+
+func isTagColumn(columns []string, column string) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// parseValue parses the value of a field in the type, guessed when empty.
+// Empty values are left out.
+func parseValue(typ, value string) (interface{}, error) {
+	if value == "" && typ != "string" {
+		return nil, nil
+	}
+	switch typ {
+	case "int":
+		return strconv.ParseInt(value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(value, 64)
+	case "bool":
+		return strconv.ParseBool(value)
+	case "string":
+		return value, nil
+	}
+
+	if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseFloat(value, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseBool(value); err == nil {
+		return v, nil
+	}
+	return value, nil
+}
+
+// parseTimestamp parses a unix timestamp, in s, ms, us or ns, or a time in a
+// Go reference time layout.
+func parseTimestamp(format, value string) (time.Time, error) {
+	var unit time.Duration
+	switch format {
+	case "unix":
+		unit = time.Second
+	case "unix_ms":
+		unit = time.Millisecond
+	case "unix_us":
+		unit = time.Microsecond
+	case "unix_ns":
+		unit = time.Nanosecond
+	default:
+		return time.Parse(format, value)
+	}
+
+	if unit == time.Second && strings.Contains(value, ".") {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(f*float64(time.Second))), nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n*int64(unit)), nil
+}
@@ -0,0 +1,130 @@
+package csv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Unix(1540000000, 0)
+
+func newParser(t *testing.T, c *Config) *Parser {
+	p, err := NewParser(c)
+	require.NoError(t, err)
+	p.timeFunc = func() time.Time { return now }
+	return p
+}
+
+func TestParseHeader(t *testing.T) {
+	p := newParser(t, &Config{
+		MetricName: "csv",
+		TagColumns: []string{"host"},
+	})
+	metrics, err := p.Parse([]byte("host,count,ratio,up,state\nedge1,3,0.5,true,ok\nedge2,,1,false,\"down, drained\"\n"))
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	assert.Equal(t, "csv", metrics[0].Name())
+	assert.Equal(t, map[string]string{"host": "edge1"}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"count": int64(3),
+		"ratio": 0.5,
+		"up":    true,
+		"state": "ok",
+	}, metrics[0].Fields())
+	assert.Equal(t, now, metrics[0].Time())
+
+	// Empty values are left out.
+	assert.Equal(t, map[string]interface{}{
+		"ratio": int64(1),
+		"up":    false,
+		"state": "down, drained",
+	}, metrics[1].Fields())
+}
+
+func TestParseSkipAndHeaderRows(t *testing.T) {
+	p := newParser(t, &Config{
+		MetricName:     "csv",
+		SkipRows:       1,
+		SkipColumns:    1,
+		HeaderRowCount: 2,
+		Delimiter:      ";",
+		Comment:        "#",
+		TrimSpace:      true,
+	})
+	data := "generated by probe\n" +
+		"id;disk;disk\n" +
+		"  ;_used;_free\n" +
+		"# a comment\n" +
+		"1; 10; 20\n"
+	metrics, err := p.Parse([]byte(data))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, map[string]interface{}{
+		"disk_used": int64(10),
+		"disk_free": int64(20),
+	}, metrics[0].Fields())
+}
+
+func TestParseColumnNamesAndTypes(t *testing.T) {
+	p := newParser(t, &Config{
+		ColumnNames:       []string{"name", "time", "code", "value"},
+		ColumnTypes:       []string{"", "", "string", "float"},
+		MeasurementColumn: "name",
+		TimestampColumn:   "time",
+		TimestampFormat:   "unix_ms",
+		DefaultTags:       map[string]string{"dc": "east"},
+	})
+	metrics, err := p.Parse([]byte("requests,1540000000123,200,3\n"))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "requests", metrics[0].Name())
+	assert.Equal(t, map[string]string{"dc": "east"}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"code":  "200",
+		"value": float64(3),
+	}, metrics[0].Fields())
+	assert.Equal(t, time.Unix(1540000000, 123000000), metrics[0].Time())
+
+	m, err := p.ParseLine("errors,1540000000000,500,1.5")
+	require.NoError(t, err)
+	assert.Equal(t, "errors", m.Name())
+	assert.Equal(t, 1.5, m.Fields()["value"])
+
+	_, err = p.ParseLine("errors,1540000000000,500,x")
+	assert.Error(t, err)
+}
+
+func TestParseTimestampLayout(t *testing.T) {
+	p := newParser(t, &Config{
+		MetricName:      "csv",
+		TimestampColumn: "time",
+		TimestampFormat: "2006-01-02T15:04:05Z07:00",
+	})
+	metrics, err := p.Parse([]byte("time,value\n2018-10-20T01:46:40Z,1\n"))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.True(t, time.Unix(1540000000, 0).Equal(metrics[0].Time()))
+	assert.Equal(t, map[string]interface{}{"value": int64(1)}, metrics[0].Fields())
+
+	_, err = p.Parse([]byte("time,value\nyesterday,1\n"))
+	assert.Error(t, err)
+}
+
+func TestNewParserInvalid(t *testing.T) {
+	for _, c := range []*Config{
+		{Delimiter: ";;"},
+		{ColumnTypes: []string{"integer"}},
+		{TimestampColumn: "time"},
+		{SkipRows: -1},
+	} {
+		_, err := NewParser(c)
+		assert.Error(t, err)
+	}
+
+	p := newParser(t, &Config{})
+	_, err := p.ParseLine("1,2")
+	assert.Error(t, err)
+}
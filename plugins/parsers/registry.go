@@ -5,7 +5,13 @@ import (
 
 	"github.com/influxdata/telegraf"
 
+	"github.com/influxdata/telegraf/plugins/parsers/collectd"
+	"github.com/influxdata/telegraf/plugins/parsers/csv"
+	"github.com/influxdata/telegraf/plugins/parsers/dropwizard"
+	"github.com/influxdata/telegraf/plugins/parsers/graphite"
+	"github.com/influxdata/telegraf/plugins/parsers/influx"
 	"github.com/influxdata/telegraf/plugins/parsers/json"
+	"github.com/influxdata/telegraf/plugins/parsers/nagios"
 	"github.com/influxdata/telegraf/plugins/parsers/value"
 )
 
@@ -41,7 +47,8 @@ type Parser interface {
 // Config is a struct that covers the data types needed for all parser types,
 // and can be used to instantiate _any_ of the parsers.
 type Config struct {
-	// Dataformat can be one of: json, influx, graphite, value, nagios
+	// Dataformat can be one of: json, influx, graphite, value, nagios,
+	// collectd, dropwizard, csv
 	DataFormat string
 
 	// Separator only applied to Graphite data.
@@ -82,6 +89,35 @@ type Config struct {
 	// an optional map containing tag names as keys and json paths to retrieve the tag values from as values
 	// used if TagsPath is empty or doesn't return any tags
 	DropwizardTagPathsMap map[string]string
+
+	// Names of the columns of csv data, taken from the header rows when
+	// empty
+	CSVColumnNames []string
+	// Types of the columns of csv data, int, float, bool or string, the
+	// types of the other columns are guessed from their values
+	CSVColumnTypes []string
+	// Lines starting with the comment character are ignored
+	CSVComment string
+	// Delimiter of the csv columns, a comma by default
+	CSVDelimiter string
+	// Number of header rows, their values are joined to name the columns.
+	// When zero, the first row is the header unless the column names are
+	// given
+	CSVHeaderRowCount int
+	// Column holding the measurement name, MetricName by default
+	CSVMeasurementColumn string
+	// Number of columns to skip at the start of each row
+	CSVSkipColumns int
+	// Number of rows to skip before the header rows
+	CSVSkipRows int
+	// Columns added as tags
+	CSVTagColumns []string
+	// Column holding the time of the metrics, and its format: unix,
+	// unix_ms, unix_us, unix_ns or a Go reference time layout
+	CSVTimestampColumn string
+	CSVTimestampFormat string
+	// Whether to trim the leading spaces of the values
+	CSVTrimSpace bool
 }
 
 // NewParser returns a Parser interface based on the given config.
@@ -95,6 +131,28 @@ func NewParser(config *Config) (Parser, error) {
 	case "value":
 		parser, err = NewValueParser(config.MetricName,
 			config.DataType, config.DefaultTags)
+	case "influx":
+		parser, err = NewInfluxParser()
+	case "nagios":
+		parser, err = NewNagiosParser()
+	case "graphite":
+		parser, err = NewGraphiteParser(config.Separator,
+			config.Templates, config.DefaultTags)
+	case "collectd":
+		parser, err = NewCollectdParser(config.CollectdAuthFile,
+			config.CollectdSecurityLevel, config.CollectdTypesDB)
+	case "dropwizard":
+		parser, err = NewDropwizardParser(
+			config.DropwizardMetricRegistryPath,
+			config.DropwizardTimePath,
+			config.DropwizardTimeFormat,
+			config.DropwizardTagsPath,
+			config.DropwizardTagPathsMap,
+			config.DefaultTags,
+			config.Separator,
+			config.Templates)
+	case "csv":
+		parser, err = NewCSVParser(config)
 	default:
 		err = fmt.Errorf("Invalid data format: %s", config.DataFormat)
 	}
@@ -125,3 +183,70 @@ func NewValueParser(
 		DefaultTags: defaultTags,
 	}, nil
 }
+
+func NewInfluxParser() (Parser, error) {
+	handler := influx.NewMetricHandler()
+	return influx.NewParser(handler), nil
+}
+
+func NewNagiosParser() (Parser, error) {
+	return &nagios.NagiosParser{}, nil
+}
+
+func NewGraphiteParser(
+	separator string,
+	templates []string,
+	defaultTags map[string]string,
+) (Parser, error) {
+	return graphite.NewGraphiteParser(separator, templates, defaultTags)
+}
+
+func NewCollectdParser(
+	authFile string,
+	securityLevel string,
+	typesDB []string,
+) (Parser, error) {
+	return collectd.NewCollectdParser(authFile, securityLevel, typesDB)
+}
+
+func NewDropwizardParser(
+	metricRegistryPath string,
+	timePath string,
+	timeFormat string,
+	tagsPath string,
+	tagPathsMap map[string]string,
+	defaultTags map[string]string,
+	separator string,
+	templates []string,
+) (Parser, error) {
+	parser := dropwizard.NewParser()
+	parser.MetricRegistryPath = metricRegistryPath
+	parser.TimePath = timePath
+	parser.TimeFormat = timeFormat
+	parser.TagsPath = tagsPath
+	parser.TagPathsMap = tagPathsMap
+	parser.DefaultTags = defaultTags
+	if err := parser.SetTemplates(separator, templates); err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func NewCSVParser(config *Config) (Parser, error) {
+	return csv.NewParser(&csv.Config{
+		MetricName:        config.MetricName,
+		ColumnNames:       config.CSVColumnNames,
+		ColumnTypes:       config.CSVColumnTypes,
+		Comment:           config.CSVComment,
+		Delimiter:         config.CSVDelimiter,
+		HeaderRowCount:    config.CSVHeaderRowCount,
+		MeasurementColumn: config.CSVMeasurementColumn,
+		SkipColumns:       config.CSVSkipColumns,
+		SkipRows:          config.CSVSkipRows,
+		TagColumns:        config.CSVTagColumns,
+		TimestampColumn:   config.CSVTimestampColumn,
+		TimestampFormat:   config.CSVTimestampFormat,
+		TrimSpace:         config.CSVTrimSpace,
+		DefaultTags:       config.DefaultTags,
+	})
+}
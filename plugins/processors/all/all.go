@@ -2,4 +2,6 @@ package all
 
 import (
 	_ "github.com/influxdata/telegraf/plugins/processors/blacklist"
+	_ "github.com/influxdata/telegraf/plugins/processors/converter"
+	_ "github.com/influxdata/telegraf/plugins/processors/override"
 )
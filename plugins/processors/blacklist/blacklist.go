@@ -2,29 +2,97 @@ package blacklist
 
 import (
 	"encoding/xml"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/metric"
 	"github.com/influxdata/telegraf/plugins/processors"
+	"github.com/influxdata/telegraf/selfstat"
 )
 
 var sampleConfig = `
   [[processors.blacklist]]
     config = "/usr/local/akamai/goblin_telegraf/conf/goblin.restriction.conf"
+
+    ## How often the restriction file is checked for changes, in the
+    ## background from the start of the agent. Metrics are evaluated against
+    ## the last successfully loaded restrictions in the meantime.
+    # reload_interval = "10s"
+
+    ## What to do when the restriction file does not exist:
+    ##   pass_all  - let every metric through
+    ##   drop_all  - drop every metric
+    ##   keep_last - keep using the last loaded restrictions, or drop every
+    ##               metric if none have been loaded yet
+    # on_missing_file = "keep_last"
+
+    ## In dry run mode no metric is dropped or modified. Instead the metrics
+    ## are tagged with the decision that would apply, using the
+    ## blacklist_decision, blacklist_rule, blacklist_owner and
+    ## blacklist_columns tags.
+    # dry_run = false
+
+    ## The network identity of this host, used to evaluate the <criteria>
+    ## elements of the restriction file. The network is taken from the first
+    ## of these sources that is set.
+    # network = "freeflow"
+    # network_env = "AKAMAI_NETWORK"
+    # network_file = "/usr/local/akamai/etc/staticinfo/network"
+
+    ## Additional identity attributes. The region may also be given in the
+    ## network_file as "region = <name>", and the hostname defaults to the
+    ## hostname of the machine.
+    # region = ""
+    # hostname = ""
 `
 
-// Restriction is the top xml struct for the restriction file
+const (
+	passAll  = "pass_all"
+	dropAll  = "drop_all"
+	keepLast = "keep_last"
+)
+
+// Restriction drops the metrics restricted by the restriction file
 type Restriction struct {
-	Config       string
+	Config         string
+	ReloadInterval internal.Duration
+	OnMissingFile  string
+	DryRun         bool
+
+	Network     string
+	NetworkEnv  string
+	NetworkFile string
+	Region      string
+	Hostname    string
+
+	// rules holds the compiled *ruleSet currently in use
+	rules        atomic.Value
 	lastReadTime time.Time
+	initOnce     sync.Once
 
-	Group     []Group `xml:"group"`
-	Blacklist map[string]struct{}
-	Whitelist map[string]struct{}
+	// done stops the reloads started by Start
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	reloads      selfstat.Stat
+	reloadErrors selfstat.Stat
+	// ownerStats holds the passed and dropped counters of each owner
+	ownerStats map[string][2]selfstat.Stat
+}
+
+// restrictionFile is the top xml struct for the restriction file
+type restrictionFile struct {
+	Group []Group `xml:"group"`
 }
 
 // Group contains the restrictions imposed by an interested party
@@ -32,16 +100,28 @@ type Group struct {
 	Owner       string     `xml:"owner,attr"`
 	Criteria    []Criteria `xml:"criteria"`
 	Whitelisted struct {
-		Tables []string `xml:"tablename,attr"`
-	} `xml:"whitelist>table"`
+		Tables []Table `xml:"table"`
+	} `xml:"whitelist"`
 	Blacklisted struct {
-		Tables []string `xml:"tablename,attr"`
-	} `xml:"blacklist>table"`
+		Tables  []Table  `xml:"table"`
+		Columns []Column `xml:"column"`
+		Tags    []Tag    `xml:"tag"`
+	} `xml:"blacklist"`
 }
 
-// Criteria determines whether this grouping applies or not
+// Criteria determines whether this grouping applies or not. Attributes may
+// contain globs.
 type Criteria struct {
-	Network string `xml:"network,attr"`
+	Network  string `xml:"network,attr"`
+	Region   string `xml:"region,attr"`
+	Hostname string `xml:"hostname,attr"`
+}
+
+func NewRestriction() *Restriction {
+	return &Restriction{
+		ReloadInterval: internal.Duration{Duration: 10 * time.Second},
+		OnMissingFile:  keepLast,
+	}
 }
 
 func (r *Restriction) SampleConfig() string {
@@ -53,19 +133,31 @@ func (r *Restriction) Description() string {
 }
 
 func (r *Restriction) Apply(in ...telegraf.Metric) []telegraf.Metric {
-	info, err := os.Stat(r.Config)
-	if err == nil {
-		if r.lastReadTime != info.ModTime() {
-			r.readConfig()
-			r.lastReadTime = info.ModTime()
-		}
-	}
+	// The first load is done inline so that no metrics are evaluated before
+	// the restrictions are known, later loads happen in the background.
+	r.initOnce.Do(r.init)
+
+	rules := r.ruleSet()
 	res := make([]telegraf.Metric, 0, 0)
 	for _, metric := range in {
-		if _, ok := r.Blacklist[metric.Name()]; ok {
+		d := rules.evaluate(metric, r.DryRun)
+		r.count(d)
+		if r.DryRun {
+			metric.AddTag("blacklist_decision", d.String())
+			metric.AddTag("blacklist_rule", d.rule)
+			if d.owner != "" {
+				metric.AddTag("blacklist_owner", d.owner)
+			}
+			if len(d.columns) > 0 {
+				metric.AddTag("blacklist_columns", strings.Join(d.columns, ","))
+			}
+			res = append(res, metric)
 			continue
 		}
-		if _, ok := r.Whitelist[metric.Name()]; !ok {
+		if d.drop {
+			// Dropped metrics are delivered, else the tracked metrics they
+			// belong to are never acknowledged.
+			metric.Drop()
 			continue
 		}
 		res = append(res, metric)
@@ -73,49 +165,269 @@ func (r *Restriction) Apply(in ...telegraf.Metric) []telegraf.Metric {
 	return res
 }
 
+// count updates the per owner statistics for the decision
+func (r *Restriction) count(d decision) {
+	owner := d.owner
+	if owner == "" {
+		owner = "none"
+	}
+	stats, ok := r.ownerStats[owner]
+	if !ok {
+		tags := map[string]string{"config": r.Config, "owner": owner}
+		stats = [2]selfstat.Stat{
+			selfstat.Register("blacklist", "metrics_passed", tags),
+			selfstat.Register("blacklist", "metrics_dropped", tags),
+		}
+		r.ownerStats[owner] = stats
+	}
+	if d.drop {
+		stats[1].Incr(1)
+	} else {
+		stats[0].Incr(1)
+	}
+}
+
+func (r *Restriction) init() {
+	tags := map[string]string{"config": r.Config}
+	r.reloads = selfstat.Register("blacklist", "reloads", tags)
+	r.reloadErrors = selfstat.Register("blacklist", "reload_errors", tags)
+	r.ownerStats = make(map[string][2]selfstat.Stat)
+
+	switch r.OnMissingFile {
+	case passAll, dropAll, keepLast:
+	case "":
+		r.OnMissingFile = keepLast
+	default:
+		log.Printf("E! [processors.blacklist] invalid on_missing_file %q, using %q\n",
+			r.OnMissingFile, keepLast)
+		r.OnMissingFile = keepLast
+	}
+
+	r.readConfig()
+}
+
+// Start loads the restriction file and checks it for changes every reload
+// interval in the background, until Stop is called.
+func (r *Restriction) Start() error {
+	r.initOnce.Do(r.init)
+	if r.ReloadInterval.Duration <= 0 {
+		return fmt.Errorf("invalid reload_interval %s", r.ReloadInterval.Duration)
+	}
+
+	r.done = make(chan struct{})
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.ReloadInterval.Duration)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.readConfig()
+			case <-r.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the reloads and waits for the ongoing one.
+func (r *Restriction) Stop() {
+	if r.done == nil {
+		return
+	}
+	close(r.done)
+	r.wg.Wait()
+	r.done = nil
+}
+
+// ruleSet returns the rules currently in use
+func (r *Restriction) ruleSet() *ruleSet {
+	rules, _ := r.rules.Load().(*ruleSet)
+	if rules == nil {
+		// Nothing has been loaded, drop everything
+		return &ruleSet{}
+	}
+	return rules
+}
+
+// readConfig reloads the restriction file if it has changed, and swaps in the
+// compiled rules. An invalid file keeps the current rules.
 func (r *Restriction) readConfig() {
+	info, err := os.Stat(r.Config)
+	if err != nil {
+		r.missingConfig(err)
+		return
+	}
+	if r.lastReadTime.Equal(info.ModTime()) {
+		return
+	}
+	r.lastReadTime = info.ModTime()
 
-	blacklist := make(map[string]struct{})
-	whitelist := make(map[string]struct{})
-	rfile, err := ioutil.ReadFile(r.Config)
+	rules, err := r.loadRules()
 	if err != nil {
-		// We have an empty blacklist and whitelist
-		r.Blacklist = blacklist
-		r.Whitelist = whitelist
+		log.Printf("E! [processors.blacklist] unable to load %s, keeping the current restrictions: %v\n",
+			r.Config, err)
+		if r.reloadErrors != nil {
+			r.reloadErrors.Incr(1)
+		}
 		return
 	}
-	v := Restriction{}
+	r.rules.Store(rules)
+	if r.reloads != nil {
+		r.reloads.Incr(1)
+	}
+}
+
+// missingConfig applies the on_missing_file policy
+func (r *Restriction) missingConfig(err error) {
+	if !r.lastReadTime.IsZero() || r.rules.Load() == nil {
+		log.Printf("E! [processors.blacklist] unable to read %s, applying %s: %v\n",
+			r.Config, r.OnMissingFile, err)
+	}
+	// Make sure the file is read again once it is restored
+	r.lastReadTime = time.Time{}
+
+	switch r.OnMissingFile {
+	case passAll:
+		r.rules.Store(&ruleSet{passAll: true})
+	case dropAll:
+		r.rules.Store(&ruleSet{})
+	}
+}
+
+// loadRules reads and compiles the restriction file
+func (r *Restriction) loadRules() (*ruleSet, error) {
+	rfile, err := ioutil.ReadFile(r.Config)
+	if err != nil {
+		return nil, err
+	}
+	v := restrictionFile{}
 	if err := xml.Unmarshal(rfile, &v); err != nil {
-		// Invalid restriction file results in no changes made.
-		return
+		return nil, fmt.Errorf("invalid restriction file: %v", err)
+	}
+
+	rules := &ruleSet{
+		blacklist: make(map[string]string),
+		whitelist: make(map[string]string),
+	}
+	id := r.identity()
+	if !identityConfigured(id) {
+		log.Printf("W! [processors.blacklist] no host identity configured, criteria are ignored\n")
 	}
-	x := struct{}{}
 	for _, group := range v.Group {
-		if !r.applicable(group.Criteria) {
+		if !r.applicable(group.Criteria, id) {
+			log.Printf("[processors.blacklist] skipping restrictions for %s, criteria not met\n", group.Owner)
 			continue
 		}
 		for _, t := range group.Whitelisted.Tables {
-			tname := strings.ToLower(strings.TrimSpace(t))
-			whitelist[tname] = x
+			tname, rule, err := compileTable(group.Owner, t)
+			if err != nil {
+				log.Printf("E! [processors.blacklist] ignoring whitelist rule for %s: %v\n", group.Owner, err)
+				continue
+			}
+			if rule != nil {
+				rules.whitelistPatterns = append(rules.whitelistPatterns, *rule)
+				log.Printf("[processors.blacklist] whitelisting pattern %s for %s\n", rule.pattern, group.Owner)
+				continue
+			}
+			rules.whitelist[tname] = group.Owner
 			log.Printf("[processors.blacklist] whitelisting %s for %s\n", tname, group.Owner)
 		}
 		for _, t := range group.Blacklisted.Tables {
-			tname := strings.ToLower(strings.TrimSpace(t))
-			blacklist[tname] = x
+			tname, rule, err := compileTable(group.Owner, t)
+			if err != nil {
+				log.Printf("E! [processors.blacklist] ignoring blacklist rule for %s: %v\n", group.Owner, err)
+				continue
+			}
+			if rule != nil {
+				rules.blacklistPatterns = append(rules.blacklistPatterns, *rule)
+				log.Printf("[processors.blacklist] blacklisting pattern %s for %s\n", rule.pattern, group.Owner)
+				continue
+			}
+			rules.blacklist[tname] = group.Owner
 			log.Printf("[processors.blacklist] blacklisting %s for %s\n", tname, group.Owner)
 		}
+		for _, c := range group.Blacklisted.Columns {
+			rule, err := compileColumn(group.Owner, c)
+			if err != nil {
+				log.Printf("E! [processors.blacklist] ignoring column rule for %s: %v\n", group.Owner, err)
+				continue
+			}
+			rules.columnRules = append(rules.columnRules, rule)
+			log.Printf("[processors.blacklist] blacklisting column %s of %q for %s\n", c.Name, c.Table, group.Owner)
+		}
+		for _, t := range group.Blacklisted.Tags {
+			rule, err := compileTag(group.Owner, t)
+			if err != nil {
+				log.Printf("E! [processors.blacklist] ignoring tag rule for %s: %v\n", group.Owner, err)
+				continue
+			}
+			rules.tagRules = append(rules.tagRules, rule)
+			log.Printf("[processors.blacklist] blacklisting tag %s=%s of %q for %s\n", t.Key, t.Value, t.Table, group.Owner)
+		}
+	}
+	return rules, nil
+}
+
+// Test evaluates the restriction file against the tables, and writes the
+// decision for each of them to w.
+func (r *Restriction) Test(w io.Writer, tables []string) error {
+	rules, err := r.loadRules()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tDECISION\tRULE\tOWNER")
+	for _, table := range tables {
+		m, err := metric.New(table,
+			map[string]string{},
+			map[string]interface{}{"value": 1},
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+		d := rules.evaluate(m, true)
+		owner := d.owner
+		if owner == "" {
+			owner = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", table, d, d.rule, owner)
 	}
-	r.Blacklist = blacklist
-	r.Whitelist = whitelist
+	return tw.Flush()
+}
+
+// TestFile evaluates the restriction file at path against the tables, it
+// implements processors.Tester.
+func (r *Restriction) TestFile(w io.Writer, path string, tables []string) error {
+	r.Config = path
+	return r.Test(w, tables)
 }
 
-// TODO(awang) be able to restrict by network
-func (r *Restriction) applicable(criteria []Criteria) bool {
-	return true
+// applicable reports whether a group applies to this host. A group without
+// criteria always applies, otherwise at least one of its criteria must match.
+// Criteria using only attributes that can not be resolved on this host are
+// ignored, and the group applies when none of them could be evaluated.
+func (r *Restriction) applicable(criteria []Criteria, id hostIdentity) bool {
+	evaluated := false
+	for _, c := range criteria {
+		if !c.resolvable(id) {
+			continue
+		}
+		if c.matches(id) {
+			return true
+		}
+		evaluated = true
+	}
+	return !evaluated
 }
 
 func init() {
 	processors.Add("blacklist", func() telegraf.Processor {
-		return &Restriction{}
+		return NewRestriction()
 	})
+	processors.AddTester("restriction", "blacklist")
 }
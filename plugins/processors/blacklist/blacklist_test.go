@@ -1,22 +1,29 @@
 package blacklist
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/models"
 	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/plugins/processors"
 	"github.com/stretchr/testify/assert"
 )
 
 // Test config reader
 func TestReadConfig(t *testing.T) {
-	r := Restriction{
-		Config: "test/test1.xml",
-	}
+	r := NewRestriction()
+	r.Config = "test/test1.xml"
 	r.readConfig()
-	assert.Equal(t, 2, len(r.Whitelist))
-	assert.Equal(t, 2, len(r.Blacklist))
+	assert.Equal(t, 2, len(r.ruleSet().whitelist))
+	assert.Equal(t, 2, len(r.ruleSet().blacklist))
 }
 
 func newMetric(name string) telegraf.Metric {
@@ -30,16 +37,17 @@ func newMetric(name string) telegraf.Metric {
 
 // TestApplyWhitelist
 func TestApplyWhitelist(t *testing.T) {
-	r := Restriction{}
-	r.Whitelist = make(map[string]struct{})
+	r := NewRestriction()
+	whitelist := make(map[string]string)
+	r.rules.Store(&ruleSet{whitelist: whitelist})
 
 	// If there is no whitelist, drop all metrics
 	processed := r.Apply(newMetric("table0"))
 	assert.Equal(t, 0, len(processed))
 
 	// If there is a whitelist, only allow the whitelisted metrics through
-	r.Whitelist["table1"] = struct{}{}
-	r.Whitelist["table2"] = struct{}{}
+	whitelist["table1"] = "owner"
+	whitelist["table2"] = "owner"
 	m1 := newMetric("table1")
 	processed = r.Apply(m1)
 	assert.Equal(t, 1, len(processed))
@@ -51,3 +59,491 @@ func TestApplyWhitelist(t *testing.T) {
 	processed = r.Apply(newMetric("table3"))
 	assert.Equal(t, 0, len(processed))
 }
+
+func tableNames(set map[string]string) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Test that groups are only applied when one of their criteria matches
+func TestReadConfigCriteria(t *testing.T) {
+	tests := []struct {
+		name      string
+		r         *Restriction
+		whitelist []string
+		blacklist []string
+	}{
+		{
+			name:      "unresolved network criteria are ignored",
+			r:         &Restriction{Hostname: "host1"},
+			whitelist: []string{"common_table", "essl_table", "freeflow_table", "regional_table"},
+			blacklist: []string{"freeflow_table"},
+		},
+		{
+			name:      "hostname criteria match without a network",
+			r:         &Restriction{Hostname: "edge-1.example.com"},
+			whitelist: []string{"common_table", "edge_table", "essl_table", "freeflow_table", "regional_table"},
+			blacklist: []string{"freeflow_table"},
+		},
+		{
+			name:      "region is evaluated without a network",
+			r:         &Restriction{Region: "eu-west", Hostname: "host1"},
+			whitelist: []string{"common_table", "essl_table", "freeflow_table"},
+			blacklist: []string{"freeflow_table"},
+		},
+		{
+			name:      "network matches a single group",
+			r:         &Restriction{Network: "freeflow", Hostname: "host1"},
+			whitelist: []string{"common_table", "freeflow_table"},
+			blacklist: []string{},
+		},
+		{
+			name:      "network and region both match",
+			r:         &Restriction{Network: "FreeFlow", Region: "us-east", Hostname: "host1"},
+			whitelist: []string{"common_table", "freeflow_table", "regional_table"},
+			blacklist: []string{},
+		},
+		{
+			name:      "any criteria in a group may match",
+			r:         &Restriction{Network: "secure-edge", Hostname: "host1"},
+			whitelist: []string{"common_table", "essl_table"},
+			blacklist: []string{"freeflow_table"},
+		},
+		{
+			name:      "hostname glob matches",
+			r:         &Restriction{Network: "other", Hostname: "edge-12.example.com"},
+			whitelist: []string{"common_table", "edge_table"},
+			blacklist: []string{},
+		},
+		{
+			name:      "no criteria match",
+			r:         &Restriction{Network: "other", Hostname: "host1"},
+			whitelist: []string{"common_table"},
+			blacklist: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.r.Config = "test/criteria.xml"
+			tt.r.readConfig()
+			assert.Equal(t, tt.whitelist, tableNames(tt.r.ruleSet().whitelist))
+			assert.Equal(t, tt.blacklist, tableNames(tt.r.ruleSet().blacklist))
+		})
+	}
+}
+
+// Test that a hostname only group is skipped on another host when no network
+// identity is configured
+func TestHostnameCriteriaNotMatched(t *testing.T) {
+	r := &Restriction{Config: "test/criteria.xml", Hostname: "other-host"}
+	r.readConfig()
+	_, ok := r.ruleSet().whitelist["edge_table"]
+	assert.False(t, ok)
+
+	processed := r.Apply(newMetricWith("edge_table", map[string]string{}, map[string]interface{}{"value": 1}))
+	assert.Equal(t, 0, len(processed))
+}
+
+// Test the sources the network identity can be read from
+func TestIdentitySources(t *testing.T) {
+	os.Setenv("TEST_BLACKLIST_NETWORK", "freeflow")
+	defer os.Unsetenv("TEST_BLACKLIST_NETWORK")
+
+	r := &Restriction{NetworkFile: "test/network"}
+	id := r.identity()
+	assert.Equal(t, "essl", id.Network)
+	assert.Equal(t, "eu-west", id.Region)
+
+	r = &Restriction{NetworkEnv: "TEST_BLACKLIST_NETWORK", NetworkFile: "test/network"}
+	id = r.identity()
+	assert.Equal(t, "freeflow", id.Network)
+	assert.Equal(t, "eu-west", id.Region)
+
+	r = &Restriction{Network: "essl", NetworkEnv: "TEST_BLACKLIST_NETWORK", Region: "us-east"}
+	id = r.identity()
+	assert.Equal(t, "essl", id.Network)
+	assert.Equal(t, "us-east", id.Region)
+
+	r = &Restriction{NetworkFile: "test/does_not_exist"}
+	id = r.identity()
+	assert.Equal(t, "", id.Network)
+}
+
+func newMetricWith(name string, tags map[string]string, fields map[string]interface{}) telegraf.Metric {
+	m, _ := metric.New(name, tags, fields, time.Now())
+	return m
+}
+
+// Test glob and regex table rules, and the precedence between groups
+func TestApplyTablePatterns(t *testing.T) {
+	r := NewRestriction()
+	r.Config = "test/patterns.xml"
+	r.readConfig()
+
+	fields := map[string]interface{}{"value": 1}
+	tests := []struct {
+		name   string
+		passed bool
+	}{
+		// whitelisted by glob
+		{"gm_example", true},
+		// whitelisted by regex
+		{"sys_disk", true},
+		{"sys_disk_io", false},
+		// not whitelisted by any group
+		{"example", false},
+		// a blacklist in one group overrides a whitelist in any group
+		{"gm_secret", false},
+		// a blacklist pattern overrides a whitelist pattern
+		{"gm_private_keys", false},
+	}
+	for _, tt := range tests {
+		processed := r.Apply(newMetricWith(tt.name, map[string]string{}, fields))
+		if tt.passed {
+			assert.Equal(t, 1, len(processed), tt.name)
+		} else {
+			assert.Equal(t, 0, len(processed), tt.name)
+		}
+	}
+}
+
+// Test that column rules strip fields and tags from matching tables only
+func TestApplyColumnRules(t *testing.T) {
+	r := NewRestriction()
+	r.Config = "test/patterns.xml"
+	r.readConfig()
+
+	m := newMetricWith("gm_example",
+		map[string]string{"password_hint": "x", "host": "a", "token": "y"},
+		map[string]interface{}{"password": "secret", "value": 1, "token": "z"},
+	)
+	processed := r.Apply(m)
+	assert.Equal(t, 1, len(processed))
+	assert.Equal(t, map[string]string{"host": "a"}, processed[0].Tags())
+	assert.Equal(t, map[string]interface{}{"value": int64(1)}, processed[0].Fields())
+
+	// The gm_* column rule does not apply to other tables
+	m = newMetricWith("sys_mem",
+		map[string]string{},
+		map[string]interface{}{"password": "secret", "token": "z"},
+	)
+	processed = r.Apply(m)
+	assert.Equal(t, 1, len(processed))
+	assert.Equal(t, map[string]interface{}{"password": "secret"}, processed[0].Fields())
+
+	// A metric without any remaining fields is dropped
+	m = newMetricWith("gm_example",
+		map[string]string{},
+		map[string]interface{}{"password": "secret"},
+	)
+	processed = r.Apply(m)
+	assert.Equal(t, 0, len(processed))
+}
+
+// Test that the metrics dropped by a processor chain are delivered, so that
+// the tracked groups they belong to are acknowledged
+func TestApplyTrackedGroup(t *testing.T) {
+	r := NewRestriction()
+	r.Config = "test/patterns.xml"
+	r.readConfig()
+	processors := models.RunningProcessors{
+		&models.RunningProcessor{
+			Processor: r,
+			Config:    &models.ProcessorConfig{Name: "blacklist"},
+		},
+	}
+
+	var delivered []telegraf.DeliveryInfo
+	group, _ := metric.WithGroupTracking([]telegraf.Metric{
+		// blacklisted table
+		newMetricWith("gm_secret", map[string]string{}, map[string]interface{}{"value": 1}),
+		// no field left once the columns are removed
+		newMetricWith("gm_example", map[string]string{}, map[string]interface{}{"password": "secret"}),
+		newMetricWith("gm_example", map[string]string{}, map[string]interface{}{"value": 1}),
+	}, func(info telegraf.DeliveryInfo) {
+		delivered = append(delivered, info)
+	})
+
+	processed := processors.Apply(group...)
+	assert.Equal(t, 1, len(processed))
+	assert.Empty(t, delivered)
+	processed[0].Accept()
+	if assert.Equal(t, 1, len(delivered)) {
+		assert.True(t, delivered[0].Delivered())
+	}
+
+	// The group is delivered when all its metrics are dropped
+	delivered = nil
+	group, _ = metric.WithGroupTracking([]telegraf.Metric{
+		newMetricWith("example", map[string]string{}, map[string]interface{}{"value": 1}),
+	}, func(info telegraf.DeliveryInfo) {
+		delivered = append(delivered, info)
+	})
+	assert.Equal(t, 0, len(processors.Apply(group...)))
+	if assert.Equal(t, 1, len(delivered)) {
+		assert.True(t, delivered[0].Delivered())
+	}
+}
+
+// Test that tag rules drop metrics with matching tag values
+func TestApplyTagRules(t *testing.T) {
+	r := NewRestriction()
+	r.Config = "test/patterns.xml"
+	r.readConfig()
+
+	fields := map[string]interface{}{"usage": 0.5}
+	processed := r.Apply(newMetricWith("cpu", map[string]string{"cpu": "cpu-total"}, fields))
+	assert.Equal(t, 0, len(processed))
+
+	processed = r.Apply(newMetricWith("cpu", map[string]string{"cpu": "cpu0"}, fields))
+	assert.Equal(t, 1, len(processed))
+
+	// The cpu tag rule is restricted to the cpu table
+	processed = r.Apply(newMetricWith("gm_example", map[string]string{"cpu": "cpu-total"}, fields))
+	assert.Equal(t, 1, len(processed))
+
+	// Tag rules without a tablename apply to every table
+	processed = r.Apply(newMetricWith("gm_example", map[string]string{"env": "testing"}, fields))
+	assert.Equal(t, 0, len(processed))
+	processed = r.Apply(newMetricWith("cpu", map[string]string{"env": "prod"}, fields))
+	assert.Equal(t, 1, len(processed))
+}
+
+const reloadXML = `
+<goblin_telegraf>
+  <group owner="Reload">
+    <whitelist>
+      <table tablename="%s" />
+    </whitelist>
+  </group>
+</goblin_telegraf>
+`
+
+func writeRestriction(t *testing.T, path string, content string) {
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	assert.NoError(t, err)
+}
+
+// Test the policies applied when the restriction file is missing
+func TestOnMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blacklist")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "restriction.xml")
+
+	tests := []struct {
+		policy        string
+		passedInitial int
+		passedRemoved int
+	}{
+		{policy: "pass_all", passedInitial: 1, passedRemoved: 2},
+		{policy: "drop_all", passedInitial: 0, passedRemoved: 0},
+		{policy: "keep_last", passedInitial: 0, passedRemoved: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			r := NewRestriction()
+			r.Config = path
+			r.OnMissingFile = tt.policy
+
+			// Missing when the processor starts
+			os.Remove(path)
+			processed := r.Apply(newMetric("table1"))
+			assert.Equal(t, tt.passedInitial, len(processed))
+
+			// Missing after a restriction file has been loaded
+			writeRestriction(t, path, fmt.Sprintf(reloadXML, "table1"))
+			r.readConfig()
+			processed = r.Apply(newMetric("table1"), newMetric("table2"))
+			assert.Equal(t, 1, len(processed))
+
+			os.Remove(path)
+			r.readConfig()
+			processed = r.Apply(newMetric("table1"), newMetric("table2"))
+			assert.Equal(t, tt.passedRemoved, len(processed))
+		})
+	}
+}
+
+// Test that an invalid restriction file keeps the current restrictions
+func TestInvalidFileKeepsRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blacklist")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "restriction.xml")
+	writeRestriction(t, path, fmt.Sprintf(reloadXML, "table1"))
+
+	r := NewRestriction()
+	r.Config = path
+	processed := r.Apply(newMetric("table1"))
+	assert.Equal(t, 1, len(processed))
+
+	errors := r.reloadErrors.Get()
+	writeRestriction(t, path, "<goblin_telegraf><group>")
+	// Make sure the modification time differs from the previous file
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+	r.readConfig()
+	assert.Equal(t, errors+1, r.reloadErrors.Get())
+
+	processed = r.Apply(newMetric("table1"))
+	assert.Equal(t, 1, len(processed))
+}
+
+// Test that changes are picked up by the background reload, without any
+// metric being applied
+func TestBackgroundReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blacklist")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "restriction.xml")
+	writeRestriction(t, path, fmt.Sprintf(reloadXML, "table1"))
+
+	r := NewRestriction()
+	r.Config = path
+	r.ReloadInterval.Duration = 10 * time.Millisecond
+	assert.NoError(t, r.Start())
+	defer r.Stop()
+	assert.True(t, r.ruleSet().evaluate(newMetric("table2"), false).drop)
+
+	writeRestriction(t, path, fmt.Sprintf(reloadXML, "table2"))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if !r.ruleSet().evaluate(newMetric("table2"), false).drop {
+			assert.Equal(t, 1, len(r.Apply(newMetric("table2"))))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("restriction file was not reloaded")
+}
+
+// Test that the restriction file is no longer reloaded once stopped
+func TestStopReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blacklist")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "restriction.xml")
+	writeRestriction(t, path, fmt.Sprintf(reloadXML, "table1"))
+
+	r := NewRestriction()
+	r.Config = path
+	r.ReloadInterval.Duration = 10 * time.Millisecond
+	assert.NoError(t, r.Start())
+	r.Stop()
+
+	writeRestriction(t, path, fmt.Sprintf(reloadXML, "table2"))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, r.ruleSet().evaluate(newMetric("table2"), false).drop)
+}
+
+// Test that dry run mode tags metrics instead of dropping them
+func TestDryRun(t *testing.T) {
+	r := NewRestriction()
+	r.Config = "test/patterns.xml"
+	r.DryRun = true
+
+	processed := r.Apply(
+		newMetricWith("gm_secret", map[string]string{}, map[string]interface{}{"value": 1}),
+		newMetricWith("gm_example", map[string]string{}, map[string]interface{}{"value": 1, "password": "x"}),
+		newMetricWith("example", map[string]string{}, map[string]interface{}{"value": 1}),
+		newMetricWith("cpu", map[string]string{"cpu": "cpu-total"}, map[string]interface{}{"value": 1}),
+	)
+	assert.Equal(t, 4, len(processed))
+
+	assert.Equal(t, map[string]string{
+		"blacklist_decision": "drop",
+		"blacklist_rule":     "blacklist table gm_secret",
+		"blacklist_owner":    "Security",
+	}, processed[0].Tags())
+
+	// Columns are not removed, only reported
+	assert.Equal(t, map[string]string{
+		"blacklist_decision": "pass",
+		"blacklist_rule":     "whitelist table gm_*",
+		"blacklist_owner":    "Monitoring",
+		"blacklist_columns":  "password",
+	}, processed[1].Tags())
+	assert.True(t, processed[1].HasField("password"))
+
+	assert.Equal(t, map[string]string{
+		"blacklist_decision": "drop",
+		"blacklist_rule":     "not whitelisted",
+	}, processed[2].Tags())
+
+	assert.Equal(t, map[string]string{
+		"cpu":                "cpu-total",
+		"blacklist_decision": "drop",
+		"blacklist_rule":     "blacklist tag cpu=cpu-total",
+		"blacklist_owner":    "Security",
+	}, processed[3].Tags())
+}
+
+// Test the passed and dropped counters of each owner
+func TestOwnerStats(t *testing.T) {
+	// The stats are registered per config, so use a path no other test uses
+	dir, err := ioutil.TempDir("", "blacklist")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	content, err := ioutil.ReadFile("test/patterns.xml")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "patterns.xml")
+	writeRestriction(t, path, string(content))
+
+	r := NewRestriction()
+	r.Config = path
+
+	fields := map[string]interface{}{"value": 1}
+	r.Apply(
+		newMetricWith("gm_example", map[string]string{}, fields),
+		newMetricWith("gm_other", map[string]string{}, fields),
+		newMetricWith("gm_secret", map[string]string{}, fields),
+		newMetricWith("example", map[string]string{}, fields),
+	)
+
+	assert.Equal(t, int64(2), r.ownerStats["Monitoring"][0].Get())
+	assert.Equal(t, int64(0), r.ownerStats["Monitoring"][1].Get())
+	assert.Equal(t, int64(0), r.ownerStats["Security"][0].Get())
+	assert.Equal(t, int64(1), r.ownerStats["Security"][1].Get())
+	assert.Equal(t, int64(1), r.ownerStats["none"][1].Get())
+}
+
+// Test evaluating a restriction file against a list of tables
+func TestRestrictionTest(t *testing.T) {
+	r := NewRestriction()
+	r.Config = "test/patterns.xml"
+
+	var buf bytes.Buffer
+	err := r.Test(&buf, []string{"gm_example", "gm_secret", "example"})
+	assert.NoError(t, err)
+	expected := "" +
+		"TABLE       DECISION  RULE                       OWNER\n" +
+		"gm_example  pass      whitelist table gm_*       Monitoring\n" +
+		"gm_secret   drop      blacklist table gm_secret  Security\n" +
+		"example     drop      not whitelisted            -\n"
+	assert.Equal(t, expected, buf.String())
+
+	r.Config = "test/does_not_exist.xml"
+	assert.Error(t, r.Test(&buf, []string{"gm_example"}))
+}
+
+// Test the restriction test is registered for the command line
+func TestRestrictionTester(t *testing.T) {
+	name, ok := processors.Testers["restriction"]
+	assert.True(t, ok)
+	tester, ok := processors.Processors[name]().(processors.Tester)
+	assert.True(t, ok)
+
+	var buf bytes.Buffer
+	assert.NoError(t, tester.TestFile(&buf, "test/patterns.xml", []string{"gm_secret"}))
+	assert.Contains(t, buf.String(), "blacklist table gm_secret")
+}
@@ -0,0 +1,121 @@
+package blacklist
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/influxdata/telegraf/filter"
+)
+
+// hostIdentity describes the machine the restrictions are evaluated on
+type hostIdentity struct {
+	Network  string
+	Region   string
+	Hostname string
+}
+
+// identity resolves the host identity from the configured sources. The
+// network comes from the first source that is set: the network option, the
+// environment variable named by network_env, then the network_file.
+func (r *Restriction) identity() hostIdentity {
+	id := hostIdentity{
+		Network: r.Network,
+		Region:  r.Region,
+	}
+	if id.Network == "" && r.NetworkEnv != "" {
+		id.Network = os.Getenv(r.NetworkEnv)
+	}
+	if (id.Network == "" || id.Region == "") && r.NetworkFile != "" {
+		values, err := readIdentityFile(r.NetworkFile)
+		if err != nil {
+			log.Printf("E! [processors.blacklist] unable to read network file %s: %v\n", r.NetworkFile, err)
+		}
+		if id.Network == "" {
+			id.Network = values["network"]
+		}
+		if id.Region == "" {
+			id.Region = values["region"]
+		}
+	}
+	id.Network = strings.ToLower(strings.TrimSpace(id.Network))
+	id.Region = strings.ToLower(strings.TrimSpace(id.Region))
+
+	id.Hostname = r.Hostname
+	if id.Hostname == "" {
+		id.Hostname, _ = os.Hostname()
+	}
+	id.Hostname = strings.ToLower(id.Hostname)
+	return id
+}
+
+// identityConfigured reports whether any attribute of the host identity could
+// be resolved. The hostname is only missing when the OS does not report it.
+func identityConfigured(id hostIdentity) bool {
+	return id.Network != "" || id.Region != "" || id.Hostname != ""
+}
+
+// readIdentityFile parses a staticinfo style file. Lines are either
+// "key = value" pairs, or a bare value which is taken as the network name.
+// Empty lines and lines starting with '#' are ignored.
+func readIdentityFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 1 {
+			if _, ok := values["network"]; !ok {
+				values["network"] = parts[0]
+			}
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		values[key] = strings.TrimSpace(parts[1])
+	}
+	return values, scanner.Err()
+}
+
+// matches reports whether a single criteria element matches the host. Every
+// attribute set on the element must match; unset attributes match anything.
+func (c Criteria) matches(id hostIdentity) bool {
+	return matchAttr(c.Network, id.Network) &&
+		matchAttr(c.Region, id.Region) &&
+		matchAttr(c.Hostname, id.Hostname)
+}
+
+// resolvable reports whether any attribute set on the criteria element has a
+// value for the host, so that the element can be evaluated at all.
+func (c Criteria) resolvable(id hostIdentity) bool {
+	return (c.Network != "" && id.Network != "") ||
+		(c.Region != "" && id.Region != "") ||
+		(c.Hostname != "" && id.Hostname != "")
+}
+
+// matchAttr compares a criteria attribute, which may be a glob, against the
+// host value.
+func matchAttr(pattern string, value string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return true
+	}
+	if value == "" {
+		return false
+	}
+	f, err := filter.Compile([]string{pattern})
+	if err != nil {
+		log.Printf("E! [processors.blacklist] invalid criteria pattern %q: %v\n", pattern, err)
+		return false
+	}
+	return f.Match(value)
+}
@@ -0,0 +1,277 @@
+package blacklist
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/filter"
+)
+
+// Table selects tables by name. The tablename may contain globs, and regex
+// takes a regular expression instead.
+type Table struct {
+	Name  string `xml:"tablename,attr"`
+	Regex string `xml:"regex,attr"`
+}
+
+// Column removes the fields and tags whose key matches name from the
+// selected tables. Without a tablename the rule applies to every table.
+type Column struct {
+	Table string `xml:"tablename,attr"`
+	Name  string `xml:"name,attr"`
+}
+
+// Tag drops the metrics of the selected tables which have the tag key set to
+// a value matching value. Without a value any value of the tag matches, and
+// without a tablename the rule applies to every table.
+type Tag struct {
+	Table string `xml:"tablename,attr"`
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// ruleSet holds the compiled restrictions of all applicable groups. A ruleSet
+// is never modified once it is in use.
+type ruleSet struct {
+	passAll bool
+
+	// blacklist and whitelist map literal table names to the owning group
+	blacklist         map[string]string
+	whitelist         map[string]string
+	blacklistPatterns []tableRule
+	whitelistPatterns []tableRule
+	columnRules       []columnRule
+	tagRules          []tagRule
+}
+
+type tableRule struct {
+	owner   string
+	pattern string
+	tables  filter.Filter
+}
+
+type columnRule struct {
+	owner  string
+	name   string
+	tables filter.Filter
+	keys   filter.Filter
+}
+
+type tagRule struct {
+	owner  string
+	key    string
+	value  string
+	tables filter.Filter
+	values filter.Filter
+}
+
+// decision describes how the restrictions apply to a metric
+type decision struct {
+	drop  bool
+	rule  string
+	owner string
+	// columns lists the fields and tags removed by column rules
+	columns []string
+}
+
+func (d decision) String() string {
+	if d.drop {
+		return "drop"
+	}
+	return "pass"
+}
+
+// regexFilter adapts a regular expression to the filter.Filter interface
+type regexFilter struct {
+	re *regexp.Regexp
+}
+
+func (f *regexFilter) Match(s string) bool {
+	return f.re.MatchString(s)
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// compileTable returns the table name when it is a literal, or a rule when
+// it is a glob or regular expression.
+func compileTable(owner string, t Table) (string, *tableRule, error) {
+	if t.Regex != "" {
+		re, err := regexp.Compile(t.Regex)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid table regex %q: %v", t.Regex, err)
+		}
+		return "", &tableRule{owner: owner, pattern: t.Regex, tables: &regexFilter{re: re}}, nil
+	}
+	name := normalize(t.Name)
+	if name == "" {
+		return "", nil, fmt.Errorf("table rule without tablename or regex")
+	}
+	if !isPattern(name) {
+		return name, nil, nil
+	}
+	f, err := filter.Compile([]string{name})
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid table pattern %q: %v", name, err)
+	}
+	return "", &tableRule{owner: owner, pattern: name, tables: f}, nil
+}
+
+// compileTableFilter compiles an optional tablename attribute. An empty
+// attribute results in a nil filter, which matches every table.
+func compileTableFilter(name string) (filter.Filter, error) {
+	name = normalize(name)
+	if name == "" {
+		return nil, nil
+	}
+	return filter.Compile([]string{name})
+}
+
+func compileColumn(owner string, c Column) (columnRule, error) {
+	tables, err := compileTableFilter(c.Table)
+	if err != nil {
+		return columnRule{}, fmt.Errorf("invalid column tablename %q: %v", c.Table, err)
+	}
+	name := strings.TrimSpace(c.Name)
+	if name == "" {
+		return columnRule{}, fmt.Errorf("column rule without name")
+	}
+	keys, err := filter.Compile([]string{name})
+	if err != nil {
+		return columnRule{}, fmt.Errorf("invalid column name %q: %v", name, err)
+	}
+	return columnRule{owner: owner, name: name, tables: tables, keys: keys}, nil
+}
+
+func compileTag(owner string, t Tag) (tagRule, error) {
+	tables, err := compileTableFilter(t.Table)
+	if err != nil {
+		return tagRule{}, fmt.Errorf("invalid tag tablename %q: %v", t.Table, err)
+	}
+	key := strings.TrimSpace(t.Key)
+	if key == "" {
+		return tagRule{}, fmt.Errorf("tag rule without key")
+	}
+	value := t.Value
+	if value == "" {
+		value = "*"
+	}
+	values, err := filter.Compile([]string{value})
+	if err != nil {
+		return tagRule{}, fmt.Errorf("invalid tag value %q: %v", t.Value, err)
+	}
+	return tagRule{owner: owner, key: key, value: value, tables: tables, values: values}, nil
+}
+
+func (c *columnRule) appliesTo(name string) bool {
+	return c.tables == nil || c.tables.Match(name)
+}
+
+func (t *tagRule) appliesTo(name string) bool {
+	return t.tables == nil || t.tables.Match(name)
+}
+
+// matches reports whether the metric carries the tag with a matching value
+func (t *tagRule) matches(metric telegraf.Metric) bool {
+	value, ok := metric.GetTag(t.key)
+	if !ok {
+		return false
+	}
+	return t.values.Match(value)
+}
+
+func matchTable(rules []tableRule, name string) *tableRule {
+	for i := range rules {
+		if rules[i].tables.Match(name) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// evaluate decides whether the metric is kept. Unless dryRun is set, the
+// blacklisted columns are removed from the metric.
+func (rs *ruleSet) evaluate(metric telegraf.Metric, dryRun bool) decision {
+	if rs.passAll {
+		return decision{rule: "pass_all"}
+	}
+
+	// Blacklisting takes precedence over whitelisting, across all groups.
+	name := metric.Name()
+	if owner, ok := rs.blacklist[name]; ok {
+		return decision{drop: true, rule: "blacklist table " + name, owner: owner}
+	}
+	if rule := matchTable(rs.blacklistPatterns, name); rule != nil {
+		return decision{drop: true, rule: "blacklist table " + rule.pattern, owner: rule.owner}
+	}
+
+	var d decision
+	if owner, ok := rs.whitelist[name]; ok {
+		d = decision{rule: "whitelist table " + name, owner: owner}
+	} else if rule := matchTable(rs.whitelistPatterns, name); rule != nil {
+		d = decision{rule: "whitelist table " + rule.pattern, owner: rule.owner}
+	} else {
+		return decision{drop: true, rule: "not whitelisted"}
+	}
+
+	for i := range rs.tagRules {
+		rule := &rs.tagRules[i]
+		if rule.appliesTo(name) && rule.matches(metric) {
+			return decision{
+				drop:  true,
+				rule:  "blacklist tag " + rule.key + "=" + rule.value,
+				owner: rule.owner,
+			}
+		}
+	}
+
+	if len(rs.columnRules) == 0 {
+		return d
+	}
+	removedTags := make(map[string]bool)
+	removedFields := make(map[string]bool)
+	fields := len(metric.FieldList())
+	for i := range rs.columnRules {
+		rule := &rs.columnRules[i]
+		if !rule.appliesTo(name) {
+			continue
+		}
+		matched := false
+		for _, tag := range metric.TagList() {
+			if rule.keys.Match(tag.Key) && !removedTags[tag.Key] {
+				removedTags[tag.Key] = true
+				d.columns = append(d.columns, tag.Key)
+				matched = true
+			}
+		}
+		for _, field := range metric.FieldList() {
+			if rule.keys.Match(field.Key) && !removedFields[field.Key] {
+				removedFields[field.Key] = true
+				d.columns = append(d.columns, field.Key)
+				fields--
+				matched = true
+			}
+		}
+		if matched && fields == 0 {
+			// Nothing is left of the metric once its columns are removed
+			d = decision{drop: true, rule: "blacklist column " + rule.name, owner: rule.owner, columns: d.columns}
+			break
+		}
+	}
+	if !dryRun && !d.drop {
+		for key := range removedTags {
+			metric.RemoveTag(key)
+		}
+		for key := range removedFields {
+			metric.RemoveField(key)
+		}
+	}
+	return d
+}
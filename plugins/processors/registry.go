@@ -1,6 +1,10 @@
 package processors
 
-import "github.com/influxdata/telegraf"
+import (
+	"io"
+
+	"github.com/influxdata/telegraf"
+)
 
 type Creator func() telegraf.Processor
 
@@ -9,3 +13,20 @@ var Processors = map[string]Creator{}
 func Add(name string, creator Creator) {
 	Processors[name] = creator
 }
+
+// Tester is implemented by the processors which can evaluate a file of rules
+// against inputs given on the command line.
+type Tester interface {
+	// TestFile writes the result of the rules of the file for each input.
+	TestFile(w io.Writer, path string, inputs []string) error
+}
+
+// Testers maps the name of a test, run with the --test-<name> flag, to the
+// processor performing it.
+var Testers = map[string]string{}
+
+// AddTester registers the processor, which must implement Tester, as
+// performing the named test.
+func AddTester(test string, processor string) {
+	Testers[test] = processor
+}
@@ -38,7 +38,8 @@ type Serializer interface {
 // Config is a struct that covers the data types needed for all serializer types,
 // and can be used to instantiate _any_ of the serializers.
 type Config struct {
-	// Dataformat can be one of: influx, graphite, tableprov_csv, or json
+	// Dataformat can be one of: influx, graphite, tableprov_csv,
+	// tableprov_csv_text, or json
 	DataFormat string
 
 	// Support tags in graphite protocol
@@ -74,6 +75,8 @@ func NewSerializer(config *Config) (Serializer, error) {
 		serializer, err = NewTableprovCSVSelfDelimitingSerializer()
 	case "tableprov_csv":
 		serializer, err = NewTableprovCSVSerializer()
+	case "tableprov_csv_text":
+		serializer, err = NewTableprovCSVTextSerializer()
 	case "influx":
 		serializer, err = NewInfluxSerializerConfig(config)
 	case "graphite":
@@ -131,3 +134,11 @@ func NewTableprovCSVSerializer() (Serializer, error) {
 		HostIP: utils.GetIP(),
 	}, nil
 }
+
+func NewTableprovCSVTextSerializer() (Serializer, error) {
+	return &tableprov_csv.TableprovCSVTextSerializer{
+		TableprovCSVSerializer: tableprov_csv.TableprovCSVSerializer{
+			HostIP: utils.GetIP(),
+		},
+	}, nil
+}
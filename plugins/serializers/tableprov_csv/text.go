@@ -0,0 +1,51 @@
+package tableprov_csv
+
+import (
+	"bytes"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/influxdata/telegraf"
+	ingestPb "goblin.dde.akamai.com/generated/grpc/goblin_ingest"
+)
+
+// TableprovCSVTextSerializer serializes the metrics as the
+// TableprovCSVSerializer, then decodes the chunks to text for humans to read:
+// the chunk header in the protobuf text format followed by the csv data.
+type TableprovCSVTextSerializer struct {
+	TableprovCSVSerializer
+}
+
+func (s *TableprovCSVTextSerializer) SerializeBatch(metrics []telegraf.Metric) ([]byte, error) {
+	var batch bytes.Buffer
+	for _, m := range metrics {
+		buf, err := s.Serialize(m)
+		if err != nil {
+			return nil, err
+		}
+		batch.Write(buf)
+	}
+	return batch.Bytes(), nil
+}
+
+func (s *TableprovCSVTextSerializer) Serialize(m telegraf.Metric) ([]byte, error) {
+	serialized, err := s.TableprovCSVSerializer.Serialize(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var publishChunk ingestPb.PublishTableChunk
+	if err := proto.Unmarshal(serialized, &publishChunk); err != nil {
+		return nil, err
+	}
+	data := publishChunk.Chunk.Data
+	publishChunk.Chunk.Data = nil
+
+	var b bytes.Buffer
+	b.WriteString(proto.CompactTextString(&publishChunk))
+	b.WriteString("\n")
+	b.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		b.WriteString("\n")
+	}
+	return b.Bytes(), nil
+}
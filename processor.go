@@ -10,3 +10,16 @@ type Processor interface {
 	// Apply the filter to the given metric.
 	Apply(in ...Metric) []Metric
 }
+
+// ServiceProcessor is a processor running a background service, such as the
+// reloading of its configuration, while the agent runs.
+type ServiceProcessor interface {
+	Processor
+
+	// Start the service of the processor, before it is applied to the
+	// metrics of the running agent.
+	Start() error
+
+	// Stop the service once the processor is no longer applied.
+	Stop()
+}